@@ -3,8 +3,8 @@ package api
 import (
 	"context"
 	"fmt"
+	"time"
 
-	sectorbuilder "github.com/filecoin-project/go-sectorbuilder"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-filestore"
 	cbor "github.com/ipfs/go-ipld-cbor"
@@ -15,10 +15,12 @@ import (
 	"github.com/filecoin-project/lotus/chain/address"
 	"github.com/filecoin-project/lotus/chain/store"
 	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/sectorbuilder"
 )
 
 func init() {
 	cbor.RegisterCborType(SealedRef{})
+	cbor.RegisterCborType(PaymentInfo{})
 }
 
 type Common interface {
@@ -101,12 +103,36 @@ type FullNode interface {
 
 	// ClientImport imports file under the specified path into filestore
 	ClientImport(ctx context.Context, path string) (cid.Cid, error)
-	ClientStartDeal(ctx context.Context, data cid.Cid, miner address.Address, price types.BigInt, blocksDuration uint64) (*cid.Cid, error)
+	// ClientImportCar imports blocks from a CAR file with a single root, for
+	// making deals in IPLD mode
+	ClientImportCar(ctx context.Context, path string) (cid.Cid, error)
+	ClientStartDeal(ctx context.Context, data cid.Cid, mode SerializationMode, miner address.Address, price types.BigInt, blocksDuration uint64) (*cid.Cid, error)
+	// ClientStartOfflineDeal proposes a deal with data transferred to the
+	// miner out of band. CommP is computed from local data, the miner imports
+	// the data with MarketImportDealData
+	ClientStartOfflineDeal(ctx context.Context, data cid.Cid, mode SerializationMode, miner address.Address, price types.BigInt, blocksDuration uint64) (*cid.Cid, error)
+	// ClientStartDeals proposes deals storing data with `replicas` miners
+	// picked by ask price, power and outcomes of previous deals. maxPrice is
+	// the highest accepted ask price, per byte per block
+	ClientStartDeals(ctx context.Context, data cid.Cid, mode SerializationMode, replicas int, maxPrice types.BigInt, blocksDuration uint64) ([]DealPlacement, error)
 	ClientListDeals(ctx context.Context) ([]DealInfo, error)
+	ClientGetDealInfo(ctx context.Context, proposalCid cid.Cid) (*DealInfo, error)
+	// ClientGetDealUpdates returns a channel receiving deals each time their
+	// state changes
+	ClientGetDealUpdates(ctx context.Context) (<-chan DealInfo, error)
 	ClientHasLocal(ctx context.Context, root cid.Cid) (bool, error)
 	ClientFindData(ctx context.Context, root cid.Cid) ([]QueryOffer, error) // TODO: specify serialization mode we want (defaults to unixfs for now)
 	ClientRetrieve(ctx context.Context, order RetrievalOrder, path string) error
+	// ClientRetrieveMulti retrieves data from up to parallel miners at once,
+	// falling back to the other orders when a miner fails or stalls. Orders
+	// must be for the same data and range, and are tried in order
+	ClientRetrieveMulti(ctx context.Context, orders []RetrievalOrder, parallel int, path string) error
+	// ClientRetrieveSelector retrieves a sub-DAG of the order root into a CAR
+	// file at path
+	ClientRetrieveSelector(ctx context.Context, order RetrievalOrder, sel RetrievalSelector, path string) error
 	ClientQueryAsk(ctx context.Context, p peer.ID, miner address.Address) (*types.SignedStorageAsk, error)
+	// ClientListRetrievals lists retrieval deals made by the client
+	ClientListRetrievals(ctx context.Context) ([]RetrievalDeal, error)
 
 	// ClientUnimport removes references to the specified file from filestore
 	//ClientUnimport(path string)
@@ -137,6 +163,9 @@ type FullNode interface {
 	PaychList(context.Context) ([]address.Address, error)
 	PaychStatus(context.Context, address.Address) (*PaychStatus, error)
 	PaychClose(context.Context, address.Address) (cid.Cid, error)
+	PaychAddFunds(ctx context.Context, ch address.Address, amt types.BigInt) (cid.Cid, error)
+	PaychSettle(context.Context, address.Address) (cid.Cid, error)
+	PaychCollect(context.Context, address.Address) (cid.Cid, error)
 	PaychAllocateLane(ctx context.Context, ch address.Address) (uint64, error)
 	PaychNewPayment(ctx context.Context, from, to address.Address, vouchers []VoucherSpec) (*PaymentInfo, error)
 	PaychVoucherCheckValid(context.Context, address.Address, *types.SignedVoucher) error
@@ -145,6 +174,7 @@ type FullNode interface {
 	PaychVoucherAdd(context.Context, address.Address, *types.SignedVoucher, []byte, types.BigInt) (types.BigInt, error)
 	PaychVoucherList(context.Context, address.Address) ([]*types.SignedVoucher, error)
 	PaychVoucherSubmit(context.Context, address.Address, *types.SignedVoucher) (cid.Cid, error)
+	PaychSettleUpdates(context.Context) (<-chan PaychSettleUpdate, error)
 }
 
 // StorageMiner is a low-level interface to the Filecoin network storage miner node
@@ -157,15 +187,48 @@ type StorageMiner interface {
 	StoreGarbageData(context.Context) (uint64, error)
 
 	// Get the status of a given sector by ID
-	SectorsStatus(context.Context, uint64) (sectorbuilder.SectorSealingStatus, error)
+	SectorsStatus(context.Context, uint64) (SectorStatus, error)
 
-	// List all staged sectors
+	// List all sectors tracked by the miner
 	SectorsList(context.Context) ([]uint64, error)
 
 	// Seal all staged sectors
 	SectorsStagedSeal(context.Context) error
 
+	// Retry the step a sector failed at
+	SectorsRetry(context.Context, uint64) error
+
 	SectorsRefs(context.Context) (map[string][]SealedRef, error)
+
+	// StorageList lists paths sealed sectors can be stored in
+	StorageList(context.Context) ([]sectorbuilder.StoragePathInfo, error)
+
+	// StorageAttach adds a path sealed sectors can be stored in
+	StorageAttach(context.Context, sectorbuilder.StoragePath) error
+
+	// MarketSetAsk sets the price (per byte per block) and minimum piece
+	// size for storage deals. The ask is valid for ttlsecs seconds, and is
+	// renewed automatically
+	MarketSetAsk(ctx context.Context, price types.BigInt, minPieceSize uint64, ttlsecs int64) error
+	MarketGetAsk(ctx context.Context) (*types.SignedStorageAsk, error)
+
+	// MarketImportDealData imports data of a manual transfer deal from a
+	// file on the miner machine
+	MarketImportDealData(ctx context.Context, proposalCid cid.Cid, path string) error
+
+	MarketListIncomingDeals(ctx context.Context) ([]MarketDeal, error)
+	MarketGetDeal(ctx context.Context, proposalCid cid.Cid) (*MarketDeal, error)
+	// MarketRejectDeal rejects a deal whose data wasn't staged yet, the client
+	// is notified with the reason
+	MarketRejectDeal(ctx context.Context, proposalCid cid.Cid, reason string) error
+	// MarketListRetrievalDeals lists retrieval deals served by the miner
+	MarketListRetrievalDeals(ctx context.Context) ([]RetrievalDeal, error)
+
+	// UnsealedList lists pieces in the unsealed piece cache
+	UnsealedList(ctx context.Context) (UnsealedCacheInfo, error)
+	// UnsealedGC removes least recently used pieces from the unsealed piece
+	// cache until it's at most keep bytes
+	UnsealedGC(ctx context.Context, keep uint64) (UnsealedGCResult, error)
 }
 
 // Version provides various build-time information
@@ -204,6 +267,81 @@ type DealInfo struct {
 
 	TotalPrice types.BigInt
 	Duration   uint64
+
+	SerializationMode SerializationMode
+	ManualTransfer    bool
+
+	// Message is the last message from the miner, or the reason the deal
+	// failed
+	Message string
+
+	// Set when State >= DealSealing
+	CommD               []byte
+	PieceInclusionProof []byte
+
+	// Set when State >= DealComplete
+	SectorCommitMessage *cid.Cid
+
+	// ReplacedBy is the deal renewing this one
+	ReplacedBy *cid.Cid
+}
+
+// DealPlacement is the outcome of proposing a deal to one of the miners
+// picked by ClientStartDeals
+type DealPlacement struct {
+	Miner address.Address
+	// Price is the ask price of the miner, per byte per block
+	Price types.BigInt
+	Power types.BigInt
+
+	// Deal is set when the deal was proposed
+	Deal *cid.Cid
+	// Err is set when proposing the deal failed
+	Err string
+}
+
+// MarketDeal is a storage deal as seen by the miner
+type MarketDeal struct {
+	ProposalCid cid.Cid
+	State       DealState
+	// Message explains why the deal was rejected or failed
+	Message string
+
+	Client        peer.ID
+	ClientAddress address.Address
+
+	PieceRef          cid.Cid
+	SerializationMode SerializationMode
+	ManualTransfer    bool
+	CommP             []byte
+	Size              uint64
+
+	TotalPrice types.BigInt
+	Duration   uint64
+
+	SectorID uint64 // Set when State >= DealStaged
+}
+
+// RetrievalDeal is a record of a retrieval deal stream, kept by both sides
+type RetrievalDeal struct {
+	// ID is the CID of the first deal proposal sent on the stream
+	ID    cid.Cid
+	State RetrievalState
+	// Err explains why the retrieval failed
+	Err string
+
+	Root cid.Cid
+	// Peer is the other side of the deal
+	Peer peer.ID
+
+	Paych address.Address
+	Lane  uint64
+
+	// Transferred is the number of bytes of retrieved data sent
+	Transferred uint64
+	// Paid is the sum of vouchers sent for the deal, including the unseal
+	// price
+	Paid types.BigInt
 }
 
 type MsgWait struct {
@@ -229,6 +367,32 @@ type SectorInfo struct {
 	CommR    []byte
 }
 
+type SectorLog struct {
+	Timestamp uint64 // unix seconds
+	State     SectorState
+	Message   string
+}
+
+type SectorStatus struct {
+	SectorID uint64
+	State    SectorState
+
+	// set when State is SectorFailed
+	FailedAt SectorState
+	LastErr  string
+
+	Pieces []string
+
+	CommD     []byte
+	CommR     []byte
+	CommRStar []byte
+	Proof     []byte
+
+	CommitMessage *cid.Cid
+
+	Log []SectorLog
+}
+
 type ActorState struct {
 	Balance types.BigInt
 	State   interface{}
@@ -247,6 +411,20 @@ type PaychStatus struct {
 	Direction   PCHDir
 }
 
+// PaychSettleUpdate is sent when an inbound channel closed by the payer
+// progresses through settlement
+type PaychSettleUpdate struct {
+	Channel   address.Address
+	State     PaychSettleState
+	ClosingAt uint64
+
+	Lane    uint64       // voucher lane, set for VoucherSubmitted
+	Amount  types.BigInt // voucher amount, or amount collected
+	Message *cid.Cid     // message submitting the voucher or collecting funds
+
+	Err string
+}
+
 type ChannelInfo struct {
 	Channel        address.Address
 	ChannelMessage cid.Cid
@@ -277,13 +455,43 @@ type SealedRef struct {
 	Size   uint32
 }
 
+// UnsealedPiece is a piece kept in the unsealed piece cache
+type UnsealedPiece struct {
+	Key      string
+	Size     uint64
+	LastUsed time.Time
+}
+
+type UnsealedCacheInfo struct {
+	Size    uint64
+	MaxSize uint64
+
+	// Hits and Misses count reads from the cache, misses unseal the piece
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+
+	// Pieces are sorted by last use, most recent first
+	Pieces []UnsealedPiece
+}
+
+type UnsealedGCResult struct {
+	Removed int
+	Freed   uint64
+}
+
 type QueryOffer struct {
 	Err string
 
 	Root cid.Cid
 
-	Size     uint64
-	MinPrice types.BigInt
+	Size          uint64
+	Serialization SerializationMode
+	MinPrice      types.BigInt
+	// UnsealPrice is paid upfront when the data has to be unsealed
+	UnsealPrice types.BigInt
+	// PaymentInterval is the most bytes the miner sends per payment
+	PaymentInterval uint64
 
 	Miner       address.Address
 	MinerPeerID peer.ID
@@ -291,9 +499,13 @@ type QueryOffer struct {
 
 func (o *QueryOffer) Order() RetrievalOrder {
 	return RetrievalOrder{
-		Root:  o.Root,
-		Size:  o.Size,
-		Total: o.MinPrice,
+		Root:          o.Root,
+		Size:          o.Size,
+		Serialization: o.Serialization,
+		Total:         o.MinPrice,
+
+		UnsealPrice:     o.UnsealPrice,
+		PaymentInterval: o.PaymentInterval,
 
 		Miner:       o.Miner,
 		MinerPeerID: o.MinerPeerID,
@@ -301,17 +513,34 @@ func (o *QueryOffer) Order() RetrievalOrder {
 }
 
 type RetrievalOrder struct {
-	// TODO: make this less unixfs specific
 	Root cid.Cid
 	Size uint64
-	// TODO: support offset
+	// Serialization the data was stored with, defaults to UnixFs
+	Serialization SerializationMode
+	// Offset and Length select a byte range of UnixFs data to retrieve. Zero
+	// Length retrieves the data until the end
+	Offset uint64
+	Length uint64
+	// Total is the price of the whole data, ranges are paid proportionally
 	Total types.BigInt
 
+	UnsealPrice     types.BigInt
+	PaymentInterval uint64
+
 	Client      address.Address
 	Miner       address.Address
 	MinerPeerID peer.ID
 }
 
+// RetrievalSelector selects a sub-DAG to retrieve
+type RetrievalSelector struct {
+	// Path from the root to the selected block, segments separated by '/'
+	Path string
+	// Depth is the number of links followed from the selected block, -1
+	// follows all links
+	Depth int64
+}
+
 type ReplayResults struct {
 	Msg     *types.Message
 	Receipt *types.MessageReceipt
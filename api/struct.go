@@ -3,7 +3,6 @@ package api
 import (
 	"context"
 
-	sectorbuilder "github.com/filecoin-project/go-sectorbuilder"
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -11,6 +10,7 @@ import (
 	"github.com/filecoin-project/lotus/chain/address"
 	"github.com/filecoin-project/lotus/chain/store"
 	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/sectorbuilder"
 )
 
 // All permissions are listed in permissioned.go
@@ -76,14 +76,22 @@ type FullNodeStruct struct {
 
 		MpoolGetNonce func(context.Context, address.Address) (uint64, error) `perm:"read"`
 
-		ClientImport      func(ctx context.Context, path string) (cid.Cid, error)                                                                     `perm:"admin"`
-		ClientListImports func(ctx context.Context) ([]Import, error)                                                                                 `perm:"write"`
-		ClientHasLocal    func(ctx context.Context, root cid.Cid) (bool, error)                                                                       `perm:"write"`
-		ClientFindData    func(ctx context.Context, root cid.Cid) ([]QueryOffer, error)                                                               `perm:"read"`
-		ClientStartDeal   func(ctx context.Context, data cid.Cid, miner address.Address, price types.BigInt, blocksDuration uint64) (*cid.Cid, error) `perm:"admin"`
-		ClientListDeals   func(ctx context.Context) ([]DealInfo, error)                                                                               `perm:"write"`
-		ClientRetrieve    func(ctx context.Context, order RetrievalOrder, path string) error                                                          `perm:"admin"`
-		ClientQueryAsk    func(ctx context.Context, p peer.ID, miner address.Address) (*types.SignedStorageAsk, error)                                `perm:"read"`
+		ClientImport           func(ctx context.Context, path string) (cid.Cid, error)                                                                                              `perm:"admin"`
+		ClientListImports      func(ctx context.Context) ([]Import, error)                                                                                                          `perm:"write"`
+		ClientHasLocal         func(ctx context.Context, root cid.Cid) (bool, error)                                                                                                `perm:"write"`
+		ClientFindData         func(ctx context.Context, root cid.Cid) ([]QueryOffer, error)                                                                                        `perm:"read"`
+		ClientImportCar        func(ctx context.Context, path string) (cid.Cid, error)                                                                                              `perm:"admin"`
+		ClientStartDeal        func(ctx context.Context, data cid.Cid, mode SerializationMode, miner address.Address, price types.BigInt, blocksDuration uint64) (*cid.Cid, error)  `perm:"admin"`
+		ClientStartOfflineDeal func(ctx context.Context, data cid.Cid, mode SerializationMode, miner address.Address, price types.BigInt, blocksDuration uint64) (*cid.Cid, error)  `perm:"admin"`
+		ClientStartDeals       func(ctx context.Context, data cid.Cid, mode SerializationMode, replicas int, maxPrice types.BigInt, blocksDuration uint64) ([]DealPlacement, error) `perm:"admin"`
+		ClientListRetrievals   func(ctx context.Context) ([]RetrievalDeal, error)                                                                                                   `perm:"write"`
+		ClientListDeals        func(ctx context.Context) ([]DealInfo, error)                                                                                                        `perm:"write"`
+		ClientGetDealInfo      func(ctx context.Context, proposalCid cid.Cid) (*DealInfo, error)                                                                                    `perm:"read"`
+		ClientGetDealUpdates   func(ctx context.Context) (<-chan DealInfo, error)                                                                                                   `perm:"read"`
+		ClientRetrieve         func(ctx context.Context, order RetrievalOrder, path string) error                                                                                   `perm:"admin"`
+		ClientRetrieveMulti    func(ctx context.Context, orders []RetrievalOrder, parallel int, path string) error                                                                  `perm:"admin"`
+		ClientRetrieveSelector func(ctx context.Context, order RetrievalOrder, sel RetrievalSelector, path string) error                                                            `perm:"admin"`
+		ClientQueryAsk         func(ctx context.Context, p peer.ID, miner address.Address) (*types.SignedStorageAsk, error)                                                         `perm:"read"`
 
 		StateMinerSectors          func(context.Context, address.Address) ([]*SectorInfo, error)                       `perm:"read"`
 		StateMinerProvingSet       func(context.Context, address.Address, *types.TipSet) ([]*SectorInfo, error)        `perm:"read"`
@@ -104,6 +112,9 @@ type FullNodeStruct struct {
 		PaychList                  func(context.Context) ([]address.Address, error)                                                         `perm:"read"`
 		PaychStatus                func(context.Context, address.Address) (*PaychStatus, error)                                             `perm:"read"`
 		PaychClose                 func(context.Context, address.Address) (cid.Cid, error)                                                  `perm:"sign"`
+		PaychAddFunds              func(ctx context.Context, ch address.Address, amt types.BigInt) (cid.Cid, error)                         `perm:"sign"`
+		PaychSettle                func(context.Context, address.Address) (cid.Cid, error)                                                  `perm:"sign"`
+		PaychCollect               func(context.Context, address.Address) (cid.Cid, error)                                                  `perm:"sign"`
 		PaychAllocateLane          func(context.Context, address.Address) (uint64, error)                                                   `perm:"sign"`
 		PaychNewPayment            func(ctx context.Context, from, to address.Address, vouchers []VoucherSpec) (*PaymentInfo, error)        `perm:"sign"`
 		PaychVoucherCheck          func(context.Context, *types.SignedVoucher) error                                                        `perm:"read"`
@@ -113,6 +124,7 @@ type FullNodeStruct struct {
 		PaychVoucherCreate         func(context.Context, address.Address, types.BigInt, uint64) (*types.SignedVoucher, error)               `perm:"sign"`
 		PaychVoucherList           func(context.Context, address.Address) ([]*types.SignedVoucher, error)                                   `perm:"write"`
 		PaychVoucherSubmit         func(context.Context, address.Address, *types.SignedVoucher) (cid.Cid, error)                            `perm:"sign"`
+		PaychSettleUpdates         func(context.Context) (<-chan PaychSettleUpdate, error)                                                  `perm:"read"`
 	}
 }
 
@@ -124,11 +136,26 @@ type StorageMinerStruct struct {
 
 		StoreGarbageData func(context.Context) (uint64, error) `perm:"write"`
 
-		SectorsStatus     func(context.Context, uint64) (sectorbuilder.SectorSealingStatus, error) `perm:"read"`
-		SectorsList       func(context.Context) ([]uint64, error)                                  `perm:"read"`
-		SectorsStagedSeal func(context.Context) error                                              `perm:"write"`
+		SectorsStatus     func(context.Context, uint64) (SectorStatus, error) `perm:"read"`
+		SectorsList       func(context.Context) ([]uint64, error)             `perm:"read"`
+		SectorsStagedSeal func(context.Context) error                         `perm:"write"`
+		SectorsRetry      func(context.Context, uint64) error                 `perm:"write"`
 
 		SectorsRefs func(context.Context) (map[string][]SealedRef, error) `perm:"read"`
+
+		StorageList   func(context.Context) ([]sectorbuilder.StoragePathInfo, error) `perm:"read"`
+		StorageAttach func(context.Context, sectorbuilder.StoragePath) error         `perm:"admin"`
+
+		MarketSetAsk             func(ctx context.Context, price types.BigInt, minPieceSize uint64, ttlsecs int64) error `perm:"admin"`
+		MarketGetAsk             func(ctx context.Context) (*types.SignedStorageAsk, error)                              `perm:"read"`
+		MarketImportDealData     func(ctx context.Context, proposalCid cid.Cid, path string) error                       `perm:"admin"`
+		MarketListIncomingDeals  func(ctx context.Context) ([]MarketDeal, error)                                         `perm:"read"`
+		MarketGetDeal            func(ctx context.Context, proposalCid cid.Cid) (*MarketDeal, error)                     `perm:"read"`
+		MarketListRetrievalDeals func(ctx context.Context) ([]RetrievalDeal, error)                                      `perm:"read"`
+		MarketRejectDeal         func(ctx context.Context, proposalCid cid.Cid, reason string) error                     `perm:"admin"`
+
+		UnsealedList func(ctx context.Context) (UnsealedCacheInfo, error)             `perm:"read"`
+		UnsealedGC   func(ctx context.Context, keep uint64) (UnsealedGCResult, error) `perm:"admin"`
 	}
 }
 
@@ -186,18 +213,50 @@ func (c *FullNodeStruct) ClientFindData(ctx context.Context, root cid.Cid) ([]Qu
 	return c.Internal.ClientFindData(ctx, root)
 }
 
-func (c *FullNodeStruct) ClientStartDeal(ctx context.Context, data cid.Cid, miner address.Address, price types.BigInt, blocksDuration uint64) (*cid.Cid, error) {
-	return c.Internal.ClientStartDeal(ctx, data, miner, price, blocksDuration)
+func (c *FullNodeStruct) ClientImportCar(ctx context.Context, path string) (cid.Cid, error) {
+	return c.Internal.ClientImportCar(ctx, path)
+}
+
+func (c *FullNodeStruct) ClientStartDeal(ctx context.Context, data cid.Cid, mode SerializationMode, miner address.Address, price types.BigInt, blocksDuration uint64) (*cid.Cid, error) {
+	return c.Internal.ClientStartDeal(ctx, data, mode, miner, price, blocksDuration)
+}
+
+func (c *FullNodeStruct) ClientStartOfflineDeal(ctx context.Context, data cid.Cid, mode SerializationMode, miner address.Address, price types.BigInt, blocksDuration uint64) (*cid.Cid, error) {
+	return c.Internal.ClientStartOfflineDeal(ctx, data, mode, miner, price, blocksDuration)
+}
+
+func (c *FullNodeStruct) ClientStartDeals(ctx context.Context, data cid.Cid, mode SerializationMode, replicas int, maxPrice types.BigInt, blocksDuration uint64) ([]DealPlacement, error) {
+	return c.Internal.ClientStartDeals(ctx, data, mode, replicas, maxPrice, blocksDuration)
 }
 
 func (c *FullNodeStruct) ClientListDeals(ctx context.Context) ([]DealInfo, error) {
 	return c.Internal.ClientListDeals(ctx)
 }
 
+func (c *FullNodeStruct) ClientListRetrievals(ctx context.Context) ([]RetrievalDeal, error) {
+	return c.Internal.ClientListRetrievals(ctx)
+}
+
+func (c *FullNodeStruct) ClientGetDealInfo(ctx context.Context, proposalCid cid.Cid) (*DealInfo, error) {
+	return c.Internal.ClientGetDealInfo(ctx, proposalCid)
+}
+
+func (c *FullNodeStruct) ClientGetDealUpdates(ctx context.Context) (<-chan DealInfo, error) {
+	return c.Internal.ClientGetDealUpdates(ctx)
+}
+
 func (c *FullNodeStruct) ClientRetrieve(ctx context.Context, order RetrievalOrder, path string) error {
 	return c.Internal.ClientRetrieve(ctx, order, path)
 }
 
+func (c *FullNodeStruct) ClientRetrieveMulti(ctx context.Context, orders []RetrievalOrder, parallel int, path string) error {
+	return c.Internal.ClientRetrieveMulti(ctx, orders, parallel, path)
+}
+
+func (c *FullNodeStruct) ClientRetrieveSelector(ctx context.Context, order RetrievalOrder, sel RetrievalSelector, path string) error {
+	return c.Internal.ClientRetrieveSelector(ctx, order, sel, path)
+}
+
 func (c *FullNodeStruct) ClientQueryAsk(ctx context.Context, p peer.ID, miner address.Address) (*types.SignedStorageAsk, error) {
 	return c.Internal.ClientQueryAsk(ctx, p, miner)
 }
@@ -424,6 +483,18 @@ func (c *FullNodeStruct) PaychClose(ctx context.Context, a address.Address) (cid
 	return c.Internal.PaychClose(ctx, a)
 }
 
+func (c *FullNodeStruct) PaychAddFunds(ctx context.Context, ch address.Address, amt types.BigInt) (cid.Cid, error) {
+	return c.Internal.PaychAddFunds(ctx, ch, amt)
+}
+
+func (c *FullNodeStruct) PaychSettle(ctx context.Context, a address.Address) (cid.Cid, error) {
+	return c.Internal.PaychSettle(ctx, a)
+}
+
+func (c *FullNodeStruct) PaychCollect(ctx context.Context, a address.Address) (cid.Cid, error) {
+	return c.Internal.PaychCollect(ctx, a)
+}
+
 func (c *FullNodeStruct) PaychAllocateLane(ctx context.Context, ch address.Address) (uint64, error) {
 	return c.Internal.PaychAllocateLane(ctx, ch)
 }
@@ -436,6 +507,10 @@ func (c *FullNodeStruct) PaychVoucherSubmit(ctx context.Context, ch address.Addr
 	return c.Internal.PaychVoucherSubmit(ctx, ch, sv)
 }
 
+func (c *FullNodeStruct) PaychSettleUpdates(ctx context.Context) (<-chan PaychSettleUpdate, error) {
+	return c.Internal.PaychSettleUpdates(ctx)
+}
+
 func (c *StorageMinerStruct) ActorAddress(ctx context.Context) (address.Address, error) {
 	return c.Internal.ActorAddress(ctx)
 }
@@ -445,11 +520,11 @@ func (c *StorageMinerStruct) StoreGarbageData(ctx context.Context) (uint64, erro
 }
 
 // Get the status of a given sector by ID
-func (c *StorageMinerStruct) SectorsStatus(ctx context.Context, sid uint64) (sectorbuilder.SectorSealingStatus, error) {
+func (c *StorageMinerStruct) SectorsStatus(ctx context.Context, sid uint64) (SectorStatus, error) {
 	return c.Internal.SectorsStatus(ctx, sid)
 }
 
-// List all staged sectors
+// List all sectors tracked by the miner
 func (c *StorageMinerStruct) SectorsList(ctx context.Context) ([]uint64, error) {
 	return c.Internal.SectorsList(ctx)
 }
@@ -459,10 +534,59 @@ func (c *StorageMinerStruct) SectorsStagedSeal(ctx context.Context) error {
 	return c.Internal.SectorsStagedSeal(ctx)
 }
 
+// Retry the step a sector failed at
+func (c *StorageMinerStruct) SectorsRetry(ctx context.Context, sid uint64) error {
+	return c.Internal.SectorsRetry(ctx, sid)
+}
+
 func (c *StorageMinerStruct) SectorsRefs(ctx context.Context) (map[string][]SealedRef, error) {
 	return c.Internal.SectorsRefs(ctx)
 }
 
+func (c *StorageMinerStruct) StorageList(ctx context.Context) ([]sectorbuilder.StoragePathInfo, error) {
+	return c.Internal.StorageList(ctx)
+}
+
+func (c *StorageMinerStruct) StorageAttach(ctx context.Context, p sectorbuilder.StoragePath) error {
+	return c.Internal.StorageAttach(ctx, p)
+}
+
+func (c *StorageMinerStruct) MarketSetAsk(ctx context.Context, price types.BigInt, minPieceSize uint64, ttlsecs int64) error {
+	return c.Internal.MarketSetAsk(ctx, price, minPieceSize, ttlsecs)
+}
+
+func (c *StorageMinerStruct) MarketGetAsk(ctx context.Context) (*types.SignedStorageAsk, error) {
+	return c.Internal.MarketGetAsk(ctx)
+}
+
+func (c *StorageMinerStruct) MarketImportDealData(ctx context.Context, proposalCid cid.Cid, path string) error {
+	return c.Internal.MarketImportDealData(ctx, proposalCid, path)
+}
+
+func (c *StorageMinerStruct) MarketListIncomingDeals(ctx context.Context) ([]MarketDeal, error) {
+	return c.Internal.MarketListIncomingDeals(ctx)
+}
+
+func (c *StorageMinerStruct) MarketGetDeal(ctx context.Context, proposalCid cid.Cid) (*MarketDeal, error) {
+	return c.Internal.MarketGetDeal(ctx, proposalCid)
+}
+
+func (c *StorageMinerStruct) MarketListRetrievalDeals(ctx context.Context) ([]RetrievalDeal, error) {
+	return c.Internal.MarketListRetrievalDeals(ctx)
+}
+
+func (c *StorageMinerStruct) MarketRejectDeal(ctx context.Context, proposalCid cid.Cid, reason string) error {
+	return c.Internal.MarketRejectDeal(ctx, proposalCid, reason)
+}
+
+func (c *StorageMinerStruct) UnsealedList(ctx context.Context) (UnsealedCacheInfo, error) {
+	return c.Internal.UnsealedList(ctx)
+}
+
+func (c *StorageMinerStruct) UnsealedGC(ctx context.Context, keep uint64) (UnsealedGCResult, error) {
+	return c.Internal.UnsealedGC(ctx, keep)
+}
+
 var _ Common = &CommonStruct{}
 var _ FullNode = &FullNodeStruct{}
 var _ StorageMiner = &StorageMinerStruct{}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 
 	ma "github.com/multiformats/go-multiaddr"
 )
@@ -23,9 +24,119 @@ const (
 	DealError // deal failed with an unexpected error
 	DealExpired
 
+	DealWaitingForData // manual transfer deal accepted, waiting for the miner to import data
+
 	DealNoUpdate = DealUnknown
 )
 
+var DealStates = []string{
+	DealUnknown:        "Unknown",
+	DealRejected:       "Rejected",
+	DealAccepted:       "Accepted",
+	DealStarted:        "Started",
+	DealFailed:         "Failed",
+	DealStaged:         "Staged",
+	DealSealing:        "Sealing",
+	DealComplete:       "Complete",
+	DealError:          "Error",
+	DealExpired:        "Expired",
+	DealWaitingForData: "WaitingForData",
+}
+
+func (s DealState) String() string {
+	if int(s) < 0 || int(s) >= len(DealStates) {
+		return fmt.Sprintf("DealState(%d)", s)
+	}
+	return DealStates[s]
+}
+
+type RetrievalState int
+
+const (
+	RetrievalUnknown = RetrievalState(iota)
+	RetrievalOngoing
+	RetrievalCompleted
+	RetrievalFailed
+)
+
+var RetrievalStates = []string{
+	RetrievalUnknown:   "Unknown",
+	RetrievalOngoing:   "Ongoing",
+	RetrievalCompleted: "Completed",
+	RetrievalFailed:    "Failed",
+}
+
+func (s RetrievalState) String() string {
+	if int(s) < 0 || int(s) >= len(RetrievalStates) {
+		return fmt.Sprintf("RetrievalState(%d)", s)
+	}
+	return RetrievalStates[s]
+}
+
+// SerializationMode specifies how data is laid out in a storage deal piece
+type SerializationMode string
+
+const (
+	// SerializationUnixFs stores the contents of a unixfs file
+	SerializationUnixFs = SerializationMode("UnixFs")
+	// SerializationRaw stores data of raw leaves of a DAG, in depth-first order
+	SerializationRaw = SerializationMode("Raw")
+	// SerializationIPLD stores all blocks of a DAG as a CAR file
+	SerializationIPLD = SerializationMode("IPLD")
+)
+
+type SectorState int
+
+const (
+	SectorUndefined  = SectorState(iota)
+	SectorPending    // accepting pieces
+	SectorPacking    // no more pieces will be added, waiting for seal to start
+	SectorSealing    // being sealed by the sectorbuilder
+	SectorCommitting // sealed, CommitSector message sent or about to be sent
+	SectorProving    // commitment landed on chain
+	SectorFailed
+)
+
+var SectorStates = []string{
+	SectorUndefined:  "Undefined",
+	SectorPending:    "Pending",
+	SectorPacking:    "Packing",
+	SectorSealing:    "Sealing",
+	SectorCommitting: "Committing",
+	SectorProving:    "Proving",
+	SectorFailed:     "Failed",
+}
+
+func (s SectorState) String() string {
+	if int(s) < 0 || int(s) >= len(SectorStates) {
+		return fmt.Sprintf("SectorState(%d)", s)
+	}
+	return SectorStates[s]
+}
+
+type PaychSettleState int
+
+const (
+	PaychSettleClosing = PaychSettleState(iota)
+	PaychSettleVoucherSubmitted
+	PaychSettleCollected
+	PaychSettleError
+)
+
+var PaychSettleStates = []string{
+	PaychSettleClosing:          "Closing",
+	PaychSettleVoucherSubmitted: "VoucherSubmitted",
+	PaychSettleCollected:        "Collected",
+	PaychSettleError:            "Error",
+}
+
+func (s PaychSettleState) String() string {
+	if int(s) < 0 || int(s) >= len(PaychSettleStates) {
+		return fmt.Sprintf("PaychSettleState(%d)", s)
+	}
+	return PaychSettleStates[s]
+}
+
 // TODO: check if this exists anywhere else
 type MultiaddrSlice []ma.Multiaddr
 
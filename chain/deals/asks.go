@@ -14,10 +14,19 @@ import (
 	"golang.org/x/xerrors"
 )
 
-func (h *Handler) SetPrice(p types.BigInt, ttlsecs int64) error {
+// askRenewCheck is how often the ask expiry is checked
+const askRenewCheck = time.Minute
+
+// SetAsk signs and publishes a new storage ask, valid for ttlsecs seconds.
+// The ask is renewed automatically before it expires
+func (h *Handler) SetAsk(price types.BigInt, minPieceSize uint64, ttlsecs int64) error {
 	h.askLk.Lock()
 	defer h.askLk.Unlock()
 
+	if ttlsecs <= 0 {
+		return xerrors.Errorf("ask ttl must be positive")
+	}
+
 	var seqno uint64
 	if h.ask != nil {
 		seqno = h.ask.Ask.SeqNo + 1
@@ -25,12 +34,12 @@ func (h *Handler) SetPrice(p types.BigInt, ttlsecs int64) error {
 
 	now := time.Now().Unix()
 	ask := &types.StorageAsk{
-		Price:        p,
+		Price:        price,
 		Timestamp:    now,
 		Expiry:       now + ttlsecs,
 		Miner:        h.actor,
 		SeqNo:        seqno,
-		MinPieceSize: h.minPieceSize,
+		MinPieceSize: minPieceSize,
 	}
 
 	ssa, err := h.signAsk(ask)
@@ -38,7 +47,59 @@ func (h *Handler) SetPrice(p types.BigInt, ttlsecs int64) error {
 		return err
 	}
 
-	return h.saveAsk(ssa)
+	if err := h.saveAsk(ssa); err != nil {
+		return err
+	}
+
+	h.pricePerByteBlock = price
+	h.minPieceSize = minPieceSize
+	return nil
+}
+
+// GetAsk returns the current storage ask
+func (h *Handler) GetAsk() *types.SignedStorageAsk {
+	h.askLk.Lock()
+	defer h.askLk.Unlock()
+
+	return h.ask
+}
+
+func (h *Handler) askPrice() types.BigInt {
+	h.askLk.Lock()
+	defer h.askLk.Unlock()
+
+	return h.pricePerByteBlock
+}
+
+// renewAsks re-signs the ask with the same terms once less than a quarter of
+// its validity period is left
+func (h *Handler) renewAsks() {
+	tick := time.NewTicker(askRenewCheck)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+		case <-h.stop:
+			return
+		}
+
+		ask := h.GetAsk()
+		if ask == nil {
+			continue
+		}
+
+		ttl := ask.Ask.Expiry - ask.Ask.Timestamp
+		if time.Now().Unix() < ask.Ask.Expiry-ttl/4 {
+			continue
+		}
+
+		log.Infof("renewing storage ask (seqno %d, expiry %d)", ask.Ask.SeqNo, ask.Ask.Expiry)
+
+		if err := h.SetAsk(ask.Ask.Price, ask.Ask.MinPieceSize, ttl); err != nil {
+			log.Errorf("renewing storage ask: %s", err)
+		}
+	}
 }
 
 func (h *Handler) getAsk(m address.Address) *types.SignedStorageAsk {
@@ -103,6 +164,8 @@ func (h *Handler) loadAsk() error {
 	}
 
 	h.ask = &ssa
+	h.pricePerByteBlock = ssa.Ask.Price
+	h.minPieceSize = ssa.Ask.MinPieceSize
 	return nil
 }
 
@@ -3,6 +3,7 @@ package deals
 import (
 	"context"
 	"math"
+	"sync"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
@@ -12,6 +13,7 @@ import (
 	"github.com/libp2p/go-libp2p-core/host"
 	inet "github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/whyrusleeping/pubsub"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/lotus/api"
@@ -21,6 +23,7 @@ import (
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/chain/wallet"
 	"github.com/filecoin-project/lotus/lib/cborrpc"
+	"github.com/filecoin-project/lotus/lib/pubsubutil"
 	"github.com/filecoin-project/lotus/node/modules/dtypes"
 	"github.com/filecoin-project/lotus/retrieval/discovery"
 )
@@ -28,11 +31,7 @@ import (
 func init() {
 	cbor.RegisterCborType(ClientDeal{})
 	cbor.RegisterCborType(actors.PieceInclVoucherData{}) // TODO: USE CBORGEN!
-	cbor.RegisterCborType(types.SignedVoucher{})
-	cbor.RegisterCborType(types.ModVerifyParams{})
-	cbor.RegisterCborType(types.Signature{})
 	cbor.RegisterCborType(actors.PaymentInfo{})
-	cbor.RegisterCborType(api.PaymentInfo{})
 	cbor.RegisterCborType(actors.InclusionProof{})
 }
 
@@ -44,9 +43,29 @@ type ClientDeal struct {
 	State       api.DealState
 	Miner       peer.ID
 
+	// Message is the last message from the miner, or the reason the deal
+	// failed
+	Message string
+
+	// Set when State >= DealSealing
+	PieceInclusionProof PieceInclusionProof
+	CommD               []byte
+
+	// Set when State >= DealComplete
+	SectorCommitMessage *cid.Cid
+
+	// ReplacedBy is set by the deal monitor when the data was stored in a
+	// new deal renewing this one
+	ReplacedBy *cid.Cid
+	// RenewFailed is set by the deal monitor when the miner couldn't renew
+	// the deal, the data is then stored with other miners
+	RenewFailed bool
+
 	s inet.Stream
 }
 
+const dealUpdatesTopic = "dealupdate"
+
 type Client struct {
 	sm        *stmgr.StateManager
 	h         host.Host
@@ -54,12 +73,16 @@ type Client struct {
 	dag       dtypes.ClientDAG
 	discovery *discovery.Local
 
-	deals ClientStateStore
-	conns map[cid.Cid]inet.Stream
+	deals   ClientStateStore
+	conns   map[cid.Cid]inet.Stream
+	connsLk sync.Mutex
 
 	incoming chan ClientDeal
 	updated  chan clientDealUpdate
 
+	// publishes deals after each state change
+	updates *pubsub.PubSub
+
 	stop    chan struct{}
 	stopped chan struct{}
 }
@@ -68,6 +91,7 @@ type clientDealUpdate struct {
 	newState api.DealState
 	id       cid.Cid
 	err      error
+	mut      func(*ClientDeal)
 }
 
 func NewClient(sm *stmgr.StateManager, h host.Host, w *wallet.Wallet, ds dtypes.MetadataDS, dag dtypes.ClientDAG, discovery *discovery.Local) *Client {
@@ -84,6 +108,8 @@ func NewClient(sm *stmgr.StateManager, h host.Host, w *wallet.Wallet, ds dtypes.
 		incoming: make(chan ClientDeal, 16),
 		updated:  make(chan clientDealUpdate, 16),
 
+		updates: pubsub.New(16),
+
 		stop:    make(chan struct{}),
 		stopped: make(chan struct{}),
 	}
@@ -92,6 +118,10 @@ func NewClient(sm *stmgr.StateManager, h host.Host, w *wallet.Wallet, ds dtypes.
 }
 
 func (c *Client) Run(ctx context.Context) {
+	if err := c.restartDeals(); err != nil {
+		log.Errorf("restarting deals: %s", err)
+	}
+
 	go func() {
 		defer close(c.stopped)
 
@@ -108,14 +138,46 @@ func (c *Client) Run(ctx context.Context) {
 	}()
 }
 
+// restartDeals resumes deals which were in progress when the client was
+// stopped. As deal streams are lost, deal state is re-queried from miners
+func (c *Client) restartDeals() error {
+	deals, err := c.deals.ListClient()
+	if err != nil {
+		return err
+	}
+
+	for _, deal := range deals {
+		if _, ok := nextDealState[deal.State]; !ok {
+			continue
+		}
+
+		log.Infof("resuming deal %s (state %d)", deal.ProposalCid, deal.State)
+
+		go func(deal ClientDeal) {
+			select {
+			case c.updated <- clientDealUpdate{
+				newState: deal.State,
+				id:       deal.ProposalCid,
+			}:
+			case <-c.stop:
+			}
+		}(deal)
+	}
+
+	return nil
+}
+
 func (c *Client) onIncoming(deal ClientDeal) {
 	log.Info("incoming deal")
 
+	c.connsLk.Lock()
 	if _, ok := c.conns[deal.ProposalCid]; ok {
+		c.connsLk.Unlock()
 		log.Errorf("tracking deal connection: already tracking connection for deal %s", deal.ProposalCid)
 		return
 	}
 	c.conns[deal.ProposalCid] = deal.s
+	c.connsLk.Unlock()
 
 	if err := c.deals.Begin(deal.ProposalCid, deal); err != nil {
 		// We may have re-sent the proposal
@@ -123,6 +185,7 @@ func (c *Client) onIncoming(deal ClientDeal) {
 		c.failDeal(deal.ProposalCid, err)
 		return
 	}
+	c.updates.Pub(deal, dealUpdatesTopic)
 
 	go func() {
 		c.updated <- clientDealUpdate{
@@ -138,9 +201,18 @@ func (c *Client) onUpdated(ctx context.Context, update clientDealUpdate) {
 	var deal ClientDeal
 	err := c.deals.MutateClient(update.id, func(d *ClientDeal) error {
 		d.State = update.newState
+		if update.mut != nil {
+			update.mut(d)
+		}
+		if update.err != nil {
+			d.Message = update.err.Error()
+		}
 		deal = *d
 		return nil
 	})
+	if err == nil {
+		c.updates.Pub(deal, dealUpdatesTopic)
+	}
 	if update.err != nil {
 		log.Errorf("deal %s failed: %s", update.id, update.err)
 		c.failDeal(update.id, update.err)
@@ -164,7 +236,8 @@ func (c *Client) onUpdated(ctx context.Context, update clientDealUpdate) {
 }
 
 type ClientDealProposal struct {
-	Data cid.Cid
+	Data              cid.Cid
+	SerializationMode api.SerializationMode
 
 	TotalPrice types.BigInt
 	Duration   uint64
@@ -174,24 +247,26 @@ type ClientDealProposal struct {
 	MinerAddress  address.Address
 	ClientAddress address.Address
 	MinerID       peer.ID
+
+	ManualTransfer bool
 }
 
-func (c *Client) VerifyParams(ctx context.Context, data cid.Cid) (*actors.PieceInclVoucherData, error) {
-	commP, size, err := c.commP(ctx, data)
+func (c *Client) VerifyParams(ctx context.Context, data cid.Cid, mode api.SerializationMode) (*actors.PieceInclVoucherData, error) {
+	commP, size, err := c.commP(ctx, data, mode)
 	if err != nil {
 		return nil, err
 	}
 
 	return &actors.PieceInclVoucherData{
 		CommP:     commP,
-		PieceSize: types.NewInt(uint64(size)),
+		PieceSize: types.NewInt(size),
 	}, nil
 }
 
 func (c *Client) Start(ctx context.Context, p ClientDealProposal, vd *actors.PieceInclVoucherData) (cid.Cid, error) {
 	proposal := StorageDealProposal{
 		PieceRef:          p.Data,
-		SerializationMode: SerializationUnixFs,
+		SerializationMode: p.SerializationMode,
 		CommP:             vd.CommP[:],
 		Size:              vd.PieceSize.Uint64(),
 		TotalPrice:        p.TotalPrice,
@@ -199,6 +274,7 @@ func (c *Client) Start(ctx context.Context, p ClientDealProposal, vd *actors.Pie
 		Payment:           p.Payment,
 		MinerAddress:      p.MinerAddress,
 		ClientAddress:     p.ClientAddress,
+		ManualTransfer:    p.ManualTransfer,
 	}
 
 	s, err := c.h.NewStream(ctx, p.MinerID, ProtocolID)
@@ -271,6 +347,26 @@ func (c *Client) List() ([]ClientDeal, error) {
 	return c.deals.ListClient()
 }
 
+func (c *Client) GetDeal(id cid.Cid) (ClientDeal, error) {
+	return c.deals.GetClient(id)
+}
+
+// SubscribeUpdates returns a channel receiving deals each time their state
+// changes. The channel is closed when ctx is cancelled
+func (c *Client) SubscribeUpdates(ctx context.Context) <-chan ClientDeal {
+	out := make(chan ClientDeal, 16)
+	pubsubutil.Subscribe(ctx, c.updates, dealUpdatesTopic, func(val interface{}) {
+		select {
+		case out <- val.(ClientDeal):
+		case <-ctx.Done():
+		}
+	}, func() {
+		close(out)
+	})
+
+	return out
+}
+
 func (c *Client) Stop() {
 	close(c.stop)
 	<-c.stopped
@@ -10,11 +10,11 @@ import (
 	"github.com/filecoin-project/lotus/lib/sectorbuilder"
 )
 
-type clientHandlerFunc func(ctx context.Context, deal ClientDeal) error
+type clientHandlerFunc func(ctx context.Context, deal ClientDeal) (func(*ClientDeal), error)
 
 func (c *Client) handle(ctx context.Context, deal ClientDeal, cb clientHandlerFunc, next api.DealState) {
 	go func() {
-		err := cb(ctx, deal)
+		mut, err := cb(ctx, deal)
 		if err != nil {
 			next = api.DealError
 		}
@@ -23,81 +23,112 @@ func (c *Client) handle(ctx context.Context, deal ClientDeal, cb clientHandlerFu
 			newState: next,
 			id:       deal.ProposalCid,
 			err:      err,
+			mut:      mut,
 		}:
 		case <-c.stop:
 		}
 	}()
 }
 
-func (c *Client) new(ctx context.Context, deal ClientDeal) error {
-	resp, err := c.readStorageDealResp(deal)
+// checkResponse makes sure the miner moved the deal to the expected state,
+// and records the response in the deal
+func checkResponse(resp *StorageDealResponse, expect api.DealState) (func(*ClientDeal), error) {
+	mut := func(deal *ClientDeal) {
+		deal.Message = resp.Message
+
+		switch resp.State {
+		case api.DealRejected, api.DealFailed:
+			deal.State = resp.State
+		}
+	}
+
+	if resp.State != expect {
+		return mut, xerrors.Errorf("deal in unexpected state %d (expected %d): %s", resp.State, expect, resp.Message)
+	}
+
+	return mut, nil
+}
+
+func (c *Client) new(ctx context.Context, deal ClientDeal) (func(*ClientDeal), error) {
+	resp, err := c.readStorageDealResp(ctx, deal)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if resp.State != api.DealAccepted {
-		return xerrors.Errorf("deal wasn't accepted (State=%d)", resp.State)
+	mut, err := checkResponse(resp, api.DealAccepted)
+	if err != nil {
+		return mut, xerrors.Errorf("deal wasn't accepted: %w", err)
 	}
 
 	log.Info("DEAL ACCEPTED!")
 
-	return nil
+	return mut, nil
 }
 
-func (c *Client) accepted(ctx context.Context, deal ClientDeal) error {
+func (c *Client) accepted(ctx context.Context, deal ClientDeal) (func(*ClientDeal), error) {
 	/* data transfer happens */
 
-	resp, err := c.readStorageDealResp(deal)
+	resp, err := c.readStorageDealResp(ctx, deal)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if resp.State != api.DealStaged {
-		return xerrors.Errorf("deal wasn't staged (State=%d)", resp.State)
+	mut, err := checkResponse(resp, api.DealStaged)
+	if err != nil {
+		return mut, xerrors.Errorf("deal wasn't staged: %w", err)
 	}
 
 	log.Info("DEAL STAGED!")
 
-	return nil
+	return mut, nil
 }
 
-func (c *Client) staged(ctx context.Context, deal ClientDeal) error {
+func (c *Client) staged(ctx context.Context, deal ClientDeal) (func(*ClientDeal), error) {
 	/* miner seals our data, hopefully */
 
-	resp, err := c.readStorageDealResp(deal)
+	resp, err := c.readStorageDealResp(ctx, deal)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if resp.State != api.DealSealing {
-		return xerrors.Errorf("deal wasn't sealed (State=%d)", resp.State)
+	mut, err := checkResponse(resp, api.DealSealing)
+	if err != nil {
+		return mut, xerrors.Errorf("deal wasn't sealed: %w", err)
 	}
 
 	log.Info("DEAL SEALED!")
 
 	ok, err := sectorbuilder.VerifyPieceInclusionProof(build.SectorSize, deal.Proposal.Size, deal.Proposal.CommP, resp.CommD, resp.PieceInclusionProof.ProofElements)
 	if err != nil {
-		return xerrors.Errorf("verifying piece inclusion proof in staged deal %s: %w", deal.ProposalCid, err)
+		return mut, xerrors.Errorf("verifying piece inclusion proof in staged deal %s: %w", deal.ProposalCid, err)
 	}
 	if !ok {
-		return xerrors.Errorf("verifying piece inclusion proof in staged deal %s failed", deal.ProposalCid)
+		return mut, xerrors.Errorf("verifying piece inclusion proof in staged deal %s failed", deal.ProposalCid)
 	}
 
-	return nil
+	return func(deal *ClientDeal) {
+		mut(deal)
+		deal.PieceInclusionProof = resp.PieceInclusionProof
+		deal.CommD = resp.CommD
+	}, nil
 }
 
-func (c *Client) sealing(ctx context.Context, deal ClientDeal) error {
-	resp, err := c.readStorageDealResp(deal)
+func (c *Client) sealing(ctx context.Context, deal ClientDeal) (func(*ClientDeal), error) {
+	resp, err := c.readStorageDealResp(ctx, deal)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if resp.State != api.DealComplete {
-		return xerrors.Errorf("deal wasn't complete (State=%d)", resp.State)
+	mut, err := checkResponse(resp, api.DealComplete)
+	if err != nil {
+		return mut, xerrors.Errorf("deal wasn't complete: %w", err)
 	}
 
 	// TODO: look for the commit message on chain, negotiate better payment vouchers
 
 	log.Info("DEAL COMPLETE!!")
-	return nil
+	return func(deal *ClientDeal) {
+		mut(deal)
+		deal.SectorCommitMessage = resp.SectorCommitMessage
+	}, nil
 }
@@ -0,0 +1,74 @@
+package deals
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	inet "github.com/libp2p/go-libp2p-core/network"
+	"github.com/stretchr/testify/require"
+	pubsub "github.com/whyrusleeping/pubsub"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func testClient() *Client {
+	return &Client{
+		deals:   ClientStateStore{StateStore{ds: datastore.NewMapDatastore()}},
+		conns:   map[cid.Cid]inet.Stream{},
+		updates: pubsub.New(16),
+		updated: make(chan clientDealUpdate, 16),
+		stop:    make(chan struct{}),
+	}
+}
+
+func TestClientDealUpdates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := testClient()
+
+	id := dealCid(t, 1)
+	require.NoError(t, c.deals.Begin(id, &ClientDeal{
+		ProposalCid: id,
+		Proposal:    StorageDealProposal{PieceRef: id, TotalPrice: types.NewInt(0)},
+		State:       api.DealSealing,
+	}))
+
+	updates := c.SubscribeUpdates(ctx)
+
+	mcid := dealCid(t, 2)
+	c.onUpdated(ctx, clientDealUpdate{
+		newState: api.DealComplete,
+		id:       id,
+		mut: func(d *ClientDeal) {
+			d.SectorCommitMessage = &mcid
+		},
+	})
+
+	deal := <-updates
+	require.Equal(t, api.DealComplete, deal.State)
+	require.Equal(t, &mcid, deal.SectorCommitMessage)
+
+	stored, err := c.GetDeal(id)
+	require.NoError(t, err)
+	require.Equal(t, api.DealComplete, stored.State)
+	require.Equal(t, &mcid, stored.SectorCommitMessage)
+
+	c.onUpdated(ctx, clientDealUpdate{
+		newState: api.DealFailed,
+		id:       id,
+		err:      xerrors.New("miner went away"),
+	})
+
+	deal = <-updates
+	require.Equal(t, api.DealFailed, deal.State)
+	require.Equal(t, "miner went away", deal.Message)
+
+	cancel()
+	for range updates {
+	}
+}
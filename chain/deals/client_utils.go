@@ -2,7 +2,7 @@ package deals
 
 import (
 	"context"
-	"github.com/filecoin-project/lotus/lib/sectorbuilder"
+	"io"
 	"runtime"
 
 	"github.com/ipfs/go-cid"
@@ -12,8 +12,12 @@ import (
 	inet "github.com/libp2p/go-libp2p-core/network"
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/stmgr"
 	"github.com/filecoin-project/lotus/lib/cborrpc"
+	"github.com/filecoin-project/lotus/lib/sectorbuilder"
+	"github.com/filecoin-project/lotus/storage/sectorblocks"
 )
 
 func (c *Client) failDeal(id cid.Cid, cerr error) {
@@ -22,41 +26,65 @@ func (c *Client) failDeal(id cid.Cid, cerr error) {
 		cerr = xerrors.Errorf("unknown error (fail called at %s:%d)", f, l)
 	}
 
+	c.connsLk.Lock()
 	s, ok := c.conns[id]
 	if ok {
 		_ = s.Reset()
 		delete(c.conns, id)
 	}
+	c.connsLk.Unlock()
 
 	// TODO: store in some sort of audit log
 	log.Errorf("deal %s failed: %s", id, cerr)
 }
 
-func (c *Client) commP(ctx context.Context, data cid.Cid) ([]byte, int64, error) {
-	root, err := c.dag.Get(ctx, data)
-	if err != nil {
-		log.Errorf("failed to get file root for deal: %s", err)
-		return nil, 0, err
-	}
-
-	n, err := unixfile.NewUnixfsFile(ctx, c.dag, root)
+func (c *Client) commP(ctx context.Context, data cid.Cid, mode api.SerializationMode) ([]byte, uint64, error) {
+	ser, err := sectorblocks.SerializationFor(mode)
 	if err != nil {
-		log.Errorf("cannot open unixfs file: %s", err)
 		return nil, 0, err
 	}
 
-	uf, ok := n.(files.File)
-	if !ok {
-		// TODO: we probably got directory, how should we handle this in unixfs mode?
-		return nil, 0, xerrors.New("unsupported unixfs type")
+	var r io.Reader
+	var size uint64
+
+	switch ser {
+	case sectorblocks.SerializationUnixfs0:
+		root, err := c.dag.Get(ctx, data)
+		if err != nil {
+			log.Errorf("failed to get file root for deal: %s", err)
+			return nil, 0, err
+		}
+
+		n, err := unixfile.NewUnixfsFile(ctx, c.dag, root)
+		if err != nil {
+			log.Errorf("cannot open unixfs file: %s", err)
+			return nil, 0, err
+		}
+
+		uf, ok := n.(files.File)
+		if !ok {
+			// TODO: we probably got directory, how should we handle this in unixfs mode?
+			return nil, 0, xerrors.New("unsupported unixfs type")
+		}
+
+		fsize, err := uf.Size()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		r, size = uf, uint64(fsize)
+	default:
+		// the piece has to be serialized exactly like the miner will do it
+		pr, psize, err := sectorblocks.DagPieceReader(ctx, c.dag, data, ser)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer pr.Close()
+
+		r, size = pr, psize
 	}
 
-	size, err := uf.Size()
-	if err != nil {
-		return nil, 0, err
-	}
-
-	commP, err := sectorbuilder.GeneratePieceCommitment(uf, uint64(size))
+	commP, err := sectorbuilder.GeneratePieceCommitment(r, size)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -84,20 +112,30 @@ func (c *Client) sendProposal(s inet.Stream, proposal StorageDealProposal, from
 	return cborrpc.WriteCborRPC(s, signedProposal)
 }
 
-func (c *Client) readStorageDealResp(deal ClientDeal) (*StorageDealResponse, error) {
+func (c *Client) readStorageDealResp(ctx context.Context, deal ClientDeal) (*StorageDealResponse, error) {
+	c.connsLk.Lock()
 	s, ok := c.conns[deal.ProposalCid]
+	c.connsLk.Unlock()
 	if !ok {
-		// TODO: Try to re-establish the connection using query protocol
-		return nil, xerrors.Errorf("no connection to miner")
+		// deal stream was lost, likely because we were restarted
+		return c.waitDealStatus(ctx, deal)
 	}
 
 	var resp SignedStorageDealResponse
 	if err := cborrpc.ReadCborRPC(s, &resp); err != nil {
-		log.Errorw("failed to read StorageDealResponse message", "error", err)
-		return nil, err
+		log.Warnw("failed to read StorageDealResponse message, querying deal status", "error", err)
+
+		_ = s.Reset()
+		c.connsLk.Lock()
+		delete(c.conns, deal.ProposalCid)
+		c.connsLk.Unlock()
+
+		return c.waitDealStatus(ctx, deal)
 	}
 
-	// TODO: verify signature
+	if err := c.checkResponseSignature(&resp, deal.Proposal.MinerAddress); err != nil {
+		return nil, err
+	}
 
 	if resp.Response.Proposal != deal.ProposalCid {
 		return nil, xerrors.New("miner responded to a wrong proposal")
@@ -105,3 +143,28 @@ func (c *Client) readStorageDealResp(deal ClientDeal) (*StorageDealResponse, err
 
 	return &resp.Response, nil
 }
+
+// checkResponseSignature checks that a response was signed by the worker of
+// the miner the deal was made with
+func (c *Client) checkResponseSignature(resp *SignedStorageDealResponse, miner address.Address) error {
+	if resp.Signature == nil {
+		return xerrors.New("deal response not signed")
+	}
+
+	tss := c.sm.ChainStore().GetHeaviestTipSet().ParentState()
+
+	w, err := stmgr.GetMinerWorker(context.TODO(), c.sm, tss, miner)
+	if err != nil {
+		return xerrors.Errorf("getting worker for miner %s: %w", miner, err)
+	}
+
+	msg, err := cbor.DumpObject(&resp.Response)
+	if err != nil {
+		return xerrors.Errorf("serializing response: %w", err)
+	}
+
+	if err := resp.Signature.Verify(w, msg); err != nil {
+		return xerrors.Errorf("verifying deal response signature: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package deals
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/chain/address"
+)
+
+// DealFilter decides whether the miner should accept a deal proposal
+type DealFilter interface {
+	// Accept returns an error describing why the proposal was rejected, or
+	// nil if it can be accepted
+	Accept(ctx context.Context, proposal StorageDealProposal) error
+}
+
+// Filters combines multiple filters, a proposal has to be accepted by all
+// of them
+type Filters []DealFilter
+
+func (fs Filters) Accept(ctx context.Context, proposal StorageDealProposal) error {
+	for _, f := range fs {
+		if err := f.Accept(ctx, proposal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RuleFilter implements simple built-in acceptance rules
+type RuleFilter struct {
+	// AllowClients, when not empty, lists the only clients deals are
+	// accepted from
+	AllowClients []address.Address
+	DenyClients  []address.Address
+
+	MaxPieceSize uint64 // 0 means no limit
+	MaxDuration  uint64 // in blocks, 0 means no limit
+}
+
+func (rf *RuleFilter) Accept(ctx context.Context, proposal StorageDealProposal) error {
+	if len(rf.AllowClients) > 0 && !hasAddr(rf.AllowClients, proposal.ClientAddress) {
+		return xerrors.Errorf("client %s not allowed", proposal.ClientAddress)
+	}
+
+	if hasAddr(rf.DenyClients, proposal.ClientAddress) {
+		return xerrors.Errorf("client %s not allowed", proposal.ClientAddress)
+	}
+
+	if rf.MaxPieceSize > 0 && proposal.Size > rf.MaxPieceSize {
+		return xerrors.Errorf("piece size %d above maximum of %d", proposal.Size, rf.MaxPieceSize)
+	}
+
+	if rf.MaxDuration > 0 && proposal.Duration > rf.MaxDuration {
+		return xerrors.Errorf("deal duration %d above maximum of %d", proposal.Duration, rf.MaxDuration)
+	}
+
+	return nil
+}
+
+func hasAddr(addrs []address.Address, a address.Address) bool {
+	for _, addr := range addrs {
+		if addr == a {
+			return true
+		}
+	}
+	return false
+}
+
+// CommandFilter runs an external command to decide on proposals. The
+// proposal is passed as JSON on stdin, the command should exit with 0 to
+// accept the deal. Output of rejecting commands is sent to the client
+type CommandFilter struct {
+	Cmd string // run with 'sh -c'
+
+	// Timeout is how long the command can run before the proposal is
+	// rejected, 0 means defaultFilterTimeout
+	Timeout time.Duration
+}
+
+const defaultFilterTimeout = time.Minute
+
+func (cf *CommandFilter) Accept(ctx context.Context, proposal StorageDealProposal) error {
+	in, err := json.Marshal(&proposal)
+	if err != nil {
+		return xerrors.Errorf("serializing proposal for deal filter: %w", err)
+	}
+
+	timeout := cf.Timeout
+	if timeout == 0 {
+		timeout = defaultFilterTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var out bytes.Buffer
+
+	cmd := exec.Command("sh", "-c", cf.Cmd)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	// the command runs in its own process group, so that processes it starts
+	// get killed on timeout too. Otherwise they keep the output open, and
+	// Wait doesn't return until they exit
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return xerrors.Errorf("starting deal filter: %w", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+				log.Warnf("killing deal filter: %s", err)
+			}
+		case <-done:
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return xerrors.Errorf("deal filter timed out after %s", timeout)
+		}
+		if _, ok := err.(*exec.ExitError); ok {
+			return xerrors.Errorf("rejected by deal filter: %s", strings.TrimSpace(out.String()))
+		}
+		return xerrors.Errorf("running deal filter: %w", err)
+	}
+
+	return nil
+}
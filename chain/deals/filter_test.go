@@ -0,0 +1,118 @@
+package deals
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/wallet"
+)
+
+func TestRuleFilter(t *testing.T) {
+	ctx := context.Background()
+
+	alice, err := address.NewIDAddress(100)
+	require.NoError(t, err)
+	bob, err := address.NewIDAddress(101)
+	require.NoError(t, err)
+
+	rf := &RuleFilter{
+		AllowClients: []address.Address{alice},
+		MaxPieceSize: 1000,
+		MaxDuration:  100,
+	}
+
+	require.NoError(t, rf.Accept(ctx, StorageDealProposal{ClientAddress: alice, Size: 1000, Duration: 100}))
+	require.Error(t, rf.Accept(ctx, StorageDealProposal{ClientAddress: bob, Size: 1000, Duration: 100}))
+	require.Error(t, rf.Accept(ctx, StorageDealProposal{ClientAddress: alice, Size: 1001, Duration: 100}))
+	require.Error(t, rf.Accept(ctx, StorageDealProposal{ClientAddress: alice, Size: 1000, Duration: 101}))
+
+	deny := &RuleFilter{DenyClients: []address.Address{bob}}
+	require.NoError(t, deny.Accept(ctx, StorageDealProposal{ClientAddress: alice}))
+	require.Error(t, Filters{rf, deny}.Accept(ctx, StorageDealProposal{ClientAddress: bob}))
+}
+
+func TestCommandFilter(t *testing.T) {
+	ctx := context.Background()
+
+	client, err := address.NewIDAddress(100)
+	require.NoError(t, err)
+
+	proposal := StorageDealProposal{
+		Size:          1000,
+		TotalPrice:    types.NewInt(10),
+		ClientAddress: client,
+		MinerAddress:  client,
+	}
+
+	accept := &CommandFilter{Cmd: `grep -q '"Size":1000'`}
+	require.NoError(t, accept.Accept(ctx, proposal))
+
+	proposal.Size = 2000
+	err = accept.Accept(ctx, proposal)
+	require.Error(t, err)
+
+	reject := &CommandFilter{Cmd: "cat > /dev/null; echo 'not today'; exit 1"}
+	err = reject.Accept(ctx, proposal)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not today")
+
+	slow := &CommandFilter{Cmd: "sleep 10", Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	err = slow.Accept(ctx, proposal)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "timed out")
+	require.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestProposalSignature(t *testing.T) {
+	w, err := wallet.NewWallet(wallet.NewMemKeyStore())
+	require.NoError(t, err)
+
+	client, err := w.GenerateKey(types.KTSecp256k1)
+	require.NoError(t, err)
+	other, err := w.GenerateKey(types.KTSecp256k1)
+	require.NoError(t, err)
+
+	sign := func(p StorageDealProposal, signer address.Address) *SignedStorageDealProposal {
+		msg, err := cbor.DumpObject(&p)
+		require.NoError(t, err)
+		sig, err := w.Sign(context.Background(), signer, msg)
+		require.NoError(t, err)
+		return &SignedStorageDealProposal{Proposal: p, Signature: sig}
+	}
+
+	miner, err := address.NewIDAddress(100)
+	require.NoError(t, err)
+
+	proposal := StorageDealProposal{
+		PieceRef:      dealCid(t, 1),
+		Size:          1000,
+		TotalPrice:    types.NewInt(10),
+		MinerAddress:  miner,
+		ClientAddress: client,
+	}
+
+	require.NoError(t, checkProposalSignature(sign(proposal, client)))
+
+	// clients can't make proposals in the name of other addresses
+	err = checkProposalSignature(sign(proposal, other))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "verifying proposal signature")
+
+	signed := sign(proposal, client)
+	signed.Proposal.Size = 2000
+	err = checkProposalSignature(signed)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "verifying proposal signature")
+
+	signed.Signature = nil
+	err = checkProposalSignature(signed)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not signed")
+}
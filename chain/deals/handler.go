@@ -4,6 +4,7 @@ import (
 	"context"
 	"math"
 	"sync"
+	"time"
 
 	cid "github.com/ipfs/go-cid"
 	datastore "github.com/ipfs/go-datastore"
@@ -35,19 +36,43 @@ type MinerDeal struct {
 
 	SectorID uint64 // Set when State >= DealStaged
 
+	// VouchersConsumed and AddingPiece let deals resumed after a restart
+	// skip steps which can't be repeated
+	VouchersConsumed bool
+	AddingPiece      bool
+
+	// Message explains why the deal was rejected or failed
+	Message string
+
+	// Set when State >= DealComplete, used to answer deal status queries
+	PieceInclusionProof PieceInclusionProof
+	CommD               []byte
+
 	s inet.Stream
 }
 
+// HandlerConfig contains the operator settings used when accepting deals
+type HandlerConfig struct {
+	PricePerByteBlock types.BigInt // how much we want for storing one byte for one block
+	MinPieceSize      uint64
+
+	// AskExpiry is how long the default ask is valid for
+	AskExpiry time.Duration
+}
+
 type Handler struct {
+	// pricePerByteBlock and minPieceSize follow the current ask, guarded
+	// by askLk
 	pricePerByteBlock types.BigInt // how much we want for storing one byte for one block
 	minPieceSize      uint64
 
 	ask   *types.SignedStorageAsk
 	askLk sync.Mutex
 
-	secst *sectorblocks.SectorBlocks
-	commt *commitment.Tracker
-	full  api.FullNode
+	secst  *sectorblocks.SectorBlocks
+	commt  *commitment.Tracker
+	full   api.FullNode
+	filter DealFilter
 
 	// TODO: Use a custom protocol or graphsync in the future
 	// TODO: GC
@@ -56,7 +81,8 @@ type Handler struct {
 	deals MinerStateStore
 	ds    dtypes.MetadataDS
 
-	conns map[cid.Cid]inet.Stream
+	conns   map[cid.Cid]inet.Stream
+	connsLk sync.Mutex
 
 	actor address.Address
 
@@ -73,7 +99,7 @@ type minerDealUpdate struct {
 	mut      func(*MinerDeal)
 }
 
-func NewHandler(ds dtypes.MetadataDS, secst *sectorblocks.SectorBlocks, commt *commitment.Tracker, dag dtypes.StagingDAG, fullNode api.FullNode) (*Handler, error) {
+func NewHandler(ds dtypes.MetadataDS, secst *sectorblocks.SectorBlocks, commt *commitment.Tracker, dag dtypes.StagingDAG, fullNode api.FullNode, cfg HandlerConfig, filter DealFilter) (*Handler, error) {
 	addr, err := ds.Get(datastore.NewKey("miner-address"))
 	if err != nil {
 		return nil, err
@@ -89,8 +115,10 @@ func NewHandler(ds dtypes.MetadataDS, secst *sectorblocks.SectorBlocks, commt *c
 		dag:   dag,
 		full:  fullNode,
 
-		pricePerByteBlock: types.NewInt(3), // TODO: allow setting
-		minPieceSize:      1,
+		filter: filter,
+
+		pricePerByteBlock: cfg.PricePerByteBlock,
+		minPieceSize:      cfg.MinPieceSize,
 
 		conns: map[cid.Cid]inet.Stream{},
 
@@ -112,7 +140,7 @@ func NewHandler(ds dtypes.MetadataDS, secst *sectorblocks.SectorBlocks, commt *c
 	if h.ask == nil {
 		// TODO: we should be fine with this state, and just say it means 'not actively accepting deals'
 		// for now... lets just set a price
-		if err := h.SetPrice(types.NewInt(3), 1000000); err != nil {
+		if err := h.SetAsk(cfg.PricePerByteBlock, cfg.MinPieceSize, int64(cfg.AskExpiry/time.Second)); err != nil {
 			return nil, xerrors.Errorf("failed setting a default price: %w", err)
 		}
 	}
@@ -121,7 +149,11 @@ func NewHandler(ds dtypes.MetadataDS, secst *sectorblocks.SectorBlocks, commt *c
 }
 
 func (h *Handler) Run(ctx context.Context) {
-	// TODO: restore state
+	if err := h.restartDeals(); err != nil {
+		log.Errorf("restarting deals: %s", err)
+	}
+
+	go h.renewAsks()
 
 	go func() {
 		defer log.Warn("quitting deal handler loop")
@@ -140,18 +172,53 @@ func (h *Handler) Run(ctx context.Context) {
 	}()
 }
 
+// restartDeals resumes deals which were in progress when the miner was
+// stopped. Deal streams are lost, clients can re-query deal state using
+// DealStatusProtocolID
+func (h *Handler) restartDeals() error {
+	deals, err := h.deals.ListMiner()
+	if err != nil {
+		return err
+	}
+
+	for _, deal := range deals {
+		switch deal.State {
+		case api.DealAccepted, api.DealStaged, api.DealSealing, api.DealComplete:
+		default:
+			continue
+		}
+
+		log.Infof("resuming deal %s (state %d)", deal.ProposalCid, deal.State)
+
+		go func(deal MinerDeal) {
+			select {
+			case h.updated <- minerDealUpdate{
+				newState: deal.State,
+				id:       deal.ProposalCid,
+			}:
+			case <-h.stop:
+			}
+		}(deal)
+	}
+
+	return nil
+}
+
 func (h *Handler) onIncoming(deal MinerDeal) {
 	log.Info("incoming deal")
 
-	h.conns[deal.ProposalCid] = deal.s
-
 	if err := h.deals.Begin(deal.ProposalCid, deal); err != nil {
-		// This can happen when client re-sends proposal
-		h.failDeal(deal.ProposalCid, err)
+		// This can happen when client re-sends proposal, the deal we already
+		// track is left alone
 		log.Errorf("deal tracking failed: %s", err)
+		_ = deal.s.Reset()
 		return
 	}
 
+	h.connsLk.Lock()
+	h.conns[deal.ProposalCid] = deal.s
+	h.connsLk.Unlock()
+
 	go func() {
 		h.updated <- minerDealUpdate{
 			newState: api.DealAccepted,
@@ -163,13 +230,21 @@ func (h *Handler) onIncoming(deal MinerDeal) {
 
 func (h *Handler) onUpdated(ctx context.Context, update minerDealUpdate) {
 	log.Infof("Deal %s updated state to %d", update.id, update.newState)
+
 	if update.err != nil {
 		log.Errorf("deal %s failed: %s", update.id, update.err)
 		h.failDeal(update.id, update.err)
 		return
 	}
 	var deal MinerDeal
+	var rejected bool
 	err := h.deals.MutateMiner(update.id, func(d *MinerDeal) error {
+		if d.State == api.DealRejected {
+			// rejected by the operator while a handler was running
+			rejected = true
+			return nil
+		}
+
 		d.State = update.newState
 		if update.mut != nil {
 			update.mut(d)
@@ -181,10 +256,20 @@ func (h *Handler) onUpdated(ctx context.Context, update minerDealUpdate) {
 		h.failDeal(update.id, err)
 		return
 	}
+	if rejected {
+		log.Infof("ignoring update of rejected deal %s", update.id)
+		h.closeConn(update.id)
+		return
+	}
 
 	switch update.newState {
 	case api.DealAccepted:
-		h.handle(ctx, deal, h.accept, api.DealStaged)
+		next := api.DealStaged
+		if deal.Proposal.ManualTransfer {
+			// parked until data is imported with ImportData
+			next = api.DealWaitingForData
+		}
+		h.handle(ctx, deal, h.accept, next)
 	case api.DealStaged:
 		h.handle(ctx, deal, h.staged, api.DealSealing)
 	case api.DealSealing:
@@ -238,6 +323,45 @@ func (h *Handler) HandleStream(s inet.Stream) {
 	h.incoming <- deal
 }
 
+func (h *Handler) ListDeals() ([]MinerDeal, error) {
+	return h.deals.ListMiner()
+}
+
+func (h *Handler) GetDeal(id cid.Cid) (MinerDeal, error) {
+	return h.deals.GetMiner(id)
+}
+
+// RejectDeal rejects a deal before its data is staged, and notifies the client
+func (h *Handler) RejectDeal(id cid.Cid, reason string) error {
+	err := h.deals.MutateMiner(id, func(d *MinerDeal) error {
+		switch d.State {
+		case api.DealAccepted, api.DealWaitingForData:
+		default:
+			return xerrors.Errorf("deals in state %s can't be rejected", d.State)
+		}
+
+		d.State = api.DealRejected
+		d.Message = reason
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = h.sendSignedResponse(StorageDealResponse{
+		State:    api.DealRejected,
+		Message:  reason,
+		Proposal: id,
+	})
+	if err != nil {
+		// the client will see the deal was rejected when querying its status
+		log.Warnf("notifying client about rejected deal %s: %s", id, err)
+	}
+
+	h.closeConn(id)
+	return nil
+}
+
 func (h *Handler) Stop() {
 	close(h.stop)
 	<-h.stopped
@@ -124,7 +124,7 @@ func (h *Handler) consumeVouchers(ctx context.Context, deal MinerDeal) error {
 		}
 	}
 
-	minPrice := types.BigMul(types.BigMul(h.pricePerByteBlock, types.NewInt(deal.Proposal.Size)), types.NewInt(deal.Proposal.Duration))
+	minPrice := types.BigMul(types.BigMul(h.askPrice(), types.NewInt(deal.Proposal.Size)), types.NewInt(deal.Proposal.Duration))
 	if types.BigCmp(minPrice, deal.Proposal.TotalPrice) > 0 {
 		return xerrors.Errorf("minimum price: %s", minPrice)
 	}
@@ -143,12 +143,20 @@ func (h *Handler) consumeVouchers(ctx context.Context, deal MinerDeal) error {
 }
 
 func (h *Handler) accept(ctx context.Context, deal MinerDeal) (func(*MinerDeal), error) {
-	switch deal.Proposal.SerializationMode {
-	//case SerializationRaw:
-	//case SerializationIPLD:
-	case SerializationUnixFs:
-	default:
-		return nil, xerrors.Errorf("deal proposal with unsupported serialization: %s", deal.Proposal.SerializationMode)
+	if _, err := sectorblocks.SerializationFor(deal.Proposal.SerializationMode); err != nil {
+		return nil, xerrors.Errorf("deal proposal with unsupported serialization: %w", err)
+	}
+
+	h.askLk.Lock()
+	minPieceSize := h.minPieceSize
+	h.askLk.Unlock()
+
+	if deal.Proposal.Size < minPieceSize {
+		return nil, xerrors.Errorf("piece size %d below minimum of %d", deal.Proposal.Size, minPieceSize)
+	}
+
+	if err := h.filter.Accept(ctx, deal.Proposal); err != nil {
+		return nil, xerrors.Errorf("deal rejected: %w", err)
 	}
 
 	if deal.Proposal.Payment.ChannelMessage != nil {
@@ -158,8 +166,19 @@ func (h *Handler) accept(ctx context.Context, deal MinerDeal) (func(*MinerDeal),
 		}
 	}
 
-	if err := h.consumeVouchers(ctx, deal); err != nil {
-		return nil, err
+	// vouchers were already added if the miner was restarted after that
+	if !deal.VouchersConsumed {
+		if err := h.consumeVouchers(ctx, deal); err != nil {
+			return nil, err
+		}
+
+		err := h.deals.MutateMiner(deal.ProposalCid, func(d *MinerDeal) error {
+			d.VouchersConsumed = true
+			return nil
+		})
+		if err != nil {
+			return nil, xerrors.Errorf("marking vouchers as consumed: %w", err)
+		}
 	}
 
 	log.Info("fetching data for a deal")
@@ -169,7 +188,13 @@ func (h *Handler) accept(ctx context.Context, deal MinerDeal) (func(*MinerDeal),
 		Proposal: deal.ProposalCid,
 	})
 	if err != nil {
-		return nil, err
+		// the client can still query deal status if the stream was lost
+		log.Warnf("Sending deal response failed: %s", err)
+	}
+
+	if deal.Proposal.ManualTransfer {
+		log.Infof("deal %s accepted, waiting for data to be imported", deal.ProposalCid)
+		return nil, nil
 	}
 
 	return nil, merkledag.FetchGraph(ctx, deal.Ref, h.dag)
@@ -177,6 +202,37 @@ func (h *Handler) accept(ctx context.Context, deal MinerDeal) (func(*MinerDeal),
 
 // STAGED
 
+const maxSealPriority = 16
+
+// sealPriority gives deals paying more than our price a higher priority,
+// their sectors will be sealed sooner
+func (h *Handler) sealPriority(deal MinerDeal) int {
+	minPrice := types.BigMul(types.BigMul(h.askPrice(), types.NewInt(deal.Proposal.Size)), types.NewInt(deal.Proposal.Duration))
+	return sealPriorityFor(deal.Proposal.TotalPrice, minPrice)
+}
+
+// sealPriorityFor is one less than how many times the price of a deal covers
+// minPrice, capped at maxSealPriority
+func sealPriorityFor(price types.BigInt, minPrice types.BigInt) int {
+	if minPrice.Sign() == 0 {
+		return 0
+	}
+
+	ratio := types.BigDiv(price, minPrice)
+	if ratio.Sign() == 0 {
+		return 0
+	}
+	if !ratio.IsUint64() {
+		return maxSealPriority
+	}
+
+	priority := ratio.Uint64() - 1
+	if priority > maxSealPriority {
+		return maxSealPriority
+	}
+	return int(priority)
+}
+
 func (h *Handler) staged(ctx context.Context, deal MinerDeal) (func(*MinerDeal), error) {
 	err := h.sendSignedResponse(StorageDealResponse{
 		State:    api.DealStaged,
@@ -186,34 +242,75 @@ func (h *Handler) staged(ctx context.Context, deal MinerDeal) (func(*MinerDeal),
 		log.Warnf("Sending deal response failed: %s", err)
 	}
 
-	root, err := h.dag.Get(ctx, deal.Ref)
+	ser, err := sectorblocks.SerializationFor(deal.Proposal.SerializationMode)
 	if err != nil {
-		return nil, xerrors.Errorf("failed to get file root for deal: %s", err)
+		return nil, err
 	}
 
-	// TODO: abstract this away into ReadSizeCloser + implement different modes
-	n, err := unixfile.NewUnixfsFile(ctx, h.dag, root)
-	if err != nil {
-		return nil, xerrors.Errorf("cannot open unixfs file: %s", err)
+	pieceKey := sectorblocks.PieceKey(ser, deal.Ref)
+	if deal.AddingPiece {
+		// the miner was restarted while adding the piece, if it was added
+		// the deal state wasn't updated yet
+		sectorID, err := h.secst.PieceSector(pieceKey)
+		switch err {
+		case nil:
+			log.Infof("piece for deal %s was already added to sector %d", deal.ProposalCid, sectorID)
+			return func(deal *MinerDeal) {
+				deal.SectorID = sectorID
+				deal.AddingPiece = false
+			}, nil
+		case sectorblocks.ErrNotFound:
+		default:
+			return nil, xerrors.Errorf("looking up piece sector: %w", err)
+		}
 	}
 
-	uf, ok := n.(sectorblocks.UnixfsReader)
-	if !ok {
-		// we probably got directory, unsupported for now
-		return nil, xerrors.Errorf("unsupported unixfs file type")
+	err = h.deals.MutateMiner(deal.ProposalCid, func(d *MinerDeal) error {
+		d.AddingPiece = true
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("marking piece as being added: %w", err)
 	}
 
-	sectorID, err := h.secst.AddUnixfsPiece(deal.Proposal.PieceRef, uf, deal.Proposal.Duration)
+	var sectorID uint64
+	switch ser {
+	case sectorblocks.SerializationUnixfs0:
+		sectorID, err = h.addUnixfsPiece(ctx, deal)
+	default:
+		sectorID, err = h.secst.AddDagPiece(ctx, deal.Ref, ser, h.dag, deal.Proposal.Duration, h.sealPriority(deal))
+	}
 	if err != nil {
-		return nil, xerrors.Errorf("AddPiece failed: %s", err)
+		return nil, xerrors.Errorf("AddPiece failed: %w", err)
 	}
 
 	log.Warnf("New Sector: %d", sectorID)
 	return func(deal *MinerDeal) {
 		deal.SectorID = sectorID
+		deal.AddingPiece = false
 	}, nil
 }
 
+func (h *Handler) addUnixfsPiece(ctx context.Context, deal MinerDeal) (uint64, error) {
+	root, err := h.dag.Get(ctx, deal.Ref)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to get file root for deal: %s", err)
+	}
+
+	n, err := unixfile.NewUnixfsFile(ctx, h.dag, root)
+	if err != nil {
+		return 0, xerrors.Errorf("cannot open unixfs file: %s", err)
+	}
+
+	uf, ok := n.(sectorblocks.UnixfsReader)
+	if !ok {
+		// we probably got directory, unsupported for now
+		return 0, xerrors.Errorf("unsupported unixfs file type")
+	}
+
+	return h.secst.AddUnixfsPiece(deal.Proposal.PieceRef, uf, deal.Proposal.Duration, h.sealPriority(deal))
+}
+
 // SEALING
 
 func getInclusionProof(ref string, status sectorbuilder.SectorSealingStatus) (PieceInclusionProof, error) {
@@ -255,8 +352,12 @@ func (h *Handler) sealing(ctx context.Context, deal MinerDeal) (func(*MinerDeal)
 		return nil, err
 	}
 
-	// TODO: don't hardcode unixfs
-	ip, err := getInclusionProof(string(sectorblocks.SerializationUnixfs0)+deal.Ref.String(), status)
+	ser, err := sectorblocks.SerializationFor(deal.Proposal.SerializationMode)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := getInclusionProof(sectorblocks.PieceKey(ser, deal.Ref), status)
 	if err != nil {
 		return nil, err
 	}
@@ -290,7 +391,10 @@ func (h *Handler) sealing(ctx context.Context, deal MinerDeal) (func(*MinerDeal)
 		log.Warnf("Sending deal response failed: %s", err)
 	}
 
-	return nil, nil
+	return func(deal *MinerDeal) {
+		deal.PieceInclusionProof = ip
+		deal.CommD = status.CommD[:]
+	}, nil
 }
 
 func (h *Handler) complete(ctx context.Context, deal MinerDeal) (func(*MinerDeal), error) {
@@ -0,0 +1,23 @@
+package deals
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestSealPriority(t *testing.T) {
+	min := types.NewInt(10)
+
+	require.Equal(t, 0, sealPriorityFor(types.NewInt(5), min))
+	require.Equal(t, 0, sealPriorityFor(types.NewInt(10), min))
+	require.Equal(t, 1, sealPriorityFor(types.NewInt(20), min))
+	require.Equal(t, 15, sealPriorityFor(types.NewInt(160), min))
+	require.Equal(t, 16, sealPriorityFor(types.NewInt(170), min))
+	require.Equal(t, maxSealPriority, sealPriorityFor(types.NewInt(180), min))
+	require.Equal(t, maxSealPriority, sealPriorityFor(types.BigMul(types.NewInt(1<<62), types.NewInt(1<<62)), types.NewInt(1)))
+
+	require.Equal(t, 0, sealPriorityFor(types.NewInt(100), types.NewInt(0)))
+}
@@ -0,0 +1,65 @@
+package deals
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestRejectDeal(t *testing.T) {
+	ctx := context.Background()
+	h := testHandler()
+
+	begin := func(i byte, state api.DealState) MinerDeal {
+		id := dealCid(t, i)
+		deal := MinerDeal{
+			ProposalCid: id,
+			Proposal:    StorageDealProposal{PieceRef: id, TotalPrice: types.NewInt(0)},
+			State:       state,
+			Ref:         id,
+		}
+		require.NoError(t, h.deals.Begin(id, &deal))
+		return deal
+	}
+
+	accepted := begin(1, api.DealAccepted)
+	staged := begin(2, api.DealStaged)
+
+	require.Error(t, h.RejectDeal(staged.ProposalCid, "too late"))
+	require.NoError(t, h.RejectDeal(accepted.ProposalCid, "no space"))
+
+	// the accept handler finishing after the deal was rejected
+	h.onUpdated(ctx, minerDealUpdate{
+		newState: api.DealStaged,
+		id:       accepted.ProposalCid,
+	})
+
+	deal, err := h.GetDeal(accepted.ProposalCid)
+	require.NoError(t, err)
+	require.Equal(t, api.DealRejected, deal.State)
+	require.Equal(t, "no space", deal.Message)
+
+	h.onUpdated(ctx, minerDealUpdate{
+		newState: api.DealStaged,
+		id:       accepted.ProposalCid,
+		err:      xerrors.New("fetching data: context canceled"),
+	})
+
+	deal, err = h.GetDeal(accepted.ProposalCid)
+	require.NoError(t, err)
+	require.Equal(t, api.DealRejected, deal.State)
+	require.Equal(t, "no space", deal.Message)
+
+	// other deals still fail normally
+	h.failDeal(staged.ProposalCid, xerrors.New("AddPiece failed"))
+
+	deal, err = h.GetDeal(staged.ProposalCid)
+	require.NoError(t, err)
+	require.Equal(t, api.DealFailed, deal.State)
+	require.Equal(t, "AddPiece failed", deal.Message)
+}
@@ -18,28 +18,42 @@ import (
 )
 
 func (h *Handler) failDeal(id cid.Cid, cerr error) {
-	if err := h.deals.End(id); err != nil {
-		log.Warnf("deals.End: %s", err)
-	}
-
 	if cerr == nil {
 		_, f, l, _ := runtime.Caller(1)
 		cerr = xerrors.Errorf("unknown error (fail called at %s:%d)", f, l)
 	}
 
+	// failed deals are kept so operators can see what happened
+	var rejected bool
+	err := h.deals.MutateMiner(id, func(d *MinerDeal) error {
+		if d.State == api.DealRejected {
+			// the client was already told the deal was rejected
+			rejected = true
+			return nil
+		}
+
+		d.State = api.DealFailed
+		d.Message = cerr.Error()
+		return nil
+	})
+	if err != nil {
+		log.Warnf("marking deal %s as failed: %s", id, err)
+	}
+	if rejected {
+		log.Infof("rejected deal %s failed: %s", id, cerr)
+		h.closeConn(id)
+		return
+	}
+
 	log.Errorf("deal %s failed: %s", id, cerr)
 
-	err := h.sendSignedResponse(StorageDealResponse{
+	err = h.sendSignedResponse(StorageDealResponse{
 		State:    api.DealFailed,
 		Message:  cerr.Error(),
 		Proposal: id,
 	})
 
-	s, ok := h.conns[id]
-	if ok {
-		_ = s.Reset()
-		delete(h.conns, id)
-	}
+	h.closeConn(id)
 
 	if err != nil {
 		log.Warnf("notifying client about deal failure: %s", err)
@@ -53,47 +67,89 @@ func (h *Handler) readProposal(s inet.Stream) (proposal SignedStorageDealProposa
 	}
 
 	// TODO: Validate proposal maybe
-	// (and signature, obviously)
 
 	if proposal.Proposal.MinerAddress != h.actor {
-		log.Errorf("proposal with wrong MinerAddress: %s", proposal.Proposal.MinerAddress)
+		return SignedStorageDealProposal{}, xerrors.Errorf("proposal with wrong MinerAddress: %s", proposal.Proposal.MinerAddress)
+	}
+
+	// deal filters trust ClientAddress
+	if err := checkProposalSignature(&proposal); err != nil {
 		return SignedStorageDealProposal{}, err
 	}
 
 	return
 }
 
-func (h *Handler) sendSignedResponse(resp StorageDealResponse) error {
-	s, ok := h.conns[resp.Proposal]
-	if !ok {
-		return xerrors.New("couldn't send response: not connected")
+// checkProposalSignature checks that a proposal was signed by its client
+func checkProposalSignature(proposal *SignedStorageDealProposal) error {
+	if proposal.Signature == nil {
+		return xerrors.New("proposal not signed")
 	}
 
+	msg, err := cbor.DumpObject(&proposal.Proposal)
+	if err != nil {
+		return xerrors.Errorf("serializing proposal: %w", err)
+	}
+
+	if err := proposal.Signature.Verify(proposal.Proposal.ClientAddress, msg); err != nil {
+		return xerrors.Errorf("verifying proposal signature from %s: %w", proposal.Proposal.ClientAddress, err)
+	}
+	return nil
+}
+
+func (h *Handler) signResponse(resp StorageDealResponse) (*SignedStorageDealResponse, error) {
 	msg, err := cbor.DumpObject(&resp)
 	if err != nil {
-		return xerrors.Errorf("serializing response: %w", err)
+		return nil, xerrors.Errorf("serializing response: %w", err)
 	}
 
 	worker, err := h.getWorker(h.actor)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	sig, err := h.full.WalletSign(context.TODO(), worker, msg)
 	if err != nil {
-		return xerrors.Errorf("failed to sign response message: %w", err)
+		return nil, xerrors.Errorf("failed to sign response message: %w", err)
 	}
 
-	signedResponse := SignedStorageDealResponse{
+	return &SignedStorageDealResponse{
 		Response:  resp,
 		Signature: sig,
+	}, nil
+}
+
+func (h *Handler) closeConn(id cid.Cid) {
+	h.connsLk.Lock()
+	defer h.connsLk.Unlock()
+
+	s, ok := h.conns[id]
+	if ok {
+		_ = s.Reset()
+		delete(h.conns, id)
+	}
+}
+
+func (h *Handler) sendSignedResponse(resp StorageDealResponse) error {
+	h.connsLk.Lock()
+	s, ok := h.conns[resp.Proposal]
+	h.connsLk.Unlock()
+	if !ok {
+		return xerrors.New("couldn't send response: not connected")
+	}
+
+	signedResponse, err := h.signResponse(resp)
+	if err != nil {
+		return err
 	}
 
 	err = cborrpc.WriteCborRPC(s, signedResponse)
 	if err != nil {
 		// Assume client disconnected
 		s.Close()
+		h.connsLk.Lock()
 		delete(h.conns, resp.Proposal)
+		h.connsLk.Unlock()
 	}
 	return err
 }
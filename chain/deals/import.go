@@ -0,0 +1,140 @@
+package deals
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/ipfs/go-car"
+	"github.com/ipfs/go-cid"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	ihelper "github.com/ipfs/go-unixfs/importer/helpers"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/storage/sectorblocks"
+)
+
+// ImportFile builds a unixfs DAG of a file in the layout used for storage
+// deals. Clients and miners importing the same file get the same root CID
+func ImportFile(ctx context.Context, dag ipld.DAGService, file files.File, noCopy bool) (cid.Cid, error) {
+	bufferedDS := ipld.NewBufferedDAG(ctx, dag)
+
+	params := ihelper.DagBuilderParams{
+		Maxlinks:   build.UnixfsLinksPerLevel,
+		RawLeaves:  true,
+		CidBuilder: nil,
+		Dagserv:    bufferedDS,
+		NoCopy:     noCopy,
+	}
+
+	db, err := params.New(chunker.NewSizeSplitter(file, int64(build.UnixfsChunkSize)))
+	if err != nil {
+		return cid.Undef, err
+	}
+	nd, err := balanced.Layout(db)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	return nd.Cid(), bufferedDS.Commit()
+}
+
+// loadCar adds blocks from a CAR file to the DAGService, returning the CAR
+// header
+func loadCar(ctx context.Context, dag ipld.DAGService, r io.Reader) (*car.CarHeader, error) {
+	cr, err := car.NewCarReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		blk, err := cr.Next()
+		switch err {
+		case io.EOF:
+			return cr.Header, nil
+		case nil:
+		default:
+			return nil, err
+		}
+
+		nd, err := ipld.Decode(blk)
+		if err != nil {
+			return nil, xerrors.Errorf("decoding block %s: %w", blk.Cid(), err)
+		}
+		if err := dag.Add(ctx, nd); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ImportData imports data of a manual transfer deal from a file, and starts
+// staging the deal. Data of UnixFs deals is read from the original file, for
+// Raw and IPLD deals the file must be a CAR file with the DAG
+func (h *Handler) ImportData(ctx context.Context, id cid.Cid, fname string) error {
+	deal, err := h.deals.GetMiner(id)
+	if err != nil {
+		return xerrors.Errorf("getting deal %s: %w", id, err)
+	}
+	if deal.State != api.DealWaitingForData {
+		return xerrors.Errorf("deal %s isn't waiting for data (state %d)", id, deal.State)
+	}
+
+	ser, err := sectorblocks.SerializationFor(deal.Proposal.SerializationMode)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var root cid.Cid
+	switch ser {
+	case sectorblocks.SerializationUnixfs0:
+		root, err = ImportFile(ctx, h.dag, files.NewReaderFile(f), false)
+		if err != nil {
+			return xerrors.Errorf("importing file: %w", err)
+		}
+	default:
+		hdr, err := loadCar(ctx, h.dag, f)
+		if err != nil {
+			return xerrors.Errorf("loading car file: %w", err)
+		}
+		if len(hdr.Roots) != 1 {
+			return xerrors.Errorf("expected car file to have one root, got %d", len(hdr.Roots))
+		}
+		root = hdr.Roots[0]
+	}
+
+	if root != deal.Ref {
+		return xerrors.Errorf("imported data root %s doesn't match deal data %s", root, deal.Ref)
+	}
+
+	err = h.deals.MutateMiner(id, func(d *MinerDeal) error {
+		if d.State != api.DealWaitingForData {
+			return xerrors.Errorf("data for deal %s was already imported", id)
+		}
+		d.State = api.DealStaged
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case h.updated <- minerDealUpdate{
+		newState: api.DealStaged,
+		id:       id,
+	}:
+		return nil
+	case <-h.stop:
+		return xerrors.New("deal handler stopped")
+	}
+}
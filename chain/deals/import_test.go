@@ -0,0 +1,132 @@
+package deals
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-car"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	inet "github.com/libp2p/go-libp2p-core/network"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func testDag() ipld.DAGService {
+	return merkledag.NewDAGService(blockservice.New(blockstore.NewBlockstore(datastore.NewMapDatastore()), nil))
+}
+
+// dealCid returns a CID for use as a test deal ID
+func dealCid(t *testing.T, i byte) cid.Cid {
+	c, err := cid.V1Builder{Codec: cid.Raw, MhType: mh.SHA2_256}.Sum([]byte{i})
+	require.NoError(t, err)
+	return c
+}
+
+func testHandler() *Handler {
+	return &Handler{
+		dag:     testDag(),
+		deals:   MinerStateStore{StateStore{ds: datastore.NewMapDatastore()}},
+		conns:   map[cid.Cid]inet.Stream{},
+		updated: make(chan minerDealUpdate, 1),
+		stop:    make(chan struct{}),
+	}
+}
+
+func waitingDeal(t *testing.T, h *Handler, id cid.Cid, ref cid.Cid, mode api.SerializationMode) {
+	require.NoError(t, h.deals.Begin(id, &MinerDeal{
+		ProposalCid: id,
+		Proposal: StorageDealProposal{
+			PieceRef:          ref,
+			SerializationMode: mode,
+			TotalPrice:        types.NewInt(0),
+			ManualTransfer:    true,
+		},
+		State: api.DealWaitingForData,
+		Ref:   ref,
+	}))
+}
+
+func TestImportData(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "deal-import")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 2*build.UnixfsChunkSize+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	fname := filepath.Join(dir, "data")
+	require.NoError(t, ioutil.WriteFile(fname, data, 0644))
+
+	// the client imports the file on its side
+	ref, err := ImportFile(ctx, testDag(), files.NewBytesFile(data), false)
+	require.NoError(t, err)
+
+	h := testHandler()
+	id := dealCid(t, 1)
+	waitingDeal(t, h, id, ref, api.SerializationUnixFs)
+
+	other := dealCid(t, 2)
+	waitingDeal(t, h, other, dealCid(t, 3), api.SerializationUnixFs)
+	require.Error(t, h.ImportData(ctx, other, fname), "data of another deal")
+
+	require.NoError(t, h.ImportData(ctx, id, fname))
+
+	update := <-h.updated
+	require.Equal(t, id, update.id)
+	require.Equal(t, api.DealStaged, update.newState)
+
+	deal, err := h.deals.GetMiner(id)
+	require.NoError(t, err)
+	require.Equal(t, api.DealStaged, deal.State)
+
+	_, err = h.dag.Get(ctx, ref)
+	require.NoError(t, err)
+
+	require.Error(t, h.ImportData(ctx, id, fname), "data already imported")
+}
+
+func TestImportCar(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "deal-import")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, build.UnixfsChunkSize+100)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	client := testDag()
+	ref, err := ImportFile(ctx, client, files.NewBytesFile(data), false)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, car.WriteCar(ctx, client, []cid.Cid{ref}, &buf))
+	fname := filepath.Join(dir, "data.car")
+	require.NoError(t, ioutil.WriteFile(fname, buf.Bytes(), 0644))
+
+	h := testHandler()
+	id := dealCid(t, 1)
+	waitingDeal(t, h, id, ref, api.SerializationRaw)
+
+	require.NoError(t, h.ImportData(ctx, id, fname))
+	<-h.updated
+
+	_, err = h.dag.Get(ctx, ref)
+	require.NoError(t, err)
+}
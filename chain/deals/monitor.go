@@ -0,0 +1,433 @@
+package deals
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/events"
+	"github.com/filecoin-project/lotus/chain/stmgr"
+	"github.com/filecoin-project/lotus/chain/store"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// monitorConfidence is the number of epochs the deal monitor waits for
+// before acting on chain events
+const monitorConfidence = 3
+
+// ReplicationPolicy controls how the deal monitor keeps client data stored
+type ReplicationPolicy struct {
+	// Copies is the number of live deals each piece of stored data is kept
+	// in. When deals fail, or miners get slashed or report faults, new deals
+	// are proposed to Miners. 0 disables repair
+	Copies int
+
+	// RenewBefore is how many blocks before a deal ends a renewal is
+	// proposed to the same miner. 0 disables renewals
+	RenewBefore uint64
+
+	// Miners are the miners new deals are proposed to when repairing, in
+	// order of preference
+	Miners []address.Address
+
+	// MaxPricePerByteBlock is the highest ask price accepted for deals
+	// proposed by the monitor, nil means no limit
+	MaxPricePerByteBlock types.BigInt
+}
+
+func (p ReplicationPolicy) enabled() bool {
+	return p.Copies > 0 || p.RenewBefore > 0
+}
+
+type monitorApi interface {
+	ChainNotify(context.Context) (<-chan []*store.HeadChange, error)
+	ChainGetBlockMessages(context.Context, cid.Cid) (*api.BlockMessages, error)
+	ChainGetTipSetByHeight(context.Context, uint64, *types.TipSet) (*types.TipSet, error)
+
+	ClientStartDeal(ctx context.Context, data cid.Cid, mode api.SerializationMode, miner address.Address, price types.BigInt, blocksDuration uint64) (*cid.Cid, error)
+}
+
+// Monitor watches deals made by the client. It renews deals before they end,
+// and makes deals with other miners when deals are lost, so that data stays
+// stored in the number of copies set in the ReplicationPolicy
+type Monitor struct {
+	api    monitorApi
+	client *Client
+	sm     *stmgr.StateManager
+	policy ReplicationPolicy
+
+	events *events.Events
+
+	// only accessed from the run goroutine
+	watched   map[address.Address]bool
+	scheduled map[cid.Cid]bool
+
+	renewals chan cid.Cid
+	check    chan struct{}
+
+	stop chan struct{}
+}
+
+func NewMonitor(api monitorApi, client *Client, policy ReplicationPolicy) *Monitor {
+	return &Monitor{
+		api:    api,
+		client: client,
+		sm:     client.sm,
+		policy: policy,
+
+		watched:   map[address.Address]bool{},
+		scheduled: map[cid.Cid]bool{},
+
+		renewals: make(chan cid.Cid),
+		check:    make(chan struct{}, 1),
+
+		stop: make(chan struct{}),
+	}
+}
+
+func (m *Monitor) Run(ctx context.Context) {
+	if !m.policy.enabled() {
+		log.Info("deal monitor disabled by replication policy")
+		return
+	}
+
+	go m.run(ctx)
+}
+
+func (m *Monitor) run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	m.events = events.NewEvents(ctx, m.api)
+	updates := m.client.SubscribeUpdates(ctx)
+
+	deals, err := m.client.List()
+	if err != nil {
+		log.Errorf("deal monitor: listing deals: %s", err)
+		return
+	}
+	for _, deal := range deals {
+		m.track(deal)
+	}
+	m.recheck()
+
+	for {
+		select {
+		case deal, ok := <-updates:
+			if !ok {
+				return
+			}
+			m.track(deal)
+
+			switch deal.State {
+			case api.DealComplete, api.DealRejected, api.DealFailed, api.DealError:
+				m.recheck()
+			}
+		case id := <-m.renewals:
+			m.renew(ctx, id)
+		case <-m.check:
+			m.repair(ctx)
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// recheck schedules a replication check of all stored data
+func (m *Monitor) recheck() {
+	select {
+	case m.check <- struct{}{}:
+	default:
+	}
+}
+
+// track starts watching the miner of a complete deal, and schedules the deal
+// renewal
+func (m *Monitor) track(deal ClientDeal) {
+	if deal.State != api.DealComplete {
+		return
+	}
+
+	miner := deal.Proposal.MinerAddress
+	if !m.watched[miner] {
+		if err := m.watchMiner(miner); err != nil {
+			log.Errorf("deal monitor: watching miner %s: %s", miner, err)
+		} else {
+			m.watched[miner] = true
+		}
+	}
+
+	if m.policy.RenewBefore == 0 || deal.ReplacedBy != nil || deal.RenewFailed || m.scheduled[deal.ProposalCid] {
+		return
+	}
+
+	var renewAt uint64
+	if end := dealEnd(deal); end > m.policy.RenewBefore {
+		renewAt = end - m.policy.RenewBefore
+	}
+
+	id := deal.ProposalCid
+	renew := func(ts *types.TipSet, curH uint64) error {
+		go func() {
+			select {
+			case m.renewals <- id:
+			case <-m.stop:
+			}
+		}()
+		return nil
+	}
+	revert := func(ts *types.TipSet) error {
+		// the renewal deal was already proposed, and will stay valid
+		return nil
+	}
+
+	if err := m.events.ChainAt(renew, revert, monitorConfidence, renewAt); err != nil {
+		log.Errorf("deal monitor: scheduling renewal of deal %s: %s", id, err)
+		return
+	}
+	m.scheduled[id] = true
+}
+
+// watchMiner schedules a replication check each time a miner gets slashed or
+// reports faults
+func (m *Monitor) watchMiner(miner address.Address) error {
+	check := func(ts *types.TipSet) (bool, bool, error) {
+		return false, true, nil
+	}
+	called := func(msg *types.Message, ts *types.TipSet, curH uint64) (bool, error) {
+		log.Warnf("deal monitor: miner %s was slashed or reported faults (method %d)", miner, msg.Method)
+		m.recheck()
+		return true, nil
+	}
+	revert := func(ts *types.TipSet) error {
+		m.recheck()
+		return nil
+	}
+
+	methods := []uint64{
+		actors.MAMethods.SlashStorageFault,
+		actors.MAMethods.SlashConsensusFault,
+		actors.MAMethods.AddFaults,
+	}
+	for _, method := range methods {
+		if err := m.events.Called(check, called, revert, monitorConfidence, events.NoTimeout, miner, method); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// healthy returns false when the miner was slashed, or has faulty sectors
+func (m *Monitor) healthy(ctx context.Context, ts *types.TipSet, miner address.Address) (bool, error) {
+	slashed, err := stmgr.GetMinerSlashed(ctx, m.sm, ts, miner)
+	if err != nil {
+		return false, err
+	}
+	if slashed {
+		return false, nil
+	}
+
+	faults, err := stmgr.GetMinerFaults(ctx, m.sm, ts, miner)
+	if err != nil {
+		return false, err
+	}
+
+	return len(faults) == 0, nil
+}
+
+func (m *Monitor) renew(ctx context.Context, id cid.Cid) {
+	deal, err := m.client.GetDeal(id)
+	if err != nil {
+		log.Errorf("deal monitor: getting deal %s: %s", id, err)
+		return
+	}
+	if deal.ReplacedBy != nil || deal.RenewFailed {
+		return
+	}
+
+	miner := deal.Proposal.MinerAddress
+	newId, err := m.renewWith(ctx, deal, miner)
+	if err != nil {
+		log.Warnf("deal monitor: renewing deal %s with miner %s: %s", id, miner, err)
+
+		// repair will store the data with other miners
+		err := m.client.deals.MutateClient(id, func(d *ClientDeal) error {
+			d.RenewFailed = true
+			return nil
+		})
+		if err != nil {
+			log.Errorf("deal monitor: recording failed renewal of deal %s: %s", id, err)
+		}
+		m.recheck()
+		return
+	}
+
+	err = m.client.deals.MutateClient(id, func(d *ClientDeal) error {
+		d.ReplacedBy = &newId
+		return nil
+	})
+	if err != nil {
+		log.Errorf("deal monitor: recording renewal of deal %s: %s", id, err)
+		return
+	}
+
+	log.Infof("deal monitor: renewed deal %s with deal %s", id, newId)
+}
+
+func (m *Monitor) renewWith(ctx context.Context, deal ClientDeal, miner address.Address) (cid.Cid, error) {
+	ok, err := m.healthy(ctx, nil, miner)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("checking miner state: %w", err)
+	}
+	if !ok {
+		return cid.Undef, xerrors.New("miner was slashed or has faults")
+	}
+
+	return m.startDeal(ctx, deal, miner, deal.Proposal.Duration)
+}
+
+// repair proposes new deals for data stored in fewer live deals than the
+// policy requires
+func (m *Monitor) repair(ctx context.Context) {
+	if m.policy.Copies == 0 {
+		return
+	}
+
+	deals, err := m.client.List()
+	if err != nil {
+		log.Errorf("deal monitor: listing deals: %s", err)
+		return
+	}
+
+	ts := m.sm.ChainStore().GetHeaviestTipSet()
+
+	health := map[address.Address]bool{}
+	healthy := func(miner address.Address) bool {
+		ok, known := health[miner]
+		if !known {
+			var err error
+			ok, err = m.healthy(ctx, ts, miner)
+			if err != nil {
+				log.Warnf("deal monitor: checking miner %s: %s", miner, err)
+			}
+			health[miner] = ok
+		}
+		return ok
+	}
+
+	byData := map[cid.Cid][]ClientDeal{}
+	for _, deal := range deals {
+		byData[deal.Proposal.PieceRef] = append(byData[deal.Proposal.PieceRef], deal)
+	}
+
+	for data, deals := range byData {
+		var template *ClientDeal
+		var end uint64
+		live := 0
+		holders := map[address.Address]bool{}
+
+		for i, deal := range deals {
+			// data is kept stored while it has a complete deal which didn't end
+			if deal.State == api.DealComplete && dealEnd(deal) > end {
+				template = &deals[i]
+				end = dealEnd(deal)
+			}
+
+			if !m.live(deal, ts.Height(), healthy) {
+				continue
+			}
+			live++
+			holders[deal.Proposal.MinerAddress] = true
+		}
+
+		if template == nil || end <= ts.Height() || live >= m.policy.Copies {
+			continue
+		}
+
+		for _, miner := range m.policy.Miners {
+			if live >= m.policy.Copies {
+				break
+			}
+			if holders[miner] || !healthy(miner) {
+				continue
+			}
+
+			id, err := m.startDeal(ctx, *template, miner, end-ts.Height())
+			if err != nil {
+				log.Warnf("deal monitor: storing %s with miner %s: %s", data, miner, err)
+				continue
+			}
+
+			log.Infof("deal monitor: proposed deal %s with miner %s for %s", id, miner, data)
+			live++
+			holders[miner] = true
+		}
+
+		if live < m.policy.Copies {
+			log.Warnf("deal monitor: %s is stored in %d deals, policy requires %d", data, live, m.policy.Copies)
+		}
+	}
+}
+
+// live returns whether a deal counts as a copy of its data. Deals still in
+// progress count, so that repair doesn't propose too many deals
+func (m *Monitor) live(deal ClientDeal, h uint64, healthy func(address.Address) bool) bool {
+	switch deal.State {
+	case api.DealUnknown, api.DealAccepted, api.DealStaged, api.DealSealing, api.DealComplete:
+	default:
+		return false
+	}
+
+	if deal.ReplacedBy != nil || deal.RenewFailed || dealEnd(deal) <= h {
+		return false
+	}
+
+	return healthy(deal.Proposal.MinerAddress)
+}
+
+func (m *Monitor) startDeal(ctx context.Context, deal ClientDeal, miner address.Address, duration uint64) (cid.Cid, error) {
+	pid, err := stmgr.GetMinerPeerID(ctx, m.sm, nil, miner)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("getting miner peer ID: %w", err)
+	}
+
+	ask, err := m.client.QueryAsk(ctx, pid, miner)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("querying ask: %w", err)
+	}
+
+	if !m.policy.MaxPricePerByteBlock.Nil() && ask.Ask.Price.GreaterThan(m.policy.MaxPricePerByteBlock) {
+		return cid.Undef, xerrors.Errorf("ask price %s is above the limit of %s", ask.Ask.Price, m.policy.MaxPricePerByteBlock)
+	}
+
+	// deal price is per block, for the whole piece
+	price := types.BigMul(ask.Ask.Price, types.NewInt(deal.Proposal.Size))
+
+	id, err := m.api.ClientStartDeal(ctx, deal.Proposal.PieceRef, deal.Proposal.SerializationMode, miner, price, duration)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	return *id, nil
+}
+
+// dealEnd returns the height at which the last payment voucher of a deal
+// unlocks, which is when the deal ends
+func dealEnd(deal ClientDeal) uint64 {
+	var end uint64
+	for _, v := range deal.Proposal.Payment.Vouchers {
+		if v.TimeLock > end {
+			end = v.TimeLock
+		}
+	}
+	return end
+}
+
+func (m *Monitor) Stop() {
+	close(m.stop)
+}
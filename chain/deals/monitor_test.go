@@ -0,0 +1,88 @@
+package deals
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func monitorDeal(t *testing.T, i byte, miner address.Address, state api.DealState, timeLocks ...uint64) ClientDeal {
+	var vouchers []*types.SignedVoucher
+	for _, tl := range timeLocks {
+		vouchers = append(vouchers, &types.SignedVoucher{TimeLock: tl, Amount: types.NewInt(1)})
+	}
+
+	id := dealCid(t, i)
+	return ClientDeal{
+		ProposalCid: id,
+		Proposal: StorageDealProposal{
+			PieceRef:     id,
+			TotalPrice:   types.NewInt(0),
+			MinerAddress: miner,
+			Payment:      actors.PaymentInfo{Vouchers: vouchers},
+		},
+		State: state,
+	}
+}
+
+func TestDealEnd(t *testing.T) {
+	miner, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	require.Equal(t, uint64(0), dealEnd(monitorDeal(t, 1, miner, api.DealComplete)))
+	require.Equal(t, uint64(300), dealEnd(monitorDeal(t, 1, miner, api.DealComplete, 100, 300, 200)))
+}
+
+func TestMonitorLive(t *testing.T) {
+	good, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	slashed, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	healthy := func(miner address.Address) bool {
+		return miner != slashed
+	}
+
+	m := &Monitor{}
+
+	require.True(t, m.live(monitorDeal(t, 1, good, api.DealComplete, 200), 100, healthy))
+	require.True(t, m.live(monitorDeal(t, 1, good, api.DealStaged, 200), 100, healthy), "deals in progress are live")
+
+	require.False(t, m.live(monitorDeal(t, 1, slashed, api.DealComplete, 200), 100, healthy))
+	require.False(t, m.live(monitorDeal(t, 1, good, api.DealFailed, 200), 100, healthy))
+	require.False(t, m.live(monitorDeal(t, 1, good, api.DealComplete, 200), 200, healthy), "ended deals aren't live")
+
+	replaced := monitorDeal(t, 1, good, api.DealComplete, 200)
+	next := dealCid(t, 2)
+	replaced.ReplacedBy = &next
+	require.False(t, m.live(replaced, 100, healthy))
+
+	failed := monitorDeal(t, 1, good, api.DealComplete, 200)
+	failed.RenewFailed = true
+	require.False(t, m.live(failed, 100, healthy))
+}
+
+func TestRenewFailedPersisted(t *testing.T) {
+	miner, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	c := testClient()
+	deal := monitorDeal(t, 1, miner, api.DealComplete, 200)
+	require.NoError(t, c.deals.Begin(deal.ProposalCid, &deal))
+
+	require.NoError(t, c.deals.MutateClient(deal.ProposalCid, func(d *ClientDeal) error {
+		d.RenewFailed = true
+		return nil
+	}))
+
+	// a restarted monitor sees the failed renewal
+	stored, err := c.GetDeal(deal.ProposalCid)
+	require.NoError(t, err)
+	require.True(t, stored.RenewFailed)
+	require.False(t, (&Monitor{}).live(stored, 100, func(address.Address) bool { return true }))
+}
@@ -1,6 +1,8 @@
 package deals
 
 import (
+	"sync"
+
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/query"
@@ -10,6 +12,9 @@ import (
 
 type StateStore struct {
 	ds datastore.Datastore
+
+	// mutateLk makes mutations atomic, so checks done in mutators hold
+	mutateLk sync.Mutex
 }
 
 func (st *StateStore) Begin(i cid.Cid, state interface{}) error {
@@ -43,6 +48,9 @@ func (st *StateStore) End(i cid.Cid) error {
 }
 
 func (st *StateStore) mutate(i cid.Cid, mutator func([]byte) ([]byte, error)) error {
+	st.mutateLk.Lock()
+	defer st.mutateLk.Unlock()
+
 	k := datastore.NewKey(i.String())
 	has, err := st.ds.Has(k)
 	if err != nil {
@@ -65,6 +73,43 @@ func (st *StateStore) mutate(i cid.Cid, mutator func([]byte) ([]byte, error)) er
 	return st.ds.Put(k, mutated)
 }
 
+func (st *StateStore) get(i cid.Cid, out interface{}) error {
+	k := datastore.NewKey(i.String())
+	b, err := st.ds.Get(k)
+	if err == datastore.ErrNotFound {
+		return xerrors.Errorf("No state for %s", i)
+	}
+	if err != nil {
+		return err
+	}
+
+	return cbor.DecodeInto(b, out)
+}
+
+func (st *StateStore) list(each func([]byte) error) error {
+	res, err := st.ds.Query(query.Query{})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	for {
+		res, ok := res.NextSync()
+		if !ok {
+			break
+		}
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if err := each(res.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 type MinerStateStore struct {
 	StateStore
 }
@@ -89,6 +134,28 @@ func minerMutator(m func(*MinerDeal) error) func([]byte) ([]byte, error) {
 	}
 }
 
+func (st *MinerStateStore) GetMiner(i cid.Cid) (MinerDeal, error) {
+	var deal MinerDeal
+	err := st.get(i, &deal)
+	return deal, err
+}
+
+func (st *MinerStateStore) ListMiner() ([]MinerDeal, error) {
+	var out []MinerDeal
+
+	err := st.list(func(b []byte) error {
+		var deal MinerDeal
+		if err := cbor.DecodeInto(b, &deal); err != nil {
+			return err
+		}
+
+		out = append(out, deal)
+		return nil
+	})
+
+	return out, err
+}
+
 type ClientStateStore struct {
 	StateStore
 }
@@ -113,6 +180,12 @@ func clientMutator(m func(*ClientDeal) error) func([]byte) ([]byte, error) {
 	}
 }
 
+func (st *ClientStateStore) GetClient(i cid.Cid) (ClientDeal, error) {
+	var deal ClientDeal
+	err := st.get(i, &deal)
+	return deal, err
+}
+
 func (st *ClientStateStore) ListClient() ([]ClientDeal, error) {
 	var out []ClientDeal
 
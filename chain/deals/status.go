@@ -0,0 +1,166 @@
+package deals
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	inet "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/lib/cborrpc"
+)
+
+const dealStatusPollInterval = 30 * time.Second
+
+// commitLookupTimeout limits how long deal status queries wait for the
+// commitment tracker
+const commitLookupTimeout = 5 * time.Second
+
+func (h *Handler) HandleDealStatusStream(s inet.Stream) {
+	defer s.Close()
+
+	var req DealStatusRequest
+	if err := cborrpc.ReadCborRPC(s, &req); err != nil {
+		log.Errorf("failed to read DealStatusRequest from incoming stream: %s", err)
+		return
+	}
+
+	resp, err := h.signResponse(h.dealStatus(req.Proposal, s.Conn().RemotePeer()))
+	if err != nil {
+		log.Errorf("failed to sign deal status response: %s", err)
+		return
+	}
+
+	if err := cborrpc.WriteCborRPC(s, resp); err != nil {
+		log.Errorf("failed to write deal status response: %s", err)
+	}
+}
+
+// dealStatus reports a deal state in the same way it would be reported on
+// the deal stream
+func (h *Handler) dealStatus(id cid.Cid, client peer.ID) StorageDealResponse {
+	deal, err := h.deals.GetMiner(id)
+	if err != nil || deal.Client != client {
+		if err != nil {
+			log.Warnf("deal status query for %s: %s", id, err)
+		}
+
+		return StorageDealResponse{
+			State:    api.DealFailed,
+			Message:  "unknown deal",
+			Proposal: id,
+		}
+	}
+
+	resp := StorageDealResponse{
+		State:    deal.State,
+		Message:  deal.Message,
+		Proposal: id,
+	}
+
+	// Deals are stored in the state whose handler is running, the response
+	// for that state is sent when the handler finishes
+	switch deal.State {
+	case api.DealWaitingForData:
+		resp.State = api.DealAccepted
+	case api.DealSealing:
+		resp.State = api.DealStaged
+	case api.DealComplete:
+		resp.State = api.DealSealing
+		resp.PieceInclusionProof = deal.PieceInclusionProof
+		resp.CommD = deal.CommD
+
+		ctx, cancel := context.WithTimeout(context.TODO(), commitLookupTimeout)
+		defer cancel()
+
+		mcid, err := h.commt.WaitCommit(ctx, deal.Proposal.MinerAddress, deal.SectorID)
+		if err == nil {
+			resp.State = api.DealComplete
+			resp.SectorCommitMessage = &mcid
+		}
+	}
+
+	return resp
+}
+
+// dealProgress orders the states a successful deal goes through
+var dealProgress = map[api.DealState]int{
+	api.DealAccepted: 1,
+	api.DealStaged:   2,
+	api.DealSealing:  3,
+	api.DealComplete: 4,
+}
+
+// nextDealState is the response a client handler for a given state waits for
+var nextDealState = map[api.DealState]api.DealState{
+	api.DealUnknown:  api.DealAccepted,
+	api.DealAccepted: api.DealStaged,
+	api.DealStaged:   api.DealSealing,
+	api.DealSealing:  api.DealComplete,
+}
+
+func (c *Client) queryDealStatus(ctx context.Context, deal ClientDeal) (*StorageDealResponse, error) {
+	s, err := c.h.NewStream(ctx, deal.Miner, DealStatusProtocolID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	if err := cborrpc.WriteCborRPC(s, &DealStatusRequest{Proposal: deal.ProposalCid}); err != nil {
+		return nil, xerrors.Errorf("failed to send deal status request: %w", err)
+	}
+
+	var resp SignedStorageDealResponse
+	if err := cborrpc.ReadCborRPC(s, &resp); err != nil {
+		return nil, xerrors.Errorf("failed to read deal status response: %w", err)
+	}
+
+	if err := c.checkResponseSignature(&resp, deal.Proposal.MinerAddress); err != nil {
+		return nil, err
+	}
+
+	if resp.Response.Proposal != deal.ProposalCid {
+		return nil, xerrors.New("miner responded to a wrong proposal")
+	}
+
+	return &resp.Response, nil
+}
+
+// waitDealStatus polls the miner until the deal moves past its current
+// state. Used when the deal stream was lost
+func (c *Client) waitDealStatus(ctx context.Context, deal ClientDeal) (*StorageDealResponse, error) {
+	expect, ok := nextDealState[deal.State]
+	if !ok {
+		return nil, xerrors.Errorf("not expecting any response in state %d", deal.State)
+	}
+
+	for {
+		resp, err := c.queryDealStatus(ctx, deal)
+		if err != nil {
+			log.Warnf("querying status of deal %s: %s", deal.ProposalCid, err)
+		} else {
+			p, ok := dealProgress[resp.State]
+			if !ok {
+				// rejected or failed
+				return resp, nil
+			}
+
+			if p >= dealProgress[expect] {
+				// Responses for later states carry the data of earlier ones
+				resp.State = expect
+				return resp, nil
+			}
+		}
+
+		select {
+		case <-time.After(dealStatusPollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.stop:
+			return nil, xerrors.New("deal client stopped")
+		}
+	}
+}
@@ -21,22 +21,17 @@ func init() {
 
 	cbor.RegisterCborType(AskRequest{})
 	cbor.RegisterCborType(AskResponse{})
+
+	cbor.RegisterCborType(DealStatusRequest{})
 }
 
 const ProtocolID = "/fil/storage/mk/1.0.0"
 const AskProtocolID = "/fil/storage/ask/1.0.0"
-
-type SerializationMode string
-
-const (
-	SerializationUnixFs = "UnixFs"
-	SerializationRaw    = "Raw"
-	SerializationIPLD   = "IPLD"
-)
+const DealStatusProtocolID = "/fil/storage/status/1.0.0"
 
 type StorageDealProposal struct {
 	PieceRef          cid.Cid // TODO: port to spec
-	SerializationMode SerializationMode
+	SerializationMode api.SerializationMode
 	CommP             []byte
 
 	Size       uint64
@@ -47,6 +42,11 @@ type StorageDealProposal struct {
 
 	MinerAddress  address.Address
 	ClientAddress address.Address
+
+	// ManualTransfer is set when data is sent to the miner out of band, e.g.
+	// on physical disks. The miner waits for the data to be imported instead
+	// of fetching it from the client
+	ManualTransfer bool
 }
 
 type SignedStorageDealProposal struct {
@@ -90,3 +90,10 @@ type AskRequest struct {
 type AskResponse struct {
 	Ask *types.SignedStorageAsk
 }
+
+// DealStatusRequest is sent by clients to re-query the state of a deal, for
+// example after the deal stream was lost. The miner responds with a
+// SignedStorageDealResponse
+type DealStatusRequest struct {
+	Proposal cid.Cid
+}
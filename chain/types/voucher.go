@@ -8,6 +8,13 @@ import (
 	cbor "github.com/ipfs/go-ipld-cbor"
 )
 
+func init() {
+	// vouchers are embedded in refmt encoded deal messages
+	cbor.RegisterCborType(SignedVoucher{})
+	cbor.RegisterCborType(ModVerifyParams{})
+	cbor.RegisterCborType(Signature{})
+}
+
 type SignedVoucher struct {
 	TimeLock       uint64
 	SecretPreimage []byte
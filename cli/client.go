@@ -2,14 +2,18 @@ package cli
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
 	"strconv"
+	"text/tabwriter"
 
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"golang.org/x/xerrors"
 	"gopkg.in/urfave/cli.v2"
 
+	lapi "github.com/filecoin-project/lotus/api"
 	actors "github.com/filecoin-project/lotus/chain/actors"
 	"github.com/filecoin-project/lotus/chain/address"
 	"github.com/filecoin-project/lotus/chain/types"
@@ -22,8 +26,13 @@ var clientCmd = &cli.Command{
 		clientImportCmd,
 		clientLocalCmd,
 		clientDealCmd,
+		clientPlaceCmd,
+		clientListDealsCmd,
+		clientGetDealCmd,
 		clientFindCmd,
 		clientRetrieveCmd,
+		clientRetrieveDagCmd,
+		clientListRetrievalsCmd,
 		clientQueryAskCmd,
 	},
 }
@@ -31,6 +40,12 @@ var clientCmd = &cli.Command{
 var clientImportCmd = &cli.Command{
 	Name:  "import",
 	Usage: "Import data",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "car",
+			Usage: "import blocks of an IPLD DAG from a CAR file",
+		},
+	},
 	Action: func(cctx *cli.Context) error {
 		api, closer, err := GetFullNodeAPI(cctx)
 		if err != nil {
@@ -43,7 +58,12 @@ var clientImportCmd = &cli.Command{
 			return err
 		}
 
-		c, err := api.ClientImport(ctx, absPath)
+		importFn := api.ClientImport
+		if cctx.Bool("car") {
+			importFn = api.ClientImportCar
+		}
+
+		c, err := importFn(ctx, absPath)
 		if err != nil {
 			return err
 		}
@@ -77,6 +97,17 @@ var clientLocalCmd = &cli.Command{
 var clientDealCmd = &cli.Command{
 	Name:  "deal",
 	Usage: "Initialize storage deal with a miner",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "serialization",
+			Usage: "how data is stored in the piece: UnixFs (file contents), Raw (data of raw DAG leaves) or IPLD (all DAG blocks)",
+			Value: string(lapi.SerializationUnixFs),
+		},
+		&cli.BoolFlag{
+			Name:  "manual-transfer",
+			Usage: "send data to the miner out of band, the miner has to import it with 'lotus-storage-miner deals import-data'",
+		},
+	},
 	Action: func(cctx *cli.Context) error {
 		api, closer, err := GetFullNodeAPI(cctx)
 		if err != nil {
@@ -112,7 +143,19 @@ var clientDealCmd = &cli.Command{
 			return err
 		}
 
-		proposal, err := api.ClientStartDeal(ctx, data, miner, types.NewInt(uint64(price)), uint64(dur))
+		mode := lapi.SerializationMode(cctx.String("serialization"))
+		switch mode {
+		case lapi.SerializationUnixFs, lapi.SerializationRaw, lapi.SerializationIPLD:
+		default:
+			return xerrors.Errorf("unknown serialization mode: '%s'", mode)
+		}
+
+		startDeal := api.ClientStartDeal
+		if cctx.Bool("manual-transfer") {
+			startDeal = api.ClientStartOfflineDeal
+		}
+
+		proposal, err := startDeal(ctx, data, mode, miner, types.NewInt(uint64(price)), uint64(dur))
 		if err != nil {
 			return err
 		}
@@ -122,6 +165,83 @@ var clientDealCmd = &cli.Command{
 	},
 }
 
+var clientPlaceCmd = &cli.Command{
+	Name:      "place",
+	Usage:     "Start storage deals with multiple miners picked by price, power and past deals",
+	ArgsUsage: "<dataCid> <replicas> <maxPrice> <duration>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "serialization",
+			Usage: "how data is stored in the piece: UnixFs (file contents), Raw (data of raw DAG leaves) or IPLD (all DAG blocks)",
+			Value: string(lapi.SerializationUnixFs),
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		if cctx.NArg() != 4 {
+			return xerrors.New("expected 4 args: dataCid, replicas, maxPrice, duration")
+		}
+
+		data, err := cid.Parse(cctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+
+		replicas, err := strconv.ParseInt(cctx.Args().Get(1), 10, 32)
+		if err != nil {
+			return xerrors.Errorf("parsing replica count: %w", err)
+		}
+
+		maxPrice, err := types.BigFromString(cctx.Args().Get(2))
+		if err != nil {
+			return xerrors.Errorf("parsing max price: %w", err)
+		}
+
+		dur, err := strconv.ParseInt(cctx.Args().Get(3), 10, 32)
+		if err != nil {
+			return err
+		}
+
+		mode := lapi.SerializationMode(cctx.String("serialization"))
+		switch mode {
+		case lapi.SerializationUnixFs, lapi.SerializationRaw, lapi.SerializationIPLD:
+		default:
+			return xerrors.Errorf("unknown serialization mode: '%s'", mode)
+		}
+
+		placements, err := api.ClientStartDeals(ctx, data, mode, int(replicas), maxPrice, uint64(dur))
+		if err != nil {
+			return err
+		}
+
+		started := 0
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Miner\tPrice\tPower\tDeal\n")
+		for _, p := range placements {
+			outcome := "error: " + p.Err
+			if p.Deal != nil {
+				outcome = p.Deal.String()
+				started++
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Miner, p.Price, p.Power, outcome)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if started < int(replicas) {
+			return xerrors.Errorf("started %d of %d deals", started, replicas)
+		}
+		return nil
+	},
+}
+
 var clientFindCmd = &cli.Command{
 	Name:  "find",
 	Usage: "find data in the network",
@@ -164,7 +284,7 @@ var clientFindCmd = &cli.Command{
 				fmt.Printf("ERR %s@%s: %s\n", offer.Miner, offer.MinerPeerID, offer.Err)
 				continue
 			}
-			fmt.Printf("RETRIEVAL %s@%s-%sfil-%db\n", offer.Miner, offer.MinerPeerID, offer.MinPrice, offer.Size)
+			fmt.Printf("RETRIEVAL %s@%s-%sfil-%db (unseal %sfil)\n", offer.Miner, offer.MinerPeerID, offer.MinPrice, offer.Size, offer.UnsealPrice)
 		}
 
 		return nil
@@ -179,6 +299,19 @@ var clientRetrieveCmd = &cli.Command{
 			Name:  "address",
 			Usage: "address to use for transactions",
 		},
+		&cli.Uint64Flag{
+			Name:  "offset",
+			Usage: "byte offset of unixfs data to start retrieving at",
+		},
+		&cli.Uint64Flag{
+			Name:  "length",
+			Usage: "number of bytes of unixfs data to retrieve, defaults to all data after the offset",
+		},
+		&cli.IntFlag{
+			Name:  "parallel",
+			Usage: "number of miners to retrieve from at once, other miners are used when one fails",
+			Value: 1,
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		if cctx.NArg() != 2 {
@@ -227,10 +360,105 @@ var clientRetrieveCmd = &cli.Command{
 
 		// TODO: parse offer strings from `client find`, make this smarter
 
-		order := offers[0].Order()
+		var orders []lapi.RetrievalOrder
+		for _, offer := range offers {
+			if offer.Err != "" {
+				continue
+			}
+
+			order := offer.Order()
+			order.Client = payer
+			order.Offset = cctx.Uint64("offset")
+			order.Length = cctx.Uint64("length")
+			orders = append(orders, order)
+		}
+		if len(orders) == 0 {
+			return xerrors.New("no miners offered to retrieve the data")
+		}
+
+		if cctx.Int("parallel") > 1 {
+			err = api.ClientRetrieveMulti(ctx, orders, cctx.Int("parallel"), cctx.Args().Get(1))
+		} else {
+			// interrupted unixfs retrievals into the same file are resumed
+			err = api.ClientRetrieve(ctx, orders[0], cctx.Args().Get(1))
+		}
+		if err == nil {
+			fmt.Println("Success")
+		}
+		return err
+	},
+}
+
+var clientRetrieveDagCmd = &cli.Command{
+	Name:  "retrieve-dag",
+	Usage: "retrieve a sub-DAG into a CAR file",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "address",
+			Usage: "address to use for transactions",
+		},
+		&cli.StringFlag{
+			Name:  "path",
+			Usage: "path from the root to the retrieved sub-DAG",
+		},
+		&cli.Int64Flag{
+			Name:  "depth",
+			Usage: "number of links to follow from the selected block, -1 for all",
+			Value: -1,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 2 {
+			fmt.Println("Usage: retrieve-dag [CID] [outfile.car]")
+			return nil
+		}
+
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		var payer address.Address
+		if cctx.String("address") != "" {
+			payer, err = address.NewFromString(cctx.String("address"))
+		} else {
+			payer, err = api.WalletDefaultAddress(ctx)
+		}
+		if err != nil {
+			return err
+		}
+
+		root, err := cid.Parse(cctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+
+		offers, err := api.ClientFindData(ctx, root)
+		if err != nil {
+			return err
+		}
+
+		var order *lapi.RetrievalOrder
+		for _, offer := range offers {
+			if offer.Err == "" {
+				o := offer.Order()
+				order = &o
+				break
+			}
+		}
+		if order == nil {
+			return xerrors.Errorf("no miners offer retrieval of %s", root)
+		}
 		order.Client = payer
 
-		err = api.ClientRetrieve(ctx, order, cctx.Args().Get(1))
+		sel := lapi.RetrievalSelector{
+			Path:  cctx.String("path"),
+			Depth: cctx.Int64("depth"),
+		}
+
+		err = api.ClientRetrieveSelector(ctx, *order, sel, cctx.Args().Get(1))
 		if err == nil {
 			fmt.Println("Success")
 		}
@@ -238,6 +466,31 @@ var clientRetrieveCmd = &cli.Command{
 	},
 }
 
+var clientListRetrievalsCmd = &cli.Command{
+	Name:  "list-retrievals",
+	Usage: "List retrieval deals",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		deals, err := api.ClientListRetrievals(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "ID\tRoot\tMiner\tState\tTransferred\tPaid\tPaych\tLane\tMessage\n")
+		for _, d := range deals {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%d\t%s\n", d.ID, d.Root, d.Peer, d.State, d.Transferred, d.Paid, d.Paych, d.Lane, d.Err)
+		}
+		return w.Flush()
+	},
+}
+
 var clientQueryAskCmd = &cli.Command{
 	Name:  "query-ask",
 	Usage: "find a miners ask",
@@ -325,3 +578,110 @@ var clientQueryAskCmd = &cli.Command{
 		return nil
 	},
 }
+
+var clientListDealsCmd = &cli.Command{
+	Name:  "list-deals",
+	Usage: "List storage deals",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "watch",
+			Usage: "keep printing deal state changes",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		var updates <-chan lapi.DealInfo
+		if cctx.Bool("watch") {
+			// subscribe first so no state change is missed
+			updates, err = api.ClientGetDealUpdates(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		deals, err := api.ClientListDeals(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "ProposalCid\tMiner\tState\tSize\tPrice\tDuration\tMessage\n")
+		for _, d := range deals {
+			printDealRow(w, d)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if updates == nil {
+			return nil
+		}
+
+		for d := range updates {
+			printDealRow(w, d)
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+func printDealRow(w io.Writer, d lapi.DealInfo) {
+	fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%d\t%s\n", d.ProposalCid, d.Miner, d.State, d.Size, d.TotalPrice, d.Duration, d.Message)
+}
+
+var clientGetDealCmd = &cli.Command{
+	Name:      "get-deal",
+	Usage:     "Print detailed deal information",
+	ArgsUsage: "<proposal-cid>",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		if !cctx.Args().Present() {
+			return xerrors.New("must specify deal proposal cid")
+		}
+
+		propCid, err := cid.Decode(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		d, err := api.ClientGetDealInfo(ctx, propCid)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("ProposalCid:\t%s\n", d.ProposalCid)
+		fmt.Printf("State:\t\t%s\n", d.State)
+		fmt.Printf("Message:\t%s\n", d.Message)
+		fmt.Printf("Miner:\t\t%s\n", d.Miner)
+		fmt.Printf("PieceRef:\t%s\n", d.PieceRef)
+		fmt.Printf("Serialization:\t%s\n", d.SerializationMode)
+		fmt.Printf("ManualTransfer:\t%t\n", d.ManualTransfer)
+		fmt.Printf("CommP:\t\t%x\n", d.CommP)
+		fmt.Printf("Size:\t\t%d\n", d.Size)
+		fmt.Printf("TotalPrice:\t%s\n", d.TotalPrice)
+		fmt.Printf("Duration:\t%d\n", d.Duration)
+
+		if len(d.CommD) > 0 {
+			fmt.Printf("CommD:\t\t%x\n", d.CommD)
+			fmt.Printf("InclusionProof:\t%x\n", d.PieceInclusionProof)
+		}
+		if d.SectorCommitMessage != nil {
+			fmt.Printf("CommitMessage:\t%s\n", d.SectorCommitMessage)
+		}
+		return nil
+	},
+}
@@ -3,6 +3,7 @@ package cli
 import (
 	"fmt"
 
+	lapi "github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/address"
 	types "github.com/filecoin-project/lotus/chain/types"
 	"gopkg.in/urfave/cli.v2"
@@ -14,6 +15,10 @@ var paychCmd = &cli.Command{
 	Subcommands: []*cli.Command{
 		paychGetCmd,
 		paychListCmd,
+		paychAddFundsCmd,
+		paychSettleCmd,
+		paychCollectCmd,
+		paychSettleUpdatesCmd,
 		paychVoucherCmd,
 	},
 }
@@ -83,6 +88,137 @@ var paychListCmd = &cli.Command{
 	},
 }
 
+var paychAddFundsCmd = &cli.Command{
+	Name:  "add-funds",
+	Usage: "Add funds to an outbound payment channel",
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 2 {
+			return fmt.Errorf("must pass two arguments: <channel> <amount>")
+		}
+
+		ch, err := address.NewFromString(cctx.Args().Get(0))
+		if err != nil {
+			return fmt.Errorf("failed to parse payment channel address: %s", err)
+		}
+
+		amt, err := types.BigFromString(cctx.Args().Get(1))
+		if err != nil {
+			return fmt.Errorf("parsing amount failed: %s", err)
+		}
+
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := ReqContext(cctx)
+
+		mcid, err := api.PaychAddFunds(ctx, ch, amt)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(mcid)
+		return nil
+	},
+}
+
+var paychSettleCmd = &cli.Command{
+	Name:  "settle",
+	Usage: "Start closing a payment channel",
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return fmt.Errorf("must pass payment channel address")
+		}
+
+		ch, err := address.NewFromString(cctx.Args().Get(0))
+		if err != nil {
+			return fmt.Errorf("failed to parse payment channel address: %s", err)
+		}
+
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := ReqContext(cctx)
+
+		mcid, err := api.PaychSettle(ctx, ch)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(mcid)
+		return nil
+	},
+}
+
+var paychCollectCmd = &cli.Command{
+	Name:  "collect",
+	Usage: "Collect funds of a closed payment channel",
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return fmt.Errorf("must pass payment channel address")
+		}
+
+		ch, err := address.NewFromString(cctx.Args().Get(0))
+		if err != nil {
+			return fmt.Errorf("failed to parse payment channel address: %s", err)
+		}
+
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := ReqContext(cctx)
+
+		mcid, err := api.PaychCollect(ctx, ch)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(mcid)
+		return nil
+	},
+}
+
+var paychSettleUpdatesCmd = &cli.Command{
+	Name:  "settle-updates",
+	Usage: "Print updates on settlement of inbound channels closed by the payer",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := ReqContext(cctx)
+
+		updates, err := api.PaychSettleUpdates(ctx)
+		if err != nil {
+			return err
+		}
+
+		for u := range updates {
+			switch u.State {
+			case lapi.PaychSettleClosing:
+				fmt.Printf("%s: closing at %d\n", u.Channel, u.ClosingAt)
+			case lapi.PaychSettleVoucherSubmitted:
+				fmt.Printf("%s: submitted voucher for %s on lane %d in %s\n", u.Channel, u.Amount, u.Lane, u.Message)
+			case lapi.PaychSettleCollected:
+				fmt.Printf("%s: collected %s\n", u.Channel, u.Amount)
+			default:
+				fmt.Printf("%s: %s: %s\n", u.Channel, u.State, u.Err)
+			}
+		}
+		return nil
+	},
+}
+
 var paychVoucherCmd = &cli.Command{
 	Name:  "voucher",
 	Usage: "Interact with payment channel vouchers",
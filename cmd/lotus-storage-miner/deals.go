@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+	"gopkg.in/urfave/cli.v2"
+
+	lcli "github.com/filecoin-project/lotus/cli"
+)
+
+var dealsCmd = &cli.Command{
+	Name:  "deals",
+	Usage: "interact with storage deals",
+	Subcommands: []*cli.Command{
+		dealsListCmd,
+		dealsInspectCmd,
+		dealsRejectCmd,
+		dealsImportDataCmd,
+	},
+}
+
+var dealsImportDataCmd = &cli.Command{
+	Name:      "import-data",
+	Usage:     "import data of a manual transfer deal (file contents for UnixFs deals, a CAR file for Raw and IPLD deals)",
+	ArgsUsage: "<proposal-cid> <file>",
+	Action: func(cctx *cli.Context) error {
+		nodeApi, closer, err := lcli.GetStorageMinerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := lcli.ReqContext(cctx)
+
+		if cctx.NArg() != 2 {
+			return xerrors.New("expected 2 args: proposal-cid, file")
+		}
+
+		propCid, err := cid.Decode(cctx.Args().Get(0))
+		if err != nil {
+			return xerrors.Errorf("parsing proposal cid: %w", err)
+		}
+
+		fpath, err := filepath.Abs(cctx.Args().Get(1))
+		if err != nil {
+			return err
+		}
+
+		return nodeApi.MarketImportDealData(ctx, propCid, fpath)
+	},
+}
+
+var dealsListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "list storage deals",
+	Action: func(cctx *cli.Context) error {
+		nodeApi, closer, err := lcli.GetStorageMinerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := lcli.ReqContext(cctx)
+
+		deals, err := nodeApi.MarketListIncomingDeals(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "ProposalCid\tClient\tState\tSize\tPrice\tDuration\tSector\n")
+		for _, d := range deals {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\n", d.ProposalCid, d.ClientAddress, d.State, sizeStr(d.Size), d.TotalPrice, d.Duration, d.SectorID)
+		}
+		return w.Flush()
+	},
+}
+
+var dealsInspectCmd = &cli.Command{
+	Name:      "inspect",
+	Usage:     "print details of a storage deal",
+	ArgsUsage: "<proposal-cid>",
+	Action: func(cctx *cli.Context) error {
+		nodeApi, closer, err := lcli.GetStorageMinerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := lcli.ReqContext(cctx)
+
+		if !cctx.Args().Present() {
+			return xerrors.New("must specify deal proposal cid")
+		}
+
+		propCid, err := cid.Decode(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing proposal cid: %w", err)
+		}
+
+		d, err := nodeApi.MarketGetDeal(ctx, propCid)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("ProposalCid:\t%s\n", d.ProposalCid)
+		fmt.Printf("State:\t\t%s\n", d.State)
+		if d.Message != "" {
+			fmt.Printf("Message:\t%s\n", d.Message)
+		}
+		fmt.Printf("Client:\t\t%s (peer %s)\n", d.ClientAddress, d.Client)
+		fmt.Printf("PieceRef:\t%s\n", d.PieceRef)
+		fmt.Printf("Serialization:\t%s\n", d.SerializationMode)
+		fmt.Printf("ManualTransfer:\t%t\n", d.ManualTransfer)
+		fmt.Printf("CommP:\t\t%x\n", d.CommP)
+		fmt.Printf("Size:\t\t%d (%s)\n", d.Size, sizeStr(d.Size))
+		fmt.Printf("TotalPrice:\t%s\n", d.TotalPrice)
+		fmt.Printf("Duration:\t%d\n", d.Duration)
+		fmt.Printf("SectorID:\t%d\n", d.SectorID)
+		return nil
+	},
+}
+
+var dealsRejectCmd = &cli.Command{
+	Name:      "reject",
+	Usage:     "reject a deal which wasn't staged yet",
+	ArgsUsage: "<proposal-cid> [reason]",
+	Action: func(cctx *cli.Context) error {
+		nodeApi, closer, err := lcli.GetStorageMinerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := lcli.ReqContext(cctx)
+
+		if !cctx.Args().Present() {
+			return xerrors.New("must specify deal proposal cid")
+		}
+
+		propCid, err := cid.Decode(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing proposal cid: %w", err)
+		}
+
+		reason := strings.Join(cctx.Args().Tail(), " ")
+		if reason == "" {
+			reason = "rejected by the miner"
+		}
+
+		return nodeApi.MarketRejectDeal(ctx, propCid, reason)
+	},
+}
@@ -6,7 +6,6 @@ import (
 
 	"gopkg.in/urfave/cli.v2"
 
-	sectorstate "github.com/filecoin-project/go-sectorbuilder/sealing_state"
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/types"
 	lcli "github.com/filecoin-project/lotus/cli"
@@ -50,7 +49,8 @@ var infoCmd = &cli.Command{
 			return err
 		}
 
-		fmt.Println("Sealed Sectors:\t", sinfo.SealedCount)
+		fmt.Println("Proving Sectors:\t", sinfo.ProvingCount)
+		fmt.Println("Committing Sectors:\t", sinfo.CommittingCount)
 		fmt.Println("Sealing Sectors:\t", sinfo.SealingCount)
 		fmt.Println("Pending Sectors:\t", sinfo.PendingCount)
 		fmt.Println("Failed Sectors:\t", sinfo.FailedCount)
@@ -64,11 +64,12 @@ var infoCmd = &cli.Command{
 }
 
 type SectorsInfo struct {
-	TotalCount   int
-	SealingCount int
-	FailedCount  int
-	SealedCount  int
-	PendingCount int
+	TotalCount      int
+	PendingCount    int
+	SealingCount    int
+	CommittingCount int
+	ProvingCount    int
+	FailedCount     int
 }
 
 func sectorsInfo(ctx context.Context, napi api.StorageMiner) (*SectorsInfo, error) {
@@ -87,15 +88,16 @@ func sectorsInfo(ctx context.Context, napi api.StorageMiner) (*SectorsInfo, erro
 		}
 
 		switch st.State {
-		case sectorstate.Sealed:
-			out.SealedCount++
-		case sectorstate.Pending:
+		case api.SectorPending:
 			out.PendingCount++
-		case sectorstate.Sealing:
+		case api.SectorPacking, api.SectorSealing:
 			out.SealingCount++
-		case sectorstate.Failed:
+		case api.SectorCommitting:
+			out.CommittingCount++
+		case api.SectorProving:
+			out.ProvingCount++
+		case api.SectorFailed:
 			out.FailedCount++
-		case sectorstate.Unknown:
 		}
 	}
 
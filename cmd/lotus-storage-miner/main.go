@@ -24,6 +24,12 @@ func main() {
 		infoCmd,
 		storeGarbageCmd,
 		sectorsCmd,
+		storageCmd,
+		setPriceCmd,
+		getAskCmd,
+		dealsCmd,
+		retrievalDealsCmd,
+		unsealedCmd,
 	}
 	jaeger := tracing.SetupJaegerTracing("lotus")
 	defer func() {
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/xerrors"
+	"gopkg.in/urfave/cli.v2"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+)
+
+var setPriceCmd = &cli.Command{
+	Name:      "set-price",
+	Usage:     "set the price (in attoFIL per byte per block) of storage deals",
+	ArgsUsage: "<price>",
+	Flags: []cli.Flag{
+		&cli.Uint64Flag{
+			Name:  "min-piece-size",
+			Usage: "smallest piece accepted in deals, in bytes (defaults to the current ask)",
+		},
+		&cli.DurationFlag{
+			Name:  "ttl",
+			Usage: "how long the ask is valid for, it will be renewed automatically (defaults to the current ask)",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		nodeApi, closer, err := lcli.GetStorageMinerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := lcli.ReqContext(cctx)
+
+		if !cctx.Args().Present() {
+			return xerrors.Errorf("must specify price to set")
+		}
+
+		price, err := types.BigFromString(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing price: %w", err)
+		}
+
+		minPieceSize := cctx.Uint64("min-piece-size")
+		ttl := int64(cctx.Duration("ttl") / time.Second)
+
+		if !cctx.IsSet("min-piece-size") || !cctx.IsSet("ttl") {
+			cur, err := nodeApi.MarketGetAsk(ctx)
+			if err != nil {
+				return xerrors.Errorf("getting current ask (set --min-piece-size and --ttl to skip): %w", err)
+			}
+
+			if !cctx.IsSet("min-piece-size") {
+				minPieceSize = cur.Ask.MinPieceSize
+			}
+			if !cctx.IsSet("ttl") {
+				ttl = cur.Ask.Expiry - cur.Ask.Timestamp
+			}
+		}
+
+		return nodeApi.MarketSetAsk(ctx, price, minPieceSize, ttl)
+	},
+}
+
+var getAskCmd = &cli.Command{
+	Name:  "get-ask",
+	Usage: "print the current storage ask",
+	Action: func(cctx *cli.Context) error {
+		nodeApi, closer, err := lcli.GetStorageMinerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := lcli.ReqContext(cctx)
+
+		ask, err := nodeApi.MarketGetAsk(ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Miner:\t\t%s\n", ask.Ask.Miner)
+		fmt.Printf("Price:\t\t%s\n", ask.Ask.Price)
+		fmt.Printf("MinPieceSize:\t%d\n", ask.Ask.MinPieceSize)
+		fmt.Printf("Timestamp:\t%s\n", time.Unix(ask.Ask.Timestamp, 0))
+		fmt.Printf("Expiry:\t\t%s\n", time.Unix(ask.Ask.Expiry, 0))
+		fmt.Printf("SeqNo:\t\t%d\n", ask.Ask.SeqNo)
+		return nil
+	},
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"gopkg.in/urfave/cli.v2"
+
+	lcli "github.com/filecoin-project/lotus/cli"
+)
+
+var retrievalDealsCmd = &cli.Command{
+	Name:  "retrieval-deals",
+	Usage: "interact with retrieval deals",
+	Subcommands: []*cli.Command{
+		retrievalDealsListCmd,
+	},
+}
+
+var retrievalDealsListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "list served retrieval deals",
+	Action: func(cctx *cli.Context) error {
+		nodeApi, closer, err := lcli.GetStorageMinerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := lcli.ReqContext(cctx)
+
+		deals, err := nodeApi.MarketListRetrievalDeals(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "ID\tRoot\tClient\tState\tTransferred\tPaid\tPaych\tLane\tMessage\n")
+		for _, d := range deals {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n", d.ID, d.Root, d.Peer, d.State, sizeStr(d.Transferred), d.Paid, d.Paych, d.Lane, d.Err)
+		}
+		return w.Flush()
+	},
+}
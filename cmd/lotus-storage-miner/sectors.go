@@ -2,10 +2,13 @@ package main
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
+	"time"
 
 	"gopkg.in/urfave/cli.v2"
 
+	"github.com/filecoin-project/lotus/api"
 	lcli "github.com/filecoin-project/lotus/cli"
 )
 
@@ -35,8 +38,9 @@ var sectorsCmd = &cli.Command{
 	Usage: "interact with sector store",
 	Subcommands: []*cli.Command{
 		sectorsStatusCmd,
-		sectorsStagedListCmd,
+		sectorsListCmd,
 		sectorsStagedSealCmd,
+		sectorsRetryCmd,
 		sectorsRefsCmd,
 	},
 }
@@ -67,20 +71,31 @@ var sectorsStatusCmd = &cli.Command{
 		}
 
 		fmt.Printf("SectorID:\t%d\n", status.SectorID)
-		fmt.Printf("Status:\t%s\n", status.State.String())
-		fmt.Printf("SealErrorMsg:\t%q\n", status.SealErrorMsg)
+		fmt.Printf("Status:\t\t%s\n", status.State)
+		if status.State == api.SectorFailed {
+			fmt.Printf("FailedAt:\t%s\n", status.FailedAt)
+			fmt.Printf("LastErr:\t%q\n", status.LastErr)
+		}
 		fmt.Printf("CommD:\t\t%x\n", status.CommD)
 		fmt.Printf("CommR:\t\t%x\n", status.CommR)
 		fmt.Printf("CommR*:\t\t%x\n", status.CommRStar)
 		fmt.Printf("Proof:\t\t%x\n", status.Proof)
 		fmt.Printf("Pieces:\t\t%v\n", status.Pieces)
+		if status.CommitMessage != nil {
+			fmt.Printf("CommitMsg:\t%s\n", status.CommitMessage)
+		}
+
+		fmt.Println("Event Log:")
+		for _, l := range status.Log {
+			fmt.Printf("\t%s\t%s\t%s\n", time.Unix(int64(l.Timestamp), 0).Format(time.Stamp), l.State, l.Message)
+		}
 		return nil
 	},
 }
 
-var sectorsStagedListCmd = &cli.Command{
-	Name:  "list-staged", // TODO: nest this under a 'staged' subcommand? idk
-	Usage: "List staged sectors",
+var sectorsListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List sectors and their states",
 	Action: func(cctx *cli.Context) error {
 		nodeApi, closer, err := lcli.GetStorageMinerAPI(cctx)
 		if err != nil {
@@ -89,13 +104,23 @@ var sectorsStagedListCmd = &cli.Command{
 		defer closer()
 		ctx := lcli.ReqContext(cctx)
 
-		staged, err := nodeApi.SectorsList(ctx)
+		sectors, err := nodeApi.SectorsList(ctx)
 		if err != nil {
 			return err
 		}
 
-		for _, s := range staged {
-			fmt.Println(s)
+		sort.Slice(sectors, func(i, j int) bool {
+			return sectors[i] < sectors[j]
+		})
+
+		for _, s := range sectors {
+			st, err := nodeApi.SectorsStatus(ctx, s)
+			if err != nil {
+				fmt.Printf("%d:\tError: %s\n", s, err)
+				continue
+			}
+
+			fmt.Printf("%d:\t%s\n", s, st.State)
 		}
 		return nil
 	},
@@ -116,6 +141,31 @@ var sectorsStagedSealCmd = &cli.Command{
 	},
 }
 
+var sectorsRetryCmd = &cli.Command{
+	Name:      "retry",
+	Usage:     "Retry the step a failed sector failed at",
+	ArgsUsage: "[sectorId]",
+	Action: func(cctx *cli.Context) error {
+		nodeApi, closer, err := lcli.GetStorageMinerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := lcli.ReqContext(cctx)
+
+		if !cctx.Args().Present() {
+			return fmt.Errorf("must specify sector ID to retry")
+		}
+
+		id, err := strconv.ParseUint(cctx.Args().First(), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		return nodeApi.SectorsRetry(ctx, id)
+	},
+}
+
 var sectorsRefsCmd = &cli.Command{
 	Name:  "refs",
 	Usage: "List References to sectors",
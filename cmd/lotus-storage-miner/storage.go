@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/mitchellh/go-homedir"
+	"golang.org/x/xerrors"
+	"gopkg.in/urfave/cli.v2"
+
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/lib/sectorbuilder"
+)
+
+var storageCmd = &cli.Command{
+	Name:  "storage",
+	Usage: "manage sealed sector storage",
+	Subcommands: []*cli.Command{
+		storageAttachCmd,
+		storageListCmd,
+	},
+}
+
+var storageAttachCmd = &cli.Command{
+	Name:      "attach",
+	Usage:     "attach a path sealed sectors can be stored in",
+	ArgsUsage: "<path>",
+	Flags: []cli.Flag{
+		&cli.Uint64Flag{
+			Name:  "weight",
+			Usage: "relative preference of this path",
+			Value: 10,
+		},
+		&cli.Uint64Flag{
+			Name:  "max-size",
+			Usage: "max bytes of sealed sectors to keep in this path, 0 means no limit",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		nodeApi, closer, err := lcli.GetStorageMinerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := lcli.ReqContext(cctx)
+
+		if !cctx.Args().Present() {
+			return xerrors.Errorf("must specify a path to attach")
+		}
+
+		p, err := homedir.Expand(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+		p, err = filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+
+		return nodeApi.StorageAttach(ctx, sectorbuilder.StoragePath{
+			Path:     p,
+			Weight:   cctx.Uint64("weight"),
+			MaxBytes: cctx.Uint64("max-size"),
+		})
+	},
+}
+
+var storageListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "list attached storage paths",
+	Action: func(cctx *cli.Context) error {
+		nodeApi, closer, err := lcli.GetStorageMinerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := lcli.ReqContext(cctx)
+
+		paths, err := nodeApi.StorageList(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(paths) == 0 {
+			fmt.Println("No storage paths attached, sealed sectors are kept in the miner repo")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 8, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Path\tWeight\tSectors\tUsed\tAvailable\tLimit\n")
+		for _, p := range paths {
+			limit := "none"
+			if p.MaxBytes > 0 {
+				limit = sizeStr(p.MaxBytes)
+			}
+
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\n", p.Path, p.Weight, p.Sectors, sizeStr(p.Used), sizeStr(p.Available), limit)
+		}
+		return w.Flush()
+	},
+}
+
+func sizeStr(size uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+
+	f := float64(size)
+	i := 0
+	for f >= 1024 && i+1 < len(units) {
+		f /= 1024
+		i++
+	}
+
+	return fmt.Sprintf("%.3g %s", f, units[i])
+}
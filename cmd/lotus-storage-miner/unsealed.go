@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/urfave/cli.v2"
+
+	lcli "github.com/filecoin-project/lotus/cli"
+)
+
+var unsealedCmd = &cli.Command{
+	Name:  "unsealed",
+	Usage: "manage the cache of unsealed pieces",
+	Subcommands: []*cli.Command{
+		unsealedListCmd,
+		unsealedGcCmd,
+	},
+}
+
+var unsealedListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "list cached unsealed pieces",
+	Action: func(cctx *cli.Context) error {
+		nodeApi, closer, err := lcli.GetStorageMinerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := lcli.ReqContext(cctx)
+
+		info, err := nodeApi.UnsealedList(ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Size: %s of %s\n", sizeStr(info.Size), sizeStr(info.MaxSize))
+		fmt.Printf("Reads: %d hits, %d misses, %d evictions\n", info.Hits, info.Misses, info.Evictions)
+
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Piece\tSize\tLast Used\n")
+		for _, p := range info.Pieces {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", p.Key, sizeStr(p.Size), p.LastUsed.Format(time.Stamp))
+		}
+		return w.Flush()
+	},
+}
+
+var unsealedGcCmd = &cli.Command{
+	Name:  "gc",
+	Usage: "remove least recently used unsealed pieces",
+	Flags: []cli.Flag{
+		&cli.Uint64Flag{
+			Name:  "keep",
+			Usage: "bytes of unsealed pieces to keep, removes all pieces by default",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		nodeApi, closer, err := lcli.GetStorageMinerAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := lcli.ReqContext(cctx)
+
+		res, err := nodeApi.UnsealedGC(ctx, cctx.Uint64("keep"))
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Removed %d pieces, freed %s\n", res.Removed, sizeStr(res.Freed))
+		return nil
+	},
+}
@@ -0,0 +1,35 @@
+package pubsubutil
+
+import (
+	"context"
+
+	"github.com/whyrusleeping/pubsub"
+)
+
+// Subscribe calls cb with each value published to topic until ctx is
+// cancelled, and then calls done. cb must return once ctx is cancelled.
+//
+// The subscription is drained while it's being removed, so publishers never
+// block on subscribers which went away
+func Subscribe(ctx context.Context, ps *pubsub.PubSub, topic string, cb func(interface{}), done func()) {
+	sub := ps.Sub(topic)
+
+	go func() {
+		defer done()
+		for {
+			select {
+			case val, ok := <-sub:
+				if !ok {
+					return
+				}
+				cb(val)
+			case <-ctx.Done():
+				go ps.Unsub(sub)
+				// drain until Unsub closes the channel
+				for range sub {
+				}
+				return
+			}
+		}
+	}()
+}
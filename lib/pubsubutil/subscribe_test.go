@@ -0,0 +1,52 @@
+package pubsubutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/whyrusleeping/pubsub"
+)
+
+func TestSubscribe(t *testing.T) {
+	ps := pubsub.New(1)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	out := make(chan int)
+	Subscribe(ctx, ps, "topic", func(v interface{}) {
+		select {
+		case out <- v.(int):
+		case <-ctx.Done():
+		}
+	}, func() {
+		close(out)
+	})
+
+	ps.Pub(1, "topic")
+	ps.Pub(2, "other")
+	ps.Pub(3, "topic")
+
+	require.Equal(t, 1, <-out)
+	require.Equal(t, 3, <-out)
+
+	cancel()
+
+	_, ok := <-out
+	require.False(t, ok, "channel should be closed when ctx is cancelled")
+
+	// publishing to a topic nobody reads from anymore doesn't block
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			ps.Pub(i, "topic")
+		}
+		close(published)
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing blocked after the subscriber went away")
+	}
+}
@@ -6,11 +6,13 @@ import (
 	"os"
 	"sort"
 	"sync"
+	"time"
 	"unsafe"
 
 	sectorbuilder "github.com/filecoin-project/go-sectorbuilder"
-
+	"github.com/filecoin-project/go-sectorbuilder/sealing_state"
 	logging "github.com/ipfs/go-log"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/lotus/chain/address"
 )
@@ -29,6 +31,13 @@ const CommLen = sectorbuilder.CommitmentBytesLen
 
 type SectorBuilder struct {
 	handle unsafe.Pointer
+	cfg    *SectorBuilderConfig
+
+	localLk      sync.Mutex
+	localSealing map[uint64]struct{}
+
+	storageLk sync.Mutex
+	storage   []StoragePath
 }
 
 type SectorBuilderConfig struct {
@@ -37,19 +46,44 @@ type SectorBuilderConfig struct {
 	SealedDir   string
 	StagedDir   string
 	MetadataDir string
+
+	// SealedPaths are additional paths sealed sectors can be moved to
+	SealedPaths []StoragePath
+
+	// MaxStagedSectors limits the number of open staged sectors, staged
+	// sectors over the limit get sealed. As all staged sectors are sealed
+	// together, it's also the most sectors sealed at once. 0 means
+	// defaultMaxStagedSectors
+	MaxStagedSectors int
 }
 
+const defaultMaxStagedSectors = 16
+
 func New(cfg *SectorBuilderConfig) (*SectorBuilder, error) {
 	proverId := addressToProverID(cfg.Miner)
 
-	sbp, err := sectorbuilder.InitSectorBuilder(cfg.SectorSize, 2, 1, 1, cfg.MetadataDir, proverId, cfg.SealedDir, cfg.StagedDir, 16)
+	maxStaged := cfg.MaxStagedSectors
+	if maxStaged <= 0 || maxStaged > defaultMaxStagedSectors {
+		maxStaged = defaultMaxStagedSectors
+	}
+
+	sbp, err := sectorbuilder.InitSectorBuilder(cfg.SectorSize, 2, 1, 1, cfg.MetadataDir, proverId, cfg.SealedDir, cfg.StagedDir, uint8(maxStaged))
 	if err != nil {
 		return nil, err
 	}
 
-	return &SectorBuilder{
+	sb := &SectorBuilder{
 		handle: sbp,
-	}, nil
+		cfg:    cfg,
+
+		localSealing: map[uint64]struct{}{},
+	}
+
+	if err := sb.loadStorage(); err != nil {
+		return nil, xerrors.Errorf("loading storage paths: %w", err)
+	}
+
+	return sb, nil
 }
 
 func addressToProverID(a address.Address) [31]byte {
@@ -88,11 +122,49 @@ func (sb *SectorBuilder) ReadPieceFromSealedSector(pieceKey string) ([]byte, err
 }
 
 func (sb *SectorBuilder) SealAllStagedSectors() error {
+	staged, err := sb.GetAllStagedSectors()
+	if err != nil {
+		return err
+	}
+
+	sb.localLk.Lock()
+	for _, id := range staged {
+		sb.localSealing[id] = struct{}{}
+	}
+	sb.localLk.Unlock()
+
 	return sectorbuilder.SealAllStagedSectors(sb.handle)
 }
 
 func (sb *SectorBuilder) SealStatus(sector uint64) (SectorSealingStatus, error) {
-	return sectorbuilder.GetSectorSealingStatusByID(sb.handle, sector)
+	status, err := sectorbuilder.GetSectorSealingStatusByID(sb.handle, sector)
+	if err != nil {
+		return SectorSealingStatus{}, err
+	}
+
+	sb.trackLocal(sector, status.State)
+
+	return status, nil
+}
+
+// trackLocal keeps track of sectors being sealed by rust-sectorbuilder, once
+// a sector is sealed it gets moved to attached storage
+func (sb *SectorBuilder) trackLocal(sector uint64, state sealing_state.State) {
+	sb.localLk.Lock()
+	defer sb.localLk.Unlock()
+
+	switch state {
+	case sealing_state.Sealing:
+		// full sectors get sealed without SealAllStagedSectors being called
+		sb.localSealing[sector] = struct{}{}
+	case sealing_state.Sealed, sealing_state.Failed:
+		if _, ok := sb.localSealing[sector]; !ok {
+			return
+		}
+		delete(sb.localSealing, sector)
+
+		time.AfterFunc(relocateGrace, sb.relocateLocal)
+	}
 }
 
 func (sb *SectorBuilder) GetAllStagedSectors() ([]uint64, error) {
@@ -6,6 +6,8 @@ import (
 	"math/rand"
 	"testing"
 
+	"github.com/filecoin-project/go-sectorbuilder/sealing_state"
+
 	"github.com/filecoin-project/lotus/chain/address"
 	"github.com/filecoin-project/lotus/lib/sectorbuilder"
 	"github.com/filecoin-project/lotus/storage/sector"
@@ -36,13 +38,20 @@ func TestSealAndVerify(t *testing.T) {
 
 	r := io.LimitReader(rand.New(rand.NewSource(42)), 1016)
 
-	if _, err := sb.AddPiece("foo", 1016, r); err != nil {
+	store := sector.NewStore(sb)
+	store.Service()
+	incoming := store.Incoming()
+
+	if _, err := store.AddPiece("foo", 1016, r, 0); err != nil {
 		t.Fatal(err)
 	}
 
-	store := sector.NewStore(sb)
-	store.Service()
-	ssinfo := <-store.Incoming()
+	var ssinfo sectorbuilder.SectorSealingStatus
+	for ssinfo = range incoming {
+		if ssinfo.State == sealing_state.Sealed {
+			break
+		}
+	}
 
 	ok, err := sectorbuilder.VerifySeal(1024, ssinfo.CommR[:], ssinfo.CommD[:], ssinfo.CommRStar[:], addr, ssinfo.SectorID, ssinfo.Proof)
 	if err != nil {
@@ -0,0 +1,336 @@
+package sectorbuilder
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const storageMeta = "storage.json"
+
+// sealed files modified more recently than this may still be in use by
+// rust-sectorbuilder, and aren't moved
+const relocateGrace = time.Minute
+
+// StoragePath is a directory sealed sectors can be kept in
+type StoragePath struct {
+	Path string
+
+	// Weight is the relative preference of this path, new sectors go to the
+	// path with the most free space multiplied by weight
+	Weight uint64
+
+	// MaxBytes limits how much sealed data is kept in this path, 0 means
+	// no limit
+	MaxBytes uint64
+}
+
+type StoragePathInfo struct {
+	StoragePath
+
+	Sectors   int
+	Used      uint64 // bytes of sealed sectors kept in the path
+	Available uint64 // bytes which can still be used for sealed sectors
+}
+
+// Sealed storage
+
+// TODO: rust-sectorbuilder only knows about a single sealed sector directory,
+//  so sealed sectors are moved to attached paths after sealing, and symlinked
+//  back into SealedDir
+
+func (sb *SectorBuilder) loadStorage() error {
+	for _, p := range sb.cfg.SealedPaths {
+		if err := sb.addStorage(p); err != nil {
+			return xerrors.Errorf("adding storage path %s from config: %w", p.Path, err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(sb.cfg.MetadataDir, storageMeta))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var attached []StoragePath
+	if err := json.Unmarshal(b, &attached); err != nil {
+		return err
+	}
+
+	for _, p := range attached {
+		if err := sb.addStorage(p); err != nil {
+			return xerrors.Errorf("adding attached storage path %s: %w", p.Path, err)
+		}
+	}
+
+	return nil
+}
+
+func (sb *SectorBuilder) addStorage(p StoragePath) error {
+	if !filepath.IsAbs(p.Path) {
+		return xerrors.Errorf("storage path must be absolute")
+	}
+	p.Path = filepath.Clean(p.Path)
+
+	for _, sp := range sb.storage {
+		if sp.Path == p.Path {
+			return xerrors.Errorf("path already attached")
+		}
+	}
+
+	if err := os.MkdirAll(p.Path, 0755); err != nil {
+		return err
+	}
+
+	sb.storage = append(sb.storage, p)
+	return nil
+}
+
+// AttachStorage adds a path new sealed sectors can be moved to. The path is
+// remembered across restarts
+func (sb *SectorBuilder) AttachStorage(p StoragePath) error {
+	sb.storageLk.Lock()
+	defer sb.storageLk.Unlock()
+
+	if err := sb.addStorage(p); err != nil {
+		return err
+	}
+
+	// move sectors sealed before the path was attached
+	go sb.relocateLocal()
+
+	var attached []StoragePath
+	for _, sp := range sb.storage {
+		if !sb.fromConfig(sp.Path) {
+			attached = append(attached, sp)
+		}
+	}
+
+	b, err := json.Marshal(attached)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(sb.cfg.MetadataDir, storageMeta), b, 0644)
+}
+
+func (sb *SectorBuilder) fromConfig(path string) bool {
+	for _, p := range sb.cfg.SealedPaths {
+		if filepath.Clean(p.Path) == path {
+			return true
+		}
+	}
+	return false
+}
+
+// StorageList returns usage information of attached sealed storage paths
+func (sb *SectorBuilder) StorageList() ([]StoragePathInfo, error) {
+	sb.storageLk.Lock()
+	defer sb.storageLk.Unlock()
+
+	out := make([]StoragePathInfo, len(sb.storage))
+	for i, p := range sb.storage {
+		info, err := storageInfo(p)
+		if err != nil {
+			return nil, xerrors.Errorf("getting info for %s: %w", p.Path, err)
+		}
+		out[i] = info
+	}
+
+	return out, nil
+}
+
+func storageInfo(p StoragePath) (StoragePathInfo, error) {
+	out := StoragePathInfo{StoragePath: p}
+
+	files, err := ioutil.ReadDir(p.Path)
+	if err != nil {
+		return StoragePathInfo{}, err
+	}
+	for _, f := range files {
+		if !f.Mode().IsRegular() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		out.Sectors++
+		out.Used += uint64(f.Size())
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(p.Path, &st); err != nil {
+		return StoragePathInfo{}, err
+	}
+	out.Available = st.Bavail * uint64(st.Bsize)
+
+	if p.MaxBytes > 0 {
+		switch {
+		case out.Used >= p.MaxBytes:
+			out.Available = 0
+		case p.MaxBytes-out.Used < out.Available:
+			out.Available = p.MaxBytes - out.Used
+		}
+	}
+
+	return out, nil
+}
+
+// pickStorage selects the path a sealed sector of the given size should be
+// kept in. Must be called with storageLk held
+func (sb *SectorBuilder) pickStorage(size uint64) (string, error) {
+	var best string
+	var bestScore uint64
+
+	for _, p := range sb.storage {
+		info, err := storageInfo(p)
+		if err != nil {
+			log.Warnf("getting info for storage path %s: %s", p.Path, err)
+			continue
+		}
+		if info.Available < size {
+			continue
+		}
+
+		weight := p.Weight
+		if weight == 0 {
+			weight = 1
+		}
+
+		if score := (info.Available / 1024) * weight; best == "" || score > bestScore {
+			best, bestScore = p.Path, score
+		}
+	}
+
+	if best == "" {
+		return "", xerrors.Errorf("no storage path has %d bytes available", size)
+	}
+	return best, nil
+}
+
+// relocateSealed moves a sealed sector file into one of the attached
+// storage paths, leaving a symlink in its place
+func (sb *SectorBuilder) relocateSealed(src string, name string) error {
+	sb.storageLk.Lock()
+	defer sb.storageLk.Unlock()
+
+	if len(sb.storage) == 0 {
+		return nil
+	}
+
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil // already moved
+	}
+
+	dir, err := sb.pickStorage(uint64(fi.Size()))
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(dir, name)
+
+	log.Infof("moving sealed sector %s to %s", src, dst)
+
+	if err := moveFile(src, dst); err != nil {
+		return xerrors.Errorf("moving %s to %s: %w", src, dst, err)
+	}
+
+	return os.Symlink(dst, src)
+}
+
+// relocateLocal moves sectors sealed by rust-sectorbuilder into attached
+// storage paths. Sectors which are still being sealed are left in place
+func (sb *SectorBuilder) relocateLocal() {
+	files, err := ioutil.ReadDir(sb.cfg.SealedDir)
+	if err != nil {
+		log.Errorf("listing sealed sectors: %s", err)
+		return
+	}
+
+	for _, f := range files {
+		if !f.Mode().IsRegular() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		if time.Since(f.ModTime()) < relocateGrace {
+			continue
+		}
+
+		id, known := sealedSectorID(f.Name())
+
+		sb.localLk.Lock()
+		_, sealing := sb.localSealing[id]
+		// files we can't tell the sector of are only moved when nothing is
+		// being sealed
+		busy := (known && sealing) || (!known && len(sb.localSealing) > 0)
+		sb.localLk.Unlock()
+		if busy {
+			continue
+		}
+
+		if err := sb.relocateSealed(filepath.Join(sb.cfg.SealedDir, f.Name()), f.Name()); err != nil {
+			log.Errorf("relocating sealed sector %s: %s", f.Name(), err)
+		}
+	}
+}
+
+// sealedSectorID returns the ID of the sector kept in a sealed file, which
+// rust-sectorbuilder names with the sector ID after the last dash
+func sealedSectorID(name string) (uint64, bool) {
+	id, err := strconv.ParseUint(name[strings.LastIndex(name, "-")+1:], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	} else if lerr, ok := err.(*os.LinkError); !ok || lerr.Err != syscall.EXDEV {
+		return err
+	}
+
+	// different devices, copy the file over
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp")
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Remove(src)
+}
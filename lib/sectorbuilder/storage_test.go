@@ -0,0 +1,23 @@
+package sectorbuilder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealedSectorID(t *testing.T) {
+	id, ok := sealedSectorID("on-0123abcd-42")
+	require.True(t, ok)
+	require.Equal(t, uint64(42), id)
+
+	id, ok = sealedSectorID("7")
+	require.True(t, ok)
+	require.Equal(t, uint64(7), id)
+
+	_, ok = sealedSectorID("remote-")
+	require.False(t, ok)
+
+	_, ok = sealedSectorID("sector-abc")
+	require.False(t, ok)
+}
@@ -0,0 +1,162 @@
+package statestore
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+)
+
+// StateStore keeps cbor-encoded state records in a datastore, keyed by
+// uint64 ids or anything with a String method (cids, addresses)
+type StateStore struct {
+	ds datastore.Datastore
+}
+
+func New(ds datastore.Datastore) *StateStore {
+	return &StateStore{ds: ds}
+}
+
+func toKey(k interface{}) datastore.Key {
+	switch t := k.(type) {
+	case uint64:
+		return datastore.NewKey(fmt.Sprint(t))
+	case fmt.Stringer:
+		return datastore.NewKey(t.String())
+	default:
+		panic(fmt.Sprintf("unexpected state key type %T", k))
+	}
+}
+
+func (st *StateStore) Begin(i interface{}, state interface{}) error {
+	k := toKey(i)
+	has, err := st.ds.Has(k)
+	if err != nil {
+		return err
+	}
+	if has {
+		return xerrors.Errorf("Already tracking state for %v", i)
+	}
+
+	b, err := cbor.DumpObject(state)
+	if err != nil {
+		return err
+	}
+
+	return st.ds.Put(k, b)
+}
+
+func (st *StateStore) End(i interface{}) error {
+	k := toKey(i)
+	has, err := st.ds.Has(k)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return xerrors.Errorf("No state for %v", i)
+	}
+	return st.ds.Delete(k)
+}
+
+func (st *StateStore) Has(i interface{}) (bool, error) {
+	return st.ds.Has(toKey(i))
+}
+
+// Mutate applies mutator to the record stored under i. mutator must be
+// a func(*T) error, where T is the type of the stored record
+func (st *StateStore) Mutate(i interface{}, mutator interface{}) error {
+	return st.mutate(i, cborMutator(mutator))
+}
+
+func cborMutator(mutator interface{}) func([]byte) ([]byte, error) {
+	rmut := reflect.ValueOf(mutator)
+
+	return func(in []byte) ([]byte, error) {
+		state := reflect.New(rmut.Type().In(0).Elem())
+
+		err := cbor.DecodeInto(in, state.Interface())
+		if err != nil {
+			return nil, err
+		}
+
+		out := rmut.Call([]reflect.Value{state})
+
+		if err := out[0].Interface(); err != nil {
+			return nil, err.(error)
+		}
+
+		return cbor.DumpObject(state.Interface())
+	}
+}
+
+func (st *StateStore) mutate(i interface{}, mutator func([]byte) ([]byte, error)) error {
+	k := toKey(i)
+	has, err := st.ds.Has(k)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return xerrors.Errorf("No state for %v", i)
+	}
+
+	cur, err := st.ds.Get(k)
+	if err != nil {
+		return err
+	}
+
+	mutated, err := mutator(cur)
+	if err != nil {
+		return err
+	}
+
+	return st.ds.Put(k, mutated)
+}
+
+// Get decodes the record stored under i into out
+func (st *StateStore) Get(i interface{}, out interface{}) error {
+	k := toKey(i)
+	val, err := st.ds.Get(k)
+	if err != nil {
+		if xerrors.Is(err, datastore.ErrNotFound) {
+			return xerrors.Errorf("No state for %v: %w", i, err)
+		}
+		return err
+	}
+
+	return cbor.DecodeInto(val, out)
+}
+
+// List decodes all stored records into out, which must be a pointer to a slice
+func (st *StateStore) List(out interface{}) error {
+	res, err := st.ds.Query(query.Query{})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	outT := reflect.TypeOf(out).Elem().Elem()
+	rout := reflect.ValueOf(out)
+
+	for {
+		res, ok := res.NextSync()
+		if !ok {
+			break
+		}
+		if res.Error != nil {
+			return res.Error
+		}
+
+		elem := reflect.New(outT)
+		err := cbor.DecodeInto(res.Value, elem.Interface())
+		if err != nil {
+			return err
+		}
+
+		rout.Elem().Set(reflect.Append(rout.Elem(), elem.Elem()))
+	}
+
+	return nil
+}
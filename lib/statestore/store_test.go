@@ -0,0 +1,43 @@
+package statestore
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/stretchr/testify/require"
+)
+
+type testState struct {
+	Name  string
+	Count uint64
+}
+
+func init() {
+	cbor.RegisterCborType(testState{})
+}
+
+func TestStateStore(t *testing.T) {
+	st := New(datastore.NewMapDatastore())
+
+	require.NoError(t, st.Begin(uint64(1), testState{Name: "a"}))
+	require.NoError(t, st.Begin(uint64(2), testState{Name: "b"}))
+	require.Error(t, st.Begin(uint64(1), testState{}), "double begin should fail")
+
+	require.NoError(t, st.Mutate(uint64(1), func(s *testState) error {
+		s.Count++
+		return nil
+	}))
+
+	var s testState
+	require.NoError(t, st.Get(uint64(1), &s))
+	require.Equal(t, testState{Name: "a", Count: 1}, s)
+
+	var all []testState
+	require.NoError(t, st.List(&all))
+	require.Len(t, all, 2)
+
+	require.NoError(t, st.End(uint64(2)))
+	require.Error(t, st.Mutate(uint64(2), func(s *testState) error { return nil }))
+	require.Error(t, st.Get(uint64(2), &s))
+}
@@ -43,6 +43,7 @@ import (
 	"github.com/filecoin-project/lotus/storage/commitment"
 	"github.com/filecoin-project/lotus/storage/sector"
 	"github.com/filecoin-project/lotus/storage/sectorblocks"
+	"github.com/filecoin-project/lotus/storage/unsealed"
 )
 
 // special is a type used to give keys to modules which
@@ -84,10 +85,13 @@ const (
 	HandleIncomingMessagesKey
 
 	RunDealClientKey
+	RunDealMonitorKey
+	RunPaychWatcherKey
 
 	// storage miner
 	HandleDealsKey
 	HandleRetrievalKey
+	ProvideRetrievalKey
 	RunSectorServiceKey
 	RegisterMinerKey
 
@@ -219,12 +223,19 @@ func Online() Option {
 			Override(new(*discovery.Local), discovery.NewLocal),
 			Override(new(discovery.PeerResolver), modules.RetrievalResolver),
 
+			Override(new(retrieval.ClientConfig), modules.RetrievalClientConfig(defConf.Client.Retrieval)),
 			Override(new(*retrieval.Client), retrieval.NewClient),
 			Override(new(*deals.Client), deals.NewClient),
 			Override(RunDealClientKey, modules.RunDealClient),
+			Override(new(deals.ReplicationPolicy), modules.ReplicationPolicy(defConf.Client.Replication)),
+			Override(new(*deals.Monitor), modules.DealMonitor),
+			Override(RunDealMonitorKey, modules.RunDealMonitor),
 
 			Override(new(*paych.Store), paych.NewStore),
+			Override(new(paych.FundingPolicy), modules.PaychFundingPolicy(defConf.Client.Paych)),
 			Override(new(*paych.Manager), paych.NewManager),
+			Override(new(*paych.Watcher), modules.PaychWatcher),
+			Override(RunPaychWatcherKey, modules.RunPaychWatcher),
 
 			Override(new(*miner.Miner), miner.NewMiner),
 		),
@@ -234,14 +245,21 @@ func Online() Option {
 			Override(new(*sectorbuilder.SectorBuilder), sectorbuilder.New),
 			Override(new(*sector.Store), sector.NewStore),
 			Override(new(*sectorblocks.SectorBlocks), sectorblocks.NewSectorBlocks),
+			Override(new(*unsealed.Cache), modules.UnsealedCache(defConf.StorageMiner.Unsealed)),
 			Override(new(*commitment.Tracker), commitment.NewTracker),
+			Override(new(*sectorbuilder.SectorBuilderConfig), modules.SectorBuilderConfig(defConf.StorageMiner.Storage.Path, defConf.StorageMiner.Storage.SealedPaths, defConf.StorageMiner.Sealing.MaxConcurrentSeals)),
+			Override(new(storage.Config), modules.StorageMinerConfig(defConf.StorageMiner)),
 			Override(new(*storage.Miner), modules.StorageMiner),
 
 			Override(new(dtypes.StagingDAG), modules.StagingDAG),
 
-			Override(new(*retrieval.Miner), retrieval.NewMiner),
+			Override(new(retrieval.MinerConfig), modules.RetrievalMinerConfig(defConf.StorageMiner.Retrieval)),
+			Override(new(*retrieval.Miner), modules.RetrievalMiner),
+			Override(new(deals.HandlerConfig), modules.DealHandlerConfig(defConf.StorageMiner.Dealmaking)),
+			Override(new(deals.DealFilter), modules.DealFilter(defConf.StorageMiner.Dealmaking)),
 			Override(new(*deals.Handler), deals.NewHandler),
 			Override(HandleRetrievalKey, modules.HandleRetrieval),
+			Override(ProvideRetrievalKey, modules.ProvideRetrievalPieces),
 			Override(HandleDealsKey, modules.HandleDeals),
 			Override(RunSectorServiceKey, modules.RunSectorService),
 			Override(RegisterMinerKey, modules.RegisterMiner),
@@ -286,6 +304,18 @@ func Config(cfg *config.Root) Option {
 
 			ApplyIf(func(s *Settings) bool { return s.nodeType == nodeFull },
 				Override(HeadMetricsKey, metrics.SendHeadNotifs(cfg.Metrics.Nickname)),
+				Override(new(deals.ReplicationPolicy), modules.ReplicationPolicy(cfg.Client.Replication)),
+				Override(new(retrieval.ClientConfig), modules.RetrievalClientConfig(cfg.Client.Retrieval)),
+				Override(new(paych.FundingPolicy), modules.PaychFundingPolicy(cfg.Client.Paych)),
+			),
+
+			ApplyIf(func(s *Settings) bool { return s.nodeType == nodeStorageMiner },
+				Override(new(*sectorbuilder.SectorBuilderConfig), modules.SectorBuilderConfig(cfg.StorageMiner.Storage.Path, cfg.StorageMiner.Storage.SealedPaths, cfg.StorageMiner.Sealing.MaxConcurrentSeals)),
+				Override(new(storage.Config), modules.StorageMinerConfig(cfg.StorageMiner)),
+				Override(new(deals.HandlerConfig), modules.DealHandlerConfig(cfg.StorageMiner.Dealmaking)),
+				Override(new(deals.DealFilter), modules.DealFilter(cfg.StorageMiner.Dealmaking)),
+				Override(new(retrieval.MinerConfig), modules.RetrievalMinerConfig(cfg.StorageMiner.Retrieval)),
+				Override(new(*unsealed.Cache), modules.UnsealedCache(cfg.StorageMiner.Unsealed)),
 			),
 		),
 	)
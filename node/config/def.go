@@ -8,6 +8,9 @@ type Root struct {
 	Libp2p Libp2p
 
 	Metrics Metrics
+
+	Client       Client
+	StorageMiner StorageMiner
 }
 
 // API contains configs for API endpoint
@@ -26,6 +29,189 @@ type Metrics struct {
 	Nickname string
 }
 
+// Client contains configs for the storage client of the full node
+type Client struct {
+	Replication Replication
+	Retrieval   ClientRetrieval
+	Paych       PaychFunding
+}
+
+// PaychFunding controls funding of outbound payment channels
+type PaychFunding struct {
+	// MinTopUp is the smallest amount payment channels are created or topped
+	// up with, in attoFIL, so that many small payments don't each need a
+	// message adding funds. Empty means only missing funds are added
+	MinTopUp string
+}
+
+// ClientRetrieval limits what the client pays miners for retrievals
+type ClientRetrieval struct {
+	// MaxPricePerByte is the highest price paid for a retrieved byte, in
+	// attoFIL. It's checked before each payment. Empty means no limit
+	MaxPricePerByte string
+
+	// MaxUnsealPrice is the highest unseal price paid upfront, in attoFIL.
+	// Empty means no limit
+	MaxUnsealPrice string
+
+	// MaxPaymentInterval is the most bytes paid for before they are
+	// received, smaller payment intervals offered by miners are used as is
+	MaxPaymentInterval uint64
+}
+
+// Replication controls automatic renewal and repair of storage deals made by
+// the client
+type Replication struct {
+	// Copies is the number of deals each piece of stored data is kept in.
+	// When deals fail, or miners get slashed or report faults, new deals are
+	// made with Miners. 0 disables repair
+	Copies int
+
+	// RenewBefore is how many blocks before a deal ends it gets renewed with
+	// the same miner. 0 disables renewals
+	RenewBefore uint64
+
+	// Miners are the miners new deals are made with when repairing, in
+	// order of preference
+	Miners []string
+
+	// MaxPricePerByteBlock is the highest ask price accepted for deals made
+	// automatically, in attoFIL. Empty means no limit
+	MaxPricePerByteBlock string
+}
+
+// StorageMiner contains configs for the storage miner node
+type StorageMiner struct {
+	Dealmaking Dealmaking
+	Sealing    Sealing
+	PoSt       PoSt
+	Storage    Storage
+	Payments   Payments
+	Retrieval  Retrieval
+	Unsealed   Unsealed
+}
+
+// Dealmaking contains the defaults used when accepting storage deals
+type Dealmaking struct {
+	// PricePerByteBlock is the price asked for storing one byte for one
+	// block, in attoFIL
+	PricePerByteBlock string
+
+	// MinPieceSize is the smallest piece accepted in a deal, in bytes
+	MinPieceSize uint64
+
+	// AskExpiry is how long the default ask stays valid
+	AskExpiry Duration
+
+	// AllowClients, when not empty, lists the only client addresses deals
+	// are accepted from
+	AllowClients []string
+	// DenyClients lists client addresses deals are never accepted from
+	DenyClients []string
+
+	// MaxPieceSize is the largest piece accepted in a deal, 0 means no limit
+	MaxPieceSize uint64
+	// MaxDuration is the longest deal accepted, in blocks, 0 means no limit
+	MaxDuration uint64
+
+	// Filter is a shell command deciding whether to accept a deal. It gets
+	// the deal proposal as JSON on stdin, and should exit with 0 to accept
+	// the deal
+	Filter string
+	// FilterTimeout is how long Filter can run before the deal is rejected
+	FilterTimeout Duration
+}
+
+// Sealing controls when staged sectors get sealed
+type Sealing struct {
+	// MaxConcurrentSeals limits the number of sectors being sealed at once,
+	// 0 means no limit
+	MaxConcurrentSeals int
+
+	// MaxWait is how long a partially filled sector can stay staged before
+	// it gets sealed. Sectors with higher priority deals wait proportionally
+	// shorter. 0 disables sealing based on wait time
+	MaxWait Duration
+
+	// FillRatio is how full (0-1) a staged sector needs to be to get
+	// sealed without waiting. 0 disables sealing based on fill ratio
+	FillRatio float64
+}
+
+// PoSt contains configs for proof-of-spacetime generation
+type PoSt struct {
+	// Confidence is the number of epochs to wait for before generating a
+	// PoSt after the proving period starts
+	Confidence int
+}
+
+// Payments controls redeeming of payment vouchers received in storage deals
+type Payments struct {
+	// RedeemThreshold is the unredeemed value on a payment channel lane,
+	// in attoFIL, above which vouchers get redeemed. When empty vouchers are
+	// only redeemed before channel deadlines
+	RedeemThreshold string
+
+	// DeadlineMargin is how many blocks before a payment channel can be
+	// closed, or before it closes, vouchers get redeemed
+	DeadlineMargin uint64
+}
+
+// Retrieval sets prices of retrieval deals
+type Retrieval struct {
+	// PricePerByte is the price of retrieving one byte, in attoFIL
+	PricePerByte string
+
+	// UnsealPrice is paid upfront by clients retrieving data which has to be
+	// unsealed first, in attoFIL
+	UnsealPrice string
+
+	// PaymentInterval is the most bytes sent for each payment, at least one
+	// unixfs chunk (1MiB)
+	PaymentInterval uint64
+
+	// MaxBytesPerSecond limits the rate retrieved data is sent at to all
+	// clients, MaxStreamBytesPerSecond to each retrieval deal. 0 means no
+	// limit
+	MaxBytesPerSecond       uint64
+	MaxStreamBytesPerSecond uint64
+}
+
+// Unsealed contains configs for the cache of unsealed pieces
+type Unsealed struct {
+	// Path is where unsealed pieces are kept, defaults to 'unsealed' in the
+	// miner repo when empty
+	Path string
+
+	// MaxBytes limits the size of the cache, least recently used pieces are
+	// removed when it's exceeded
+	MaxBytes uint64
+}
+
+// Storage contains configs for sector storage
+type Storage struct {
+	// Path is where sector metadata, staged and sealed sectors are kept,
+	// defaults to the miner repo when empty
+	Path string
+
+	// SealedPaths are additional paths sealed sectors are moved to. Paths
+	// can also be attached at runtime with 'lotus-storage-miner storage attach'
+	SealedPaths []SealedPath
+}
+
+// SealedPath is a path sealed sectors can be stored in
+type SealedPath struct {
+	Path string
+
+	// Weight is the relative preference of this path, new sectors go to the
+	// path with the most free space multiplied by weight
+	Weight uint64
+
+	// MaxBytes limits how much sealed data is kept in this path, 0 means
+	// no limit
+	MaxBytes uint64
+}
+
 // Default returns the default config
 func Default() *Root {
 	def := Root{
@@ -39,6 +225,41 @@ func Default() *Root {
 				"/ip6/::/tcp/0",
 			},
 		},
+		Client: Client{
+			Retrieval: ClientRetrieval{
+				MaxPaymentInterval: 8 << 20,
+			},
+			Paych: PaychFunding{
+				MinTopUp: "100000000",
+			},
+		},
+		StorageMiner: StorageMiner{
+			Dealmaking: Dealmaking{
+				PricePerByteBlock: "3",
+				MinPieceSize:      1,
+				AskExpiry:         Duration(1000000 * time.Second),
+				FilterTimeout:     Duration(time.Minute),
+			},
+			Sealing: Sealing{
+				MaxConcurrentSeals: 0,
+				MaxWait:            Duration(time.Hour),
+				FillRatio:          0.9,
+			},
+			PoSt: PoSt{
+				Confidence: 3,
+			},
+			Payments: Payments{
+				DeadlineMargin: 100,
+			},
+			Retrieval: Retrieval{
+				PricePerByte:    "2",
+				UnsealPrice:     "1000",
+				PaymentInterval: 1 << 20,
+			},
+			Unsealed: Unsealed{
+				MaxBytes: 32 << 30,
+			},
+		},
 	}
 	return &def
 }
@@ -61,3 +61,26 @@ func TestParitalConfig(t *testing.T) {
 			"config from reader should contain changes")
 	}
 }
+
+func TestStorageMinerConfig(t *testing.T) {
+	assert := assert.New(t)
+	cfgString := `
+		[StorageMiner.Dealmaking]
+		PricePerByteBlock = "10"
+
+		[StorageMiner.Sealing]
+		MaxWait = "30m"
+
+		[StorageMiner.Storage]
+		Path = "/data/sectors"
+		`
+	expected := Default()
+	expected.StorageMiner.Dealmaking.PricePerByteBlock = "10"
+	expected.StorageMiner.Sealing.MaxWait = Duration(30 * time.Minute)
+	expected.StorageMiner.Storage.Path = "/data/sectors"
+
+	cfg, err := FromReader(bytes.NewReader([]byte(cfgString)))
+	assert.NoError(err, "error should be nil")
+	assert.Equal(expected, cfg,
+		"config from reader should contain changes")
+}
@@ -6,23 +6,22 @@ import (
 	"golang.org/x/xerrors"
 	"io"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-car"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-filestore"
-	chunker "github.com/ipfs/go-ipfs-chunker"
 	offline "github.com/ipfs/go-ipfs-exchange-offline"
 	files "github.com/ipfs/go-ipfs-files"
 	cbor "github.com/ipfs/go-ipld-cbor"
 	ipld "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-merkledag"
-	"github.com/ipfs/go-unixfs/importer/balanced"
-	ihelper "github.com/ipfs/go-unixfs/importer/helpers"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"go.uber.org/fx"
 
 	"github.com/filecoin-project/lotus/api"
-	"github.com/filecoin-project/lotus/build"
 	"github.com/filecoin-project/lotus/chain/actors"
 	"github.com/filecoin-project/lotus/chain/address"
 	"github.com/filecoin-project/lotus/chain/deals"
@@ -53,13 +52,15 @@ type API struct {
 	Filestore  dtypes.ClientFilestore `optional:"true"`
 }
 
-func (a *API) ClientStartDeal(ctx context.Context, data cid.Cid, miner address.Address, price types.BigInt, blocksDuration uint64) (*cid.Cid, error) {
-	// TODO: make this a param
-	self, err := a.WalletDefaultAddress(ctx)
-	if err != nil {
-		return nil, err
-	}
+func (a *API) ClientStartDeal(ctx context.Context, data cid.Cid, mode api.SerializationMode, miner address.Address, price types.BigInt, blocksDuration uint64) (*cid.Cid, error) {
+	return a.startDeal(ctx, data, mode, miner, price, blocksDuration, false)
+}
+
+func (a *API) ClientStartOfflineDeal(ctx context.Context, data cid.Cid, mode api.SerializationMode, miner address.Address, price types.BigInt, blocksDuration uint64) (*cid.Cid, error) {
+	return a.startDeal(ctx, data, mode, miner, price, blocksDuration, true)
+}
 
+func (a *API) startDeal(ctx context.Context, data cid.Cid, mode api.SerializationMode, miner address.Address, price types.BigInt, blocksDuration uint64, manual bool) (*cid.Cid, error) {
 	// get miner peerID
 	msg := &types.Message{
 		To:     miner,
@@ -76,14 +77,26 @@ func (a *API) ClientStartDeal(ctx context.Context, data cid.Cid, miner address.A
 		return nil, err
 	}
 
-	vd, err := a.DealClient.VerifyParams(ctx, data)
+	vd, err := a.DealClient.VerifyParams(ctx, data, mode)
 	if err != nil {
 		return nil, err
 	}
 
+	c, err := a.proposeDeal(ctx, data, mode, vd, miner, pid, price, blocksDuration, manual)
+	return &c, err
+}
+
+// proposeDeal sets up payments for a deal and sends the proposal to the miner
+func (a *API) proposeDeal(ctx context.Context, data cid.Cid, mode api.SerializationMode, vd *actors.PieceInclVoucherData, miner address.Address, pid peer.ID, price types.BigInt, blocksDuration uint64, manual bool) (cid.Cid, error) {
+	// TODO: make this a param
+	self, err := a.WalletDefaultAddress(ctx)
+	if err != nil {
+		return cid.Undef, err
+	}
+
 	voucherData, err := cbor.DumpObject(vd)
 	if err != nil {
-		return nil, err
+		return cid.Undef, err
 	}
 
 	// setup payments
@@ -101,13 +114,14 @@ func (a *API) ClientStartDeal(ctx context.Context, data cid.Cid, miner address.A
 
 	payment, err := a.PaychNewPayment(ctx, self, miner, vouchers)
 	if err != nil {
-		return nil, err
+		return cid.Undef, err
 	}
 
 	proposal := deals.ClientDealProposal{
-		Data:       data,
-		TotalPrice: total,
-		Duration:   blocksDuration,
+		Data:              data,
+		SerializationMode: mode,
+		TotalPrice:        total,
+		Duration:          blocksDuration,
 		Payment: actors.PaymentInfo{
 			PayChActor:     payment.Channel,
 			Payer:          self,
@@ -117,11 +131,12 @@ func (a *API) ClientStartDeal(ctx context.Context, data cid.Cid, miner address.A
 		MinerAddress:  miner,
 		ClientAddress: self,
 		MinerID:       pid,
+
+		ManualTransfer: manual,
 	}
 
-	c, err := a.DealClient.Start(ctx, proposal, vd)
 	// TODO: send updated voucher with PaymentVerifySector for cheaper validation (validate the sector the miner sent us first!)
-	return &c, err
+	return a.DealClient.Start(ctx, proposal, vd)
 }
 
 func (a *API) ClientListDeals(ctx context.Context) ([]api.DealInfo, error) {
@@ -132,23 +147,66 @@ func (a *API) ClientListDeals(ctx context.Context) ([]api.DealInfo, error) {
 
 	out := make([]api.DealInfo, len(deals))
 	for k, v := range deals {
-		out[k] = api.DealInfo{
-			ProposalCid: v.ProposalCid,
-			State:       v.State,
-			Miner:       v.Proposal.MinerAddress,
+		out[k] = dealInfo(v)
+	}
 
-			PieceRef: v.Proposal.PieceRef,
-			CommP:    v.Proposal.CommP,
-			Size:     v.Proposal.Size,
+	return out, nil
+}
 
-			TotalPrice: v.Proposal.TotalPrice,
-			Duration:   v.Proposal.Duration,
-		}
+func (a *API) ClientGetDealInfo(ctx context.Context, d cid.Cid) (*api.DealInfo, error) {
+	v, err := a.DealClient.GetDeal(d)
+	if err != nil {
+		return nil, err
 	}
 
+	di := dealInfo(v)
+	return &di, nil
+}
+
+func (a *API) ClientGetDealUpdates(ctx context.Context) (<-chan api.DealInfo, error) {
+	updates := a.DealClient.SubscribeUpdates(ctx)
+
+	out := make(chan api.DealInfo)
+	go func() {
+		defer close(out)
+		for deal := range updates {
+			select {
+			case out <- dealInfo(deal):
+			case <-ctx.Done():
+			}
+		}
+	}()
+
 	return out, nil
 }
 
+func dealInfo(v deals.ClientDeal) api.DealInfo {
+	return api.DealInfo{
+		ProposalCid: v.ProposalCid,
+		State:       v.State,
+		Miner:       v.Proposal.MinerAddress,
+
+		PieceRef: v.Proposal.PieceRef,
+		CommP:    v.Proposal.CommP,
+		Size:     v.Proposal.Size,
+
+		TotalPrice: v.Proposal.TotalPrice,
+		Duration:   v.Proposal.Duration,
+
+		SerializationMode: v.Proposal.SerializationMode,
+		ManualTransfer:    v.Proposal.ManualTransfer,
+
+		Message: v.Message,
+
+		CommD:               v.CommD,
+		PieceInclusionProof: v.PieceInclusionProof.ProofElements,
+
+		SectorCommitMessage: v.SectorCommitMessage,
+
+		ReplacedBy: v.ReplacedBy,
+	}
+}
+
 func (a *API) ClientHasLocal(ctx context.Context, root cid.Cid) (bool, error) {
 	// TODO: check if we have the ENTIRE dag
 
@@ -164,15 +222,28 @@ func (a *API) ClientHasLocal(ctx context.Context, root cid.Cid) (bool, error) {
 }
 
 func (a *API) ClientFindData(ctx context.Context, root cid.Cid) ([]api.QueryOffer, error) {
-	peers, err := a.RetDiscovery.GetPeers(root)
+	peers, err := a.RetDiscovery.GetPeers(ctx, root)
 	if err != nil {
 		return nil, err
 	}
 
 	out := make([]api.QueryOffer, len(peers))
+	var wg sync.WaitGroup
+	wg.Add(len(peers))
 	for k, p := range peers {
-		out[k] = a.Retrieval.Query(ctx, p, root)
+		if p.Offer != nil {
+			// the resolver already queried the peer
+			out[k] = *p.Offer
+			wg.Done()
+			continue
+		}
+
+		go func(k int, p discovery.RetrievalPeer) {
+			defer wg.Done()
+			out[k] = a.Retrieval.Query(ctx, p, root)
+		}(k, p)
 	}
+	wg.Wait()
 
 	return out, nil
 }
@@ -192,50 +263,31 @@ func (a *API) ClientImport(ctx context.Context, path string) (cid.Cid, error) {
 		return cid.Undef, err
 	}
 
-	bufferedDS := ipld.NewBufferedDAG(ctx, a.LocalDAG)
-
-	params := ihelper.DagBuilderParams{
-		Maxlinks:   build.UnixfsLinksPerLevel,
-		RawLeaves:  true,
-		CidBuilder: nil,
-		Dagserv:    bufferedDS,
-		NoCopy:     true,
-	}
+	return deals.ImportFile(ctx, a.LocalDAG, file, true)
+}
 
-	db, err := params.New(chunker.NewSizeSplitter(file, int64(build.UnixfsChunkSize)))
+func (a *API) ClientImportCar(ctx context.Context, path string) (cid.Cid, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return cid.Undef, err
 	}
-	nd, err := balanced.Layout(db)
+	defer f.Close()
+
+	hdr, err := car.LoadCar(a.Blockstore, f)
 	if err != nil {
-		return cid.Undef, err
+		return cid.Undef, xerrors.Errorf("loading car file: %w", err)
+	}
+	if len(hdr.Roots) != 1 {
+		return cid.Undef, xerrors.Errorf("expected car file to have one root, got %d", len(hdr.Roots))
 	}
 
-	return nd.Cid(), bufferedDS.Commit()
+	return hdr.Roots[0], nil
 }
 
 func (a *API) ClientImportLocal(ctx context.Context, f io.Reader) (cid.Cid, error) {
 	file := files.NewReaderFile(f)
 
-	bufferedDS := ipld.NewBufferedDAG(ctx, a.LocalDAG)
-
-	params := ihelper.DagBuilderParams{
-		Maxlinks:   build.UnixfsLinksPerLevel,
-		RawLeaves:  true,
-		CidBuilder: nil,
-		Dagserv:    bufferedDS,
-	}
-
-	db, err := params.New(chunker.NewSizeSplitter(file, int64(build.UnixfsChunkSize)))
-	if err != nil {
-		return cid.Undef, err
-	}
-	nd, err := balanced.Layout(db)
-	if err != nil {
-		return cid.Undef, err
-	}
-
-	return nd.Cid(), bufferedDS.Commit()
+	return deals.ImportFile(ctx, a.LocalDAG, file, false)
 }
 
 func (a *API) ClientListImports(ctx context.Context) ([]api.Import, error) {
@@ -277,20 +329,211 @@ func (a *API) ClientRetrieve(ctx context.Context, order api.RetrievalOrder, path
 		order.MinerPeerID = pid
 	}
 
+	switch order.Serialization {
+	case "", api.SerializationUnixFs:
+		return a.retrieveUnixfs(ctx, order, path)
+	}
+
+	if order.Offset != 0 || (order.Length != 0 && order.Length != order.Size) {
+		return xerrors.Errorf("ranged retrieval of %s data isn't supported", order.Serialization)
+	}
+
 	outFile, err := os.OpenFile(path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0777)
 	if err != nil {
 		return err
 	}
 
-	err = a.Retrieval.RetrieveUnixfs(ctx, order.Root, order.Size, order.Total, order.MinerPeerID, order.Client, order.Miner, outFile)
+	err = a.Retrieval.RetrieveDAG(ctx, order.Root, order.Serialization, order.Size, orderTerms(order), order.MinerPeerID, order.Client, order.Miner, outFile)
 	if err != nil {
 		_ = outFile.Close()
-		return xerrors.Errorf("RetrieveUnixfs: %w", err)
+		return xerrors.Errorf("retrieving %s data: %w", order.Serialization, err)
 	}
 
 	return outFile.Close()
 }
 
+// retrieveUnixfs retrieves a range of a unixfs file. Progress is recorded
+// next to the output file, and a retrieval of the same range into the same
+// file resumes from the last verified block
+func (a *API) retrieveUnixfs(ctx context.Context, order api.RetrievalOrder, path string) error {
+	if order.Offset > order.Size {
+		return xerrors.Errorf("offset %d is past the end of the data (%d bytes)", order.Offset, order.Size)
+	}
+	length := order.Length
+	if length == 0 {
+		length = order.Size - order.Offset
+	}
+
+	prog, err := retrieval.LoadProgress(path)
+	if err != nil {
+		return xerrors.Errorf("loading retrieval progress: %w", err)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if prog == nil || !prog.Resumes(order.Root, order.Offset, length) {
+		flags |= os.O_TRUNC
+		prog = &retrieval.Progress{
+			Root:   order.Root,
+			Offset: order.Offset,
+			Length: length,
+		}
+	}
+
+	outFile, err := os.OpenFile(path, flags, 0777)
+	if err != nil {
+		return err
+	}
+	if err := outFile.Truncate(int64(prog.Written)); err != nil {
+		_ = outFile.Close()
+		return err
+	}
+	if _, err := outFile.Seek(int64(prog.Written), io.SeekStart); err != nil {
+		_ = outFile.Close()
+		return err
+	}
+	if err := prog.Save(path); err != nil {
+		_ = outFile.Close()
+		return xerrors.Errorf("saving retrieval progress: %w", err)
+	}
+
+	resumed := prog.Written
+	if resumed < length {
+		err = a.Retrieval.RetrieveUnixfsRange(ctx, order.Root, order.Size, orderTerms(order), order.Offset+resumed, length-resumed, order.MinerPeerID, order.Client, order.Miner, outFile, func(written uint64) error {
+			// progress can't get ahead of the data on disk
+			if err := outFile.Sync(); err != nil {
+				return err
+			}
+			prog.Written = resumed + written
+			return prog.Save(path)
+		})
+		if err != nil {
+			_ = outFile.Close()
+			return xerrors.Errorf("retrieving unixfs data: %w", err)
+		}
+	}
+
+	if err := outFile.Close(); err != nil {
+		return err
+	}
+	return retrieval.RemoveProgress(path)
+}
+
+func (a *API) ClientRetrieveMulti(ctx context.Context, orders []api.RetrievalOrder, parallel int, path string) error {
+	if len(orders) == 0 {
+		return xerrors.New("no retrieval orders")
+	}
+
+	first := orders[0]
+	for i := range orders {
+		o := &orders[i]
+		if !o.Root.Equals(first.Root) || o.Size != first.Size || o.Serialization != first.Serialization || o.Offset != first.Offset || o.Length != first.Length {
+			return xerrors.Errorf("order %d is for different data than the first order", i)
+		}
+
+		if o.MinerPeerID == "" {
+			pid, err := a.StateMinerPeerID(ctx, o.Miner, nil)
+			if err != nil {
+				return err
+			}
+
+			o.MinerPeerID = pid
+		}
+	}
+
+	switch first.Serialization {
+	case "", api.SerializationUnixFs:
+		return a.retrieveUnixfsMulti(ctx, orders, parallel, path)
+	}
+
+	// DAGs in other serializations are retrieved from one miner at a time,
+	// starting over with the next one when a miner fails
+	var err error
+	for _, order := range orders {
+		err = a.ClientRetrieve(ctx, order, path)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (a *API) retrieveUnixfsMulti(ctx context.Context, orders []api.RetrievalOrder, parallel int, path string) error {
+	first := orders[0]
+	if first.Offset > first.Size {
+		return xerrors.Errorf("offset %d is past the end of the data (%d bytes)", first.Offset, first.Size)
+	}
+	length := first.Length
+	if length == 0 {
+		length = first.Size - first.Offset
+	}
+
+	sources := make([]retrieval.Source, len(orders))
+	for i, order := range orders {
+		sources[i] = retrieval.Source{
+			Terms:     orderTerms(order),
+			Miner:     order.MinerPeerID,
+			MinerAddr: order.Miner,
+		}
+	}
+
+	outFile, err := os.OpenFile(path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0777)
+	if err != nil {
+		return err
+	}
+
+	err = a.Retrieval.RetrieveUnixfsParallel(ctx, first.Root, first.Size, first.Offset, length, sources, parallel, first.Client, outFile)
+	if err != nil {
+		_ = outFile.Close()
+		return xerrors.Errorf("retrieving unixfs data: %w", err)
+	}
+
+	return outFile.Close()
+}
+
+func (a *API) ClientRetrieveSelector(ctx context.Context, order api.RetrievalOrder, sel api.RetrievalSelector, path string) error {
+	if order.MinerPeerID == "" {
+		pid, err := a.StateMinerPeerID(ctx, order.Miner, nil)
+		if err != nil {
+			return err
+		}
+
+		order.MinerPeerID = pid
+	}
+
+	var segments []string
+	for _, s := range strings.Split(sel.Path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+
+	outFile, err := os.OpenFile(path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0777)
+	if err != nil {
+		return err
+	}
+
+	err = a.Retrieval.RetrieveSelector(ctx, order.Root, segments, sel.Depth, order.Size, orderTerms(order), order.MinerPeerID, order.Client, order.Miner, outFile)
+	if err != nil {
+		_ = outFile.Close()
+		return xerrors.Errorf("retrieving selected dag: %w", err)
+	}
+
+	return outFile.Close()
+}
+
+func (a *API) ClientListRetrievals(ctx context.Context) ([]api.RetrievalDeal, error) {
+	return a.Retrieval.ListDeals()
+}
+
+// orderTerms returns retrieval terms the miner offered for the order
+func orderTerms(order api.RetrievalOrder) retrieval.Terms {
+	return retrieval.Terms{
+		Total:           order.Total,
+		UnsealPrice:     order.UnsealPrice,
+		PaymentInterval: order.PaymentInterval,
+	}
+}
+
 func (a *API) ClientQueryAsk(ctx context.Context, p peer.ID, miner address.Address) (*types.SignedStorageAsk, error) {
 	return a.DealClient.QueryAsk(ctx, p, miner)
 }
@@ -0,0 +1,223 @@
+package client
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// askQueryTimeout limits how long a miner can take to answer an ask query
+// when placing deals
+const askQueryTimeout = 10 * time.Second
+
+// placementQueryParallel limits how many miners are queried at once
+const placementQueryParallel = 16
+
+type placementCandidate struct {
+	miner address.Address
+	peer  peer.ID
+	ask   *types.StorageAsk
+	power types.BigInt
+
+	// failed is the number of our deals the miner rejected or failed
+	failed int
+}
+
+// ClientStartDeals proposes deals storing data with `replicas` miners. Miners
+// are picked from the ones asking at most maxPrice per byte per block,
+// preferring miners which didn't fail our previous deals, then cheaper ones,
+// then ones with more power. When a proposal fails the next miner is tried
+func (a *API) ClientStartDeals(ctx context.Context, data cid.Cid, mode api.SerializationMode, replicas int, maxPrice types.BigInt, blocksDuration uint64) ([]api.DealPlacement, error) {
+	if replicas <= 0 {
+		return nil, xerrors.New("replica count must be positive")
+	}
+
+	vd, err := a.DealClient.VerifyParams(ctx, data, mode)
+	if err != nil {
+		return nil, err
+	}
+	size := vd.PieceSize.Uint64()
+
+	candidates, err := a.placementCandidates(ctx, size, maxPrice)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, xerrors.Errorf("no miners accepting %d byte pieces at or below %s", size, maxPrice)
+	}
+
+	var out []api.DealPlacement
+	started := 0
+	for started < replicas && len(candidates) > 0 {
+		n := replicas - started
+		if n > len(candidates) {
+			n = len(candidates)
+		}
+		batch := candidates[:n]
+		candidates = candidates[n:]
+
+		results := make([]api.DealPlacement, len(batch))
+
+		var wg sync.WaitGroup
+		wg.Add(len(batch))
+		for i, c := range batch {
+			go func(i int, c placementCandidate) {
+				defer wg.Done()
+
+				results[i] = api.DealPlacement{
+					Miner: c.miner,
+					Price: c.ask.Price,
+					Power: c.power,
+				}
+
+				// deal price is per block, for the whole piece
+				price := types.BigMul(c.ask.Price, types.NewInt(size))
+
+				deal, err := a.proposeDeal(ctx, data, mode, vd, c.miner, c.peer, price, blocksDuration, false)
+				if err != nil {
+					results[i].Err = err.Error()
+					return
+				}
+				results[i].Deal = &deal
+			}(i, c)
+		}
+		wg.Wait()
+
+		for _, r := range results {
+			if r.Deal != nil {
+				started++
+			}
+		}
+		out = append(out, results...)
+	}
+
+	return out, nil
+}
+
+// placementCandidates returns miners whose asks accept the piece, best first
+func (a *API) placementCandidates(ctx context.Context, size uint64, maxPrice types.BigInt) ([]placementCandidate, error) {
+	miners, err := a.StateListMiners(ctx, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("listing miners: %w", err)
+	}
+
+	failed, err := a.failedDeals()
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]*placementCandidate, len(miners))
+	throttle := make(chan struct{}, placementQueryParallel)
+
+	var wg sync.WaitGroup
+	wg.Add(len(miners))
+	for i, miner := range miners {
+		go func(i int, miner address.Address) {
+			defer wg.Done()
+
+			select {
+			case throttle <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-throttle }()
+
+			c, err := a.placementCandidate(ctx, miner)
+			if err != nil {
+				// unreachable miners aren't candidates
+				return
+			}
+			found[i] = c
+		}(i, miner)
+	}
+	wg.Wait()
+
+	return rankCandidates(found, failed, size, maxPrice, time.Now().Unix()), nil
+}
+
+// rankCandidates drops miners whose asks don't accept the piece or expired,
+// and orders the rest best first
+func rankCandidates(found []*placementCandidate, failed map[address.Address]int, size uint64, maxPrice types.BigInt, now int64) []placementCandidate {
+	var out []placementCandidate
+	for _, c := range found {
+		if c == nil {
+			continue
+		}
+		if (!maxPrice.Nil() && c.ask.Price.GreaterThan(maxPrice)) || c.ask.MinPieceSize > size {
+			continue
+		}
+		if c.ask.Expiry <= now {
+			continue
+		}
+
+		cand := *c
+		cand.failed = failed[c.miner]
+		out = append(out, cand)
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].failed != out[j].failed {
+			return out[i].failed < out[j].failed
+		}
+		if types.BigCmp(out[i].ask.Price, out[j].ask.Price) != 0 {
+			return out[i].ask.Price.LessThan(out[j].ask.Price)
+		}
+		return out[i].power.GreaterThan(out[j].power)
+	})
+
+	return out
+}
+
+func (a *API) placementCandidate(ctx context.Context, miner address.Address) (*placementCandidate, error) {
+	pid, err := a.StateMinerPeerID(ctx, miner, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	power, err := a.StateMinerPower(ctx, miner, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, askQueryTimeout)
+	defer cancel()
+
+	ask, err := a.ClientQueryAsk(ctx, pid, miner)
+	if err != nil {
+		return nil, err
+	}
+
+	return &placementCandidate{
+		miner: miner,
+		peer:  pid,
+		ask:   ask.Ask,
+		power: power.MinerPower,
+	}, nil
+}
+
+// failedDeals counts our deals each miner rejected or failed
+func (a *API) failedDeals() (map[address.Address]int, error) {
+	deals, err := a.DealClient.List()
+	if err != nil {
+		return nil, xerrors.Errorf("listing deals: %w", err)
+	}
+
+	out := map[address.Address]int{}
+	for _, deal := range deals {
+		switch deal.State {
+		case api.DealRejected, api.DealFailed, api.DealError:
+			out[deal.Proposal.MinerAddress]++
+		}
+	}
+
+	return out, nil
+}
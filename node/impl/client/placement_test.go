@@ -0,0 +1,58 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestRankCandidates(t *testing.T) {
+	const now = 1000
+
+	candidate := func(id uint64, price uint64, power uint64, minPiece uint64, expiry int64) *placementCandidate {
+		miner, err := address.NewIDAddress(id)
+		require.NoError(t, err)
+
+		return &placementCandidate{
+			miner: miner,
+			ask: &types.StorageAsk{
+				Price:        types.NewInt(price),
+				MinPieceSize: minPiece,
+				Miner:        miner,
+				Expiry:       expiry,
+			},
+			power: types.NewInt(power),
+		}
+	}
+
+	cheap := candidate(100, 1, 10, 0, now+100)
+	strong := candidate(101, 2, 1000, 0, now+100)
+	weak := candidate(102, 2, 10, 0, now+100)
+	unreliable := candidate(103, 1, 1000, 0, now+100)
+	expensive := candidate(104, 10, 1000, 0, now+100)
+	bigPieces := candidate(105, 1, 1000, 1<<20, now+100)
+	expired := candidate(106, 1, 1000, 0, now)
+
+	found := []*placementCandidate{nil, weak, expensive, unreliable, strong, bigPieces, cheap, expired, nil}
+	failed := map[address.Address]int{unreliable.miner: 2}
+
+	ranked := rankCandidates(found, failed, 1000, types.NewInt(5), now)
+
+	var order []address.Address
+	for _, c := range ranked {
+		order = append(order, c.miner)
+	}
+
+	// miners which failed deals go last, then cheaper miners are preferred,
+	// then ones with more power
+	require.Equal(t, []address.Address{cheap.miner, strong.miner, weak.miner, unreliable.miner}, order)
+	require.Equal(t, 2, ranked[3].failed)
+
+	// without a price limit expensive miners are candidates too
+	ranked = rankCandidates(found, nil, 1000, types.EmptyInt, now)
+	require.Len(t, ranked, 5)
+	require.Equal(t, expensive.miner, ranked[4].miner)
+}
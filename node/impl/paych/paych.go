@@ -23,7 +23,8 @@ type PaychAPI struct {
 	full.WalletAPI
 	full.ChainAPI
 
-	PaychMgr *paych.Manager
+	PaychMgr     *paych.Manager
+	PaychWatcher *paych.Watcher
 }
 
 func (a *PaychAPI) PaychGet(ctx context.Context, from, to address.Address, ensureFunds types.BigInt) (*api.ChannelInfo, error) {
@@ -45,7 +46,6 @@ func (a *PaychAPI) PaychAllocateLane(ctx context.Context, ch address.Address) (u
 func (a *PaychAPI) PaychNewPayment(ctx context.Context, from, to address.Address, vouchers []api.VoucherSpec) (*api.PaymentInfo, error) {
 	amount := vouchers[len(vouchers)-1].Amount
 
-	// TODO: Fix free fund tracking in PaychGet
 	// TODO: validate voucher spec before locking funds
 	ch, err := a.PaychGet(ctx, from, to, amount)
 	if err != nil {
@@ -136,6 +136,18 @@ func (a *PaychAPI) PaychClose(ctx context.Context, addr address.Address) (cid.Ci
 	return smsg.Cid(), nil
 }
 
+func (a *PaychAPI) PaychAddFunds(ctx context.Context, ch address.Address, amt types.BigInt) (cid.Cid, error) {
+	return a.PaychMgr.AddFunds(ctx, ch, amt)
+}
+
+func (a *PaychAPI) PaychSettle(ctx context.Context, ch address.Address) (cid.Cid, error) {
+	return a.PaychMgr.Settle(ctx, ch)
+}
+
+func (a *PaychAPI) PaychCollect(ctx context.Context, ch address.Address) (cid.Cid, error) {
+	return a.PaychMgr.Collect(ctx, ch)
+}
+
 func (a *PaychAPI) PaychVoucherCheckValid(ctx context.Context, ch address.Address, sv *types.SignedVoucher) error {
 	return a.PaychMgr.CheckVoucherValid(ctx, ch, sv)
 }
@@ -145,7 +157,9 @@ func (a *PaychAPI) PaychVoucherCheckSpendable(ctx context.Context, ch address.Ad
 }
 
 func (a *PaychAPI) PaychVoucherAdd(ctx context.Context, ch address.Address, sv *types.SignedVoucher, proof []byte, minDelta types.BigInt) (types.BigInt, error) {
-	_ = a.PaychMgr.TrackInboundChannel(ctx, ch) // TODO: expose those calls
+	if err := a.PaychMgr.TrackInboundChannel(ctx, ch); err == nil { // TODO: expose those calls
+		a.PaychWatcher.Watch(ch)
+	}
 
 	return a.PaychMgr.AddVoucher(ctx, ch, sv, proof, minDelta)
 }
@@ -207,47 +221,53 @@ func (a *PaychAPI) PaychVoucherList(ctx context.Context, pch address.Address) ([
 }
 
 func (a *PaychAPI) PaychVoucherSubmit(ctx context.Context, ch address.Address, sv *types.SignedVoucher) (cid.Cid, error) {
-	ci, err := a.PaychMgr.GetChannelInfo(ch)
+	from, err := a.PaychMgr.ChannelSender(ctx, ch)
 	if err != nil {
 		return cid.Undef, err
 	}
 
-	nonce, err := a.MpoolGetNonce(ctx, ci.Control)
-	if err != nil {
-		return cid.Undef, err
+	if len(sv.SecretPreimage) > 0 {
+		return cid.Undef, fmt.Errorf("cant handle more advanced payment channel stuff yet")
 	}
 
-	if sv.Extra != nil || len(sv.SecretPreimage) > 0 {
-		return cid.Undef, fmt.Errorf("cant handle more advanced payment channel stuff yet")
+	var proof []byte
+	if sv.Extra != nil {
+		proof, err = a.PaychMgr.VoucherProof(ctx, ch, sv)
+		if err != nil {
+			return cid.Undef, err
+		}
+		if proof == nil {
+			return cid.Undef, xerrors.New("no proof stored for voucher with validation")
+		}
 	}
 
 	enc, err := actors.SerializeParams(&actors.PCAUpdateChannelStateParams{
-		Sv: *sv,
+		Sv:    *sv,
+		Proof: proof,
 	})
 	if err != nil {
 		return cid.Undef, err
 	}
 
-	msg := &types.Message{
-		From:     ci.Control,
+	// the sender is usually a miner worker, the mpool assigns nonces so
+	// we don't race its other messages
+	smsg, err := a.MpoolPushMessage(ctx, &types.Message{
+		From:     from,
 		To:       ch,
 		Value:    types.NewInt(0),
-		Nonce:    nonce,
 		Method:   actors.PCAMethods.UpdateChannelState,
 		Params:   enc,
 		GasLimit: types.NewInt(100000),
 		GasPrice: types.NewInt(0),
-	}
-
-	smsg, err := a.WalletSignMessage(ctx, ci.Control, msg)
+	})
 	if err != nil {
 		return cid.Undef, err
 	}
 
-	if err := a.MpoolPush(ctx, smsg); err != nil {
-		return cid.Undef, err
-	}
-
 	// TODO: should we wait for it...?
 	return smsg.Cid(), nil
 }
+
+func (a *PaychAPI) PaychSettleUpdates(ctx context.Context) (<-chan api.PaychSettleUpdate, error) {
+	return a.PaychWatcher.SubscribeUpdates(ctx), nil
+}
@@ -6,13 +6,20 @@ import (
 	"io"
 	"math/rand"
 
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/build"
 	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/deals"
+	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/lib/sectorbuilder"
+	"github.com/filecoin-project/lotus/retrieval"
 	"github.com/filecoin-project/lotus/storage"
 	"github.com/filecoin-project/lotus/storage/sector"
 	"github.com/filecoin-project/lotus/storage/sectorblocks"
+	"github.com/filecoin-project/lotus/storage/unsealed"
 )
 
 type StorageMinerAPI struct {
@@ -22,8 +29,11 @@ type StorageMinerAPI struct {
 	SectorBuilder       *sectorbuilder.SectorBuilder
 	Sectors             *sector.Store
 	SectorBlocks        *sectorblocks.SectorBlocks
+	UnsealedCache       *unsealed.Cache
 
-	Miner *storage.Miner
+	Miner          *storage.Miner
+	DealHandler    *deals.Handler
+	RetrievalMiner *retrieval.Miner
 }
 
 func (sm *StorageMinerAPI) ActorAddress(context.Context) (address.Address, error) {
@@ -34,7 +44,7 @@ func (sm *StorageMinerAPI) StoreGarbageData(ctx context.Context) (uint64, error)
 	size := sectorbuilder.UserBytesForSectorSize(build.SectorSize)
 
 	name := fmt.Sprintf("fake-file-%d", rand.Intn(100000000))
-	sectorId, err := sm.Sectors.AddPiece(name, size, io.LimitReader(rand.New(rand.NewSource(42)), int64(size)))
+	sectorId, err := sm.Sectors.AddPiece(name, size, io.LimitReader(rand.New(rand.NewSource(42)), int64(size)), 0)
 	if err != nil {
 		return 0, err
 	}
@@ -42,18 +52,36 @@ func (sm *StorageMinerAPI) StoreGarbageData(ctx context.Context) (uint64, error)
 	return sectorId, err
 }
 
-func (sm *StorageMinerAPI) SectorsStatus(ctx context.Context, sid uint64) (sectorbuilder.SectorSealingStatus, error) {
-	return sm.SectorBuilder.SealStatus(sid)
+func (sm *StorageMinerAPI) SectorsStatus(ctx context.Context, sid uint64) (api.SectorStatus, error) {
+	si, err := sm.Miner.GetSectorInfo(sid)
+	if err != nil {
+		return api.SectorStatus{}, err
+	}
+
+	return si.Status(), nil
 }
 
-// List all staged sectors
+// List all sectors tracked by the miner
 func (sm *StorageMinerAPI) SectorsList(context.Context) ([]uint64, error) {
-	return sm.SectorBuilder.GetAllStagedSectors()
+	sectors, err := sm.Miner.ListSectors()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]uint64, len(sectors))
+	for i, si := range sectors {
+		out[i] = si.SectorID
+	}
+	return out, nil
 }
 
 // Seal all staged sectors
-func (sm *StorageMinerAPI) SectorsStagedSeal(context.Context) error {
-	return sm.SectorBuilder.SealAllStagedSectors()
+func (sm *StorageMinerAPI) SectorsStagedSeal(ctx context.Context) error {
+	return sm.Miner.SealStagedSectors(ctx)
+}
+
+func (sm *StorageMinerAPI) SectorsRetry(ctx context.Context, sid uint64) error {
+	return sm.Miner.RetrySector(ctx, sid)
 }
 
 func (sm *StorageMinerAPI) SectorsRefs(context.Context) (map[string][]api.SealedRef, error) {
@@ -72,4 +100,90 @@ func (sm *StorageMinerAPI) SectorsRefs(context.Context) (map[string][]api.Sealed
 	return out, nil
 }
 
+func (sm *StorageMinerAPI) StorageList(context.Context) ([]sectorbuilder.StoragePathInfo, error) {
+	return sm.SectorBuilder.StorageList()
+}
+
+func (sm *StorageMinerAPI) StorageAttach(ctx context.Context, p sectorbuilder.StoragePath) error {
+	return sm.SectorBuilder.AttachStorage(p)
+}
+
+func (sm *StorageMinerAPI) MarketSetAsk(ctx context.Context, price types.BigInt, minPieceSize uint64, ttlsecs int64) error {
+	return sm.DealHandler.SetAsk(price, minPieceSize, ttlsecs)
+}
+
+func (sm *StorageMinerAPI) MarketGetAsk(ctx context.Context) (*types.SignedStorageAsk, error) {
+	ask := sm.DealHandler.GetAsk()
+	if ask == nil {
+		return nil, xerrors.New("no ask set")
+	}
+	return ask, nil
+}
+
+func (sm *StorageMinerAPI) MarketImportDealData(ctx context.Context, proposalCid cid.Cid, path string) error {
+	return sm.DealHandler.ImportData(ctx, proposalCid, path)
+}
+
+func (sm *StorageMinerAPI) MarketListIncomingDeals(ctx context.Context) ([]api.MarketDeal, error) {
+	deals, err := sm.DealHandler.ListDeals()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]api.MarketDeal, len(deals))
+	for i, deal := range deals {
+		out[i] = marketDeal(deal)
+	}
+	return out, nil
+}
+
+func (sm *StorageMinerAPI) MarketGetDeal(ctx context.Context, proposalCid cid.Cid) (*api.MarketDeal, error) {
+	deal, err := sm.DealHandler.GetDeal(proposalCid)
+	if err != nil {
+		return nil, err
+	}
+
+	md := marketDeal(deal)
+	return &md, nil
+}
+
+func (sm *StorageMinerAPI) MarketListRetrievalDeals(ctx context.Context) ([]api.RetrievalDeal, error) {
+	return sm.RetrievalMiner.ListDeals()
+}
+
+func (sm *StorageMinerAPI) MarketRejectDeal(ctx context.Context, proposalCid cid.Cid, reason string) error {
+	return sm.DealHandler.RejectDeal(proposalCid, reason)
+}
+
+func (sm *StorageMinerAPI) UnsealedList(ctx context.Context) (api.UnsealedCacheInfo, error) {
+	return sm.UnsealedCache.Info(), nil
+}
+
+func (sm *StorageMinerAPI) UnsealedGC(ctx context.Context, keep uint64) (api.UnsealedGCResult, error) {
+	removed, freed := sm.UnsealedCache.GC(keep)
+	return api.UnsealedGCResult{Removed: removed, Freed: freed}, nil
+}
+
+func marketDeal(deal deals.MinerDeal) api.MarketDeal {
+	return api.MarketDeal{
+		ProposalCid: deal.ProposalCid,
+		State:       deal.State,
+		Message:     deal.Message,
+
+		Client:        deal.Client,
+		ClientAddress: deal.Proposal.ClientAddress,
+
+		PieceRef:          deal.Ref,
+		SerializationMode: deal.Proposal.SerializationMode,
+		ManualTransfer:    deal.Proposal.ManualTransfer,
+		CommP:             deal.Proposal.CommP,
+		Size:              deal.Proposal.Size,
+
+		TotalPrice: deal.Proposal.TotalPrice,
+		Duration:   deal.Proposal.Duration,
+
+		SectorID: deal.SectorID,
+	}
+}
+
 var _ api.StorageMiner = &StorageMinerAPI{}
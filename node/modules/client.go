@@ -16,9 +16,17 @@ import (
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	"github.com/ipfs/go-merkledag"
 	"go.uber.org/fx"
+	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/deals"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/node/config"
+	"github.com/filecoin-project/lotus/node/impl/client"
 	"github.com/filecoin-project/lotus/node/modules/dtypes"
 	"github.com/filecoin-project/lotus/node/repo"
+	"github.com/filecoin-project/lotus/paych"
+	"github.com/filecoin-project/lotus/retrieval"
 )
 
 func ClientFstore(r repo.LockedRepo) (dtypes.ClientFilestore, error) {
@@ -55,3 +63,76 @@ func ClientDAG(mctx helpers.MetricsCtx, lc fx.Lifecycle, ibs dtypes.ClientBlocks
 
 	return dag
 }
+
+func ReplicationPolicy(cfg config.Replication) func() (deals.ReplicationPolicy, error) {
+	return func() (deals.ReplicationPolicy, error) {
+		policy := deals.ReplicationPolicy{
+			Copies:      cfg.Copies,
+			RenewBefore: cfg.RenewBefore,
+		}
+
+		for _, m := range cfg.Miners {
+			addr, err := address.NewFromString(m)
+			if err != nil {
+				return deals.ReplicationPolicy{}, xerrors.Errorf("parsing replication miner address: %w", err)
+			}
+			policy.Miners = append(policy.Miners, addr)
+		}
+
+		if cfg.MaxPricePerByteBlock != "" {
+			price, err := types.BigFromString(cfg.MaxPricePerByteBlock)
+			if err != nil {
+				return deals.ReplicationPolicy{}, xerrors.Errorf("parsing replication max price: %w", err)
+			}
+			policy.MaxPricePerByteBlock = price
+		}
+
+		return policy, nil
+	}
+}
+
+func DealMonitor(capi client.API, c *deals.Client, policy deals.ReplicationPolicy) *deals.Monitor {
+	return deals.NewMonitor(&capi, c, policy)
+}
+
+func PaychFundingPolicy(cfg config.PaychFunding) func() (paych.FundingPolicy, error) {
+	return func() (paych.FundingPolicy, error) {
+		var policy paych.FundingPolicy
+
+		if cfg.MinTopUp != "" {
+			amt, err := types.BigFromString(cfg.MinTopUp)
+			if err != nil {
+				return paych.FundingPolicy{}, xerrors.Errorf("parsing payment channel min top-up: %w", err)
+			}
+			policy.MinTopUp = amt
+		}
+
+		return policy, nil
+	}
+}
+
+func RetrievalClientConfig(cfg config.ClientRetrieval) func() (retrieval.ClientConfig, error) {
+	return func() (retrieval.ClientConfig, error) {
+		out := retrieval.ClientConfig{
+			MaxPaymentInterval: cfg.MaxPaymentInterval,
+		}
+
+		if cfg.MaxPricePerByte != "" {
+			price, err := types.BigFromString(cfg.MaxPricePerByte)
+			if err != nil {
+				return retrieval.ClientConfig{}, xerrors.Errorf("parsing retrieval max price: %w", err)
+			}
+			out.MaxPricePerByte = price
+		}
+
+		if cfg.MaxUnsealPrice != "" {
+			price, err := types.BigFromString(cfg.MaxUnsealPrice)
+			if err != nil {
+				return retrieval.ClientConfig{}, xerrors.Errorf("parsing retrieval max unseal price: %w", err)
+			}
+			out.MaxUnsealPrice = price
+		}
+
+		return out, nil
+	}
+}
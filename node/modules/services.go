@@ -2,9 +2,11 @@ package modules
 
 import (
 	"context"
+	"time"
 
 	"github.com/libp2p/go-libp2p-core/host"
 	inet "github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/routing"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"go.uber.org/fx"
 
@@ -12,8 +14,11 @@ import (
 	"github.com/filecoin-project/lotus/chain/deals"
 	"github.com/filecoin-project/lotus/chain/sub"
 	"github.com/filecoin-project/lotus/node/hello"
+	"github.com/filecoin-project/lotus/node/impl/full"
 	"github.com/filecoin-project/lotus/node/modules/helpers"
+	"github.com/filecoin-project/lotus/paych"
 	"github.com/filecoin-project/lotus/peermgr"
+	"github.com/filecoin-project/lotus/retrieval"
 	"github.com/filecoin-project/lotus/retrieval/discovery"
 	"github.com/filecoin-project/lotus/storage/sector"
 )
@@ -79,6 +84,40 @@ func RunDealClient(mctx helpers.MetricsCtx, lc fx.Lifecycle, c *deals.Client) {
 	})
 }
 
+func RunDealMonitor(mctx helpers.MetricsCtx, lc fx.Lifecycle, m *deals.Monitor) {
+	ctx := helpers.LifecycleCtx(mctx, lc)
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			m.Run(ctx)
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			m.Stop()
+			return nil
+		},
+	})
+}
+
+func PaychWatcher(capi full.ChainAPI, pm *paych.Manager) *paych.Watcher {
+	return paych.NewWatcher(&capi, pm)
+}
+
+func RunPaychWatcher(mctx helpers.MetricsCtx, lc fx.Lifecycle, w *paych.Watcher) {
+	ctx := helpers.LifecycleCtx(mctx, lc)
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			w.Run(ctx)
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			w.Stop()
+			return nil
+		},
+	})
+}
+
 func RunSectorService(lc fx.Lifecycle, secst *sector.Store) {
 	lc.Append(fx.Hook{
 		OnStart: func(context.Context) error {
@@ -92,6 +131,13 @@ func RunSectorService(lc fx.Lifecycle, secst *sector.Store) {
 	})
 }
 
-func RetrievalResolver(l *discovery.Local) discovery.PeerResolver {
-	return discovery.Multi(l)
+// retrievalResolverTimeout limits how long remote peer resolvers can take
+const retrievalResolverTimeout = 10 * time.Second
+
+func RetrievalResolver(l *discovery.Local, sapi full.StateAPI, rc *retrieval.Client, rt routing.Routing) discovery.PeerResolver {
+	return discovery.Multi(
+		l,
+		discovery.Timeout(discovery.NewChain(&sapi, rc), retrievalResolverTimeout),
+		discovery.Timeout(discovery.NewDHT(rt), retrievalResolverTimeout),
+	)
 }
@@ -3,6 +3,7 @@ package modules
 import (
 	"context"
 	"path/filepath"
+	"time"
 
 	"github.com/ipfs/go-bitswap"
 	"github.com/ipfs/go-bitswap/network"
@@ -14,12 +15,15 @@ import (
 	"github.com/libp2p/go-libp2p-core/routing"
 	"github.com/mitchellh/go-homedir"
 	"go.uber.org/fx"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/build"
 	"github.com/filecoin-project/lotus/chain/address"
 	"github.com/filecoin-project/lotus/chain/deals"
+	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/lib/sectorbuilder"
+	"github.com/filecoin-project/lotus/node/config"
 	"github.com/filecoin-project/lotus/node/modules/dtypes"
 	"github.com/filecoin-project/lotus/node/modules/helpers"
 	"github.com/filecoin-project/lotus/node/repo"
@@ -27,6 +31,8 @@ import (
 	"github.com/filecoin-project/lotus/storage"
 	"github.com/filecoin-project/lotus/storage/commitment"
 	"github.com/filecoin-project/lotus/storage/sector"
+	"github.com/filecoin-project/lotus/storage/sectorblocks"
+	"github.com/filecoin-project/lotus/storage/unsealed"
 )
 
 func minerAddrFromDS(ds dtypes.MetadataDS) (address.Address, error) {
@@ -38,13 +44,19 @@ func minerAddrFromDS(ds dtypes.MetadataDS) (address.Address, error) {
 	return address.NewFromBytes(maddrb)
 }
 
-func SectorBuilderConfig(storagePath string) func(dtypes.MetadataDS) (*sectorbuilder.SectorBuilderConfig, error) {
-	return func(ds dtypes.MetadataDS) (*sectorbuilder.SectorBuilderConfig, error) {
+// SectorBuilderConfig keeps sectors in storagePath, or in the miner repo
+// when it's empty
+func SectorBuilderConfig(storagePath string, sealedPaths []config.SealedPath, maxConcurrentSeals int) func(dtypes.MetadataDS, repo.LockedRepo) (*sectorbuilder.SectorBuilderConfig, error) {
+	return func(ds dtypes.MetadataDS, lr repo.LockedRepo) (*sectorbuilder.SectorBuilderConfig, error) {
 		minerAddr, err := minerAddrFromDS(ds)
 		if err != nil {
 			return nil, err
 		}
 
+		if storagePath == "" {
+			storagePath = lr.Path()
+		}
+
 		sp, err := homedir.Expand(storagePath)
 		if err != nil {
 			return nil, err
@@ -60,19 +72,155 @@ func SectorBuilderConfig(storagePath string) func(dtypes.MetadataDS) (*sectorbui
 			MetadataDir: metadata,
 			SealedDir:   sealed,
 			StagedDir:   staging,
+
+			// all staged sectors are sealed at once
+			MaxStagedSectors: maxConcurrentSeals,
+		}
+
+		for _, p := range sealedPaths {
+			path, err := homedir.Expand(p.Path)
+			if err != nil {
+				return nil, err
+			}
+
+			sb.SealedPaths = append(sb.SealedPaths, sectorbuilder.StoragePath{
+				Path:     path,
+				Weight:   p.Weight,
+				MaxBytes: p.MaxBytes,
+			})
 		}
 
 		return sb, nil
 	}
 }
 
-func StorageMiner(mctx helpers.MetricsCtx, lc fx.Lifecycle, api api.FullNode, h host.Host, ds dtypes.MetadataDS, secst *sector.Store, commt *commitment.Tracker) (*storage.Miner, error) {
+func StorageMinerConfig(cfg config.StorageMiner) func() (storage.Config, error) {
+	return func() (storage.Config, error) {
+		redeem := storage.RedeemPolicy{
+			DeadlineMargin: cfg.Payments.DeadlineMargin,
+		}
+		if cfg.Payments.RedeemThreshold != "" {
+			threshold, err := types.BigFromString(cfg.Payments.RedeemThreshold)
+			if err != nil {
+				return storage.Config{}, xerrors.Errorf("parsing voucher redeem threshold: %w", err)
+			}
+			redeem.Threshold = threshold
+		}
+
+		return storage.Config{
+			Sealing: storage.SealPolicy{
+				MaxConcurrentSeals: cfg.Sealing.MaxConcurrentSeals,
+				MaxWait:            time.Duration(cfg.Sealing.MaxWait),
+				FillRatio:          cfg.Sealing.FillRatio,
+			},
+			PoStConfidence: cfg.PoSt.Confidence,
+			Redeem:         redeem,
+		}, nil
+	}
+}
+
+func DealHandlerConfig(cfg config.Dealmaking) func() (deals.HandlerConfig, error) {
+	return func() (deals.HandlerConfig, error) {
+		price, err := types.BigFromString(cfg.PricePerByteBlock)
+		if err != nil {
+			return deals.HandlerConfig{}, xerrors.Errorf("parsing deal price: %w", err)
+		}
+
+		return deals.HandlerConfig{
+			PricePerByteBlock: price,
+			MinPieceSize:      cfg.MinPieceSize,
+			AskExpiry:         time.Duration(cfg.AskExpiry),
+		}, nil
+	}
+}
+
+// UnsealedCache keeps unsealed pieces in path, or in the miner repo when
+// it's empty
+func UnsealedCache(cfg config.Unsealed) func(repo.LockedRepo) (*unsealed.Cache, error) {
+	return func(lr repo.LockedRepo) (*unsealed.Cache, error) {
+		path := cfg.Path
+		if path == "" {
+			path = filepath.Join(lr.Path(), "unsealed")
+		}
+
+		p, err := homedir.Expand(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return unsealed.New(p, cfg.MaxBytes)
+	}
+}
+
+func RetrievalMinerConfig(cfg config.Retrieval) func() (retrieval.MinerConfig, error) {
+	return func() (retrieval.MinerConfig, error) {
+		price, err := types.BigFromString(cfg.PricePerByte)
+		if err != nil {
+			return retrieval.MinerConfig{}, xerrors.Errorf("parsing retrieval price: %w", err)
+		}
+
+		unsealPrice, err := types.BigFromString(cfg.UnsealPrice)
+		if err != nil {
+			return retrieval.MinerConfig{}, xerrors.Errorf("parsing unseal price: %w", err)
+		}
+
+		if cfg.PaymentInterval < build.UnixfsChunkSize {
+			return retrieval.MinerConfig{}, xerrors.Errorf("retrieval payment interval must be at least %d bytes", build.UnixfsChunkSize)
+		}
+
+		return retrieval.MinerConfig{
+			PricePerByte:    price,
+			UnsealPrice:     unsealPrice,
+			PaymentInterval: cfg.PaymentInterval,
+
+			MaxBytesPerSecond:       cfg.MaxBytesPerSecond,
+			MaxStreamBytesPerSecond: cfg.MaxStreamBytesPerSecond,
+		}, nil
+	}
+}
+
+func DealFilter(cfg config.Dealmaking) func() (deals.DealFilter, error) {
+	return func() (deals.DealFilter, error) {
+		rules := &deals.RuleFilter{
+			MaxPieceSize: cfg.MaxPieceSize,
+			MaxDuration:  cfg.MaxDuration,
+		}
+
+		for _, a := range cfg.AllowClients {
+			addr, err := address.NewFromString(a)
+			if err != nil {
+				return nil, xerrors.Errorf("parsing allowed client address: %w", err)
+			}
+			rules.AllowClients = append(rules.AllowClients, addr)
+		}
+
+		for _, a := range cfg.DenyClients {
+			addr, err := address.NewFromString(a)
+			if err != nil {
+				return nil, xerrors.Errorf("parsing denied client address: %w", err)
+			}
+			rules.DenyClients = append(rules.DenyClients, addr)
+		}
+
+		filters := deals.Filters{rules}
+		if cfg.Filter != "" {
+			filters = append(filters, &deals.CommandFilter{
+				Cmd:     cfg.Filter,
+				Timeout: time.Duration(cfg.FilterTimeout),
+			})
+		}
+
+		return filters, nil
+	}
+}
+
+func StorageMiner(mctx helpers.MetricsCtx, lc fx.Lifecycle, api api.FullNode, h host.Host, ds dtypes.MetadataDS, secst *sector.Store, commt *commitment.Tracker, cfg storage.Config) (*storage.Miner, error) {
 	maddr, err := minerAddrFromDS(ds)
 	if err != nil {
 		return nil, err
 	}
 
-	sm, err := storage.NewMiner(api, maddr, h, ds, secst, commt)
+	sm, err := storage.NewMiner(api, maddr, h, ds, secst, commt, cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -88,6 +236,62 @@ func StorageMiner(mctx helpers.MetricsCtx, lc fx.Lifecycle, api api.FullNode, h
 	return sm, nil
 }
 
+func RetrievalMiner(sblks *sectorblocks.SectorBlocks, full api.FullNode, ds dtypes.MetadataDS, cfg retrieval.MinerConfig) (*retrieval.Miner, error) {
+	maddr, err := minerAddrFromDS(ds)
+	if err != nil {
+		return nil, err
+	}
+
+	return retrieval.NewMiner(sblks, full, maddr, cfg, ds), nil
+}
+
+// retrievalReprovideInterval is how often provider records of stored pieces
+// are announced, DHT records expire after 24 hours
+const retrievalReprovideInterval = 12 * time.Hour
+
+// ProvideRetrievalPieces periodically announces roots of stored pieces as
+// provider records, so clients can find the miner for retrieval
+func ProvideRetrievalPieces(mctx helpers.MetricsCtx, lc fx.Lifecycle, sblks *sectorblocks.SectorBlocks, rt routing.Routing) {
+	ctx := helpers.LifecycleCtx(mctx, lc)
+
+	provide := func() {
+		pieces, err := sblks.ListPieces()
+		if err != nil {
+			log.Errorf("listing pieces to provide: %s", err)
+			return
+		}
+
+		for _, piece := range pieces {
+			if err := rt.Provide(ctx, piece, true); err != nil {
+				log.Warnf("providing piece %s: %s", piece, err)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				ticker := time.NewTicker(retrievalReprovideInterval)
+				defer ticker.Stop()
+
+				for {
+					provide()
+
+					select {
+					case <-ticker.C:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+			return nil
+		},
+	})
+}
+
 func HandleRetrieval(host host.Host, lc fx.Lifecycle, m *retrieval.Miner) {
 	lc.Append(fx.Hook{
 		OnStart: func(context.Context) error {
@@ -106,6 +310,7 @@ func HandleDeals(mctx helpers.MetricsCtx, lc fx.Lifecycle, host host.Host, h *de
 			h.Run(ctx)
 			host.SetStreamHandler(deals.ProtocolID, h.HandleStream)
 			host.SetStreamHandler(deals.AskProtocolID, h.HandleAskStream)
+			host.SetStreamHandler(deals.DealStatusProtocolID, h.HandleDealStatusStream)
 			return nil
 		},
 		OnStop: func(context.Context) error {
@@ -0,0 +1,241 @@
+package paych
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// FundingPolicy controls how outbound channels are created and topped up
+type FundingPolicy struct {
+	// MinTopUp is the smallest amount channels are created or topped up
+	// with, so that following payments can be made from funds already in
+	// the channel. Nil means exactly the missing amount is added
+	MinTopUp types.BigInt
+}
+
+// fundingQueue tracks add funds messages of a channel. Requests made while a
+// message is in flight wait for it, and are then covered by a single message
+type fundingQueue struct {
+	next *fundingBatch
+}
+
+type fundingBatch struct {
+	amt  types.BigInt
+	done chan struct{}
+
+	mcid cid.Cid
+	err  error
+}
+
+// AvailableFunds returns the funds in the channel which aren't redeemed, or
+// promised in vouchers we know of
+func (pm *Manager) AvailableFunds(ctx context.Context, ch address.Address) (types.BigInt, error) {
+	act, st, err := pm.loadPaychState(ctx, ch)
+	if err != nil {
+		return types.EmptyInt, err
+	}
+
+	vouchers, err := pm.ListVouchers(ctx, ch)
+	if err != nil {
+		return types.EmptyInt, err
+	}
+
+	spent := map[uint64]types.BigInt{}
+	for lane, ls := range st.LaneStates {
+		l, err := strconv.ParseUint(lane, 10, 64)
+		if err != nil {
+			return types.EmptyInt, err
+		}
+		spent[l] = ls.Redeemed
+	}
+	for _, vi := range vouchers {
+		s, ok := spent[vi.Voucher.Lane]
+		if !ok || vi.Voucher.Amount.GreaterThan(s) {
+			spent[vi.Voucher.Lane] = vi.Voucher.Amount
+		}
+	}
+
+	total := types.NewInt(0)
+	for _, s := range spent {
+		total = types.BigAdd(total, s)
+	}
+
+	if act.Balance.LessThan(total) {
+		return types.NewInt(0), nil
+	}
+	return types.BigSub(act.Balance, total), nil
+}
+
+// ensureFunds makes sure amt is available in the channel, and reserves it
+// until vouchers spending it are created. When funds are already being added
+// to the channel, the request is batched with the others made in the
+// meantime, and they are all covered by the next message
+func (pm *Manager) ensureFunds(ctx context.Context, ch address.Address, from address.Address, amt types.BigInt) (cid.Cid, error) {
+	return pm.batchFunding(ctx, ch, amt, func(ctx context.Context, amt types.BigInt) (cid.Cid, error) {
+		return pm.fund(ctx, ch, from, amt)
+	})
+}
+
+// batchFunding runs fund for amt, or for the batch of requests amt is added
+// to when fund is already running for the channel. Funded amounts are
+// reserved before the next batch is funded, so checking funds and reserving
+// them can't race with other requests
+func (pm *Manager) batchFunding(ctx context.Context, ch address.Address, amt types.BigInt, fund func(context.Context, types.BigInt) (cid.Cid, error)) (cid.Cid, error) {
+	pm.fundLk.Lock()
+	if q, ok := pm.funding[ch]; ok {
+		if q.next == nil {
+			q.next = &fundingBatch{
+				amt:  types.NewInt(0),
+				done: make(chan struct{}),
+			}
+		}
+		b := q.next
+		b.amt = types.BigAdd(b.amt, amt)
+		pm.fundLk.Unlock()
+
+		select {
+		case <-b.done:
+			return b.mcid, b.err
+		case <-ctx.Done():
+			return cid.Undef, ctx.Err()
+		}
+	}
+	pm.funding[ch] = &fundingQueue{}
+	pm.fundLk.Unlock()
+
+	mcid, err := fund(ctx, amt)
+	pm.nextFunding(ch, amt, err, fund)
+	return mcid, err
+}
+
+// nextFunding reserves the funded amount, and adds funds for the batch of
+// requests made while the last message was in flight
+func (pm *Manager) nextFunding(ch address.Address, funded types.BigInt, err error, fund func(context.Context, types.BigInt) (cid.Cid, error)) {
+	pm.fundLk.Lock()
+	defer pm.fundLk.Unlock()
+
+	if err == nil {
+		pm.reserveLocked(ch, funded)
+	}
+
+	q := pm.funding[ch]
+	b := q.next
+	if b == nil {
+		delete(pm.funding, ch)
+		return
+	}
+	q.next = nil
+
+	go func() {
+		mcid, err := fund(context.TODO(), b.amt)
+		pm.nextFunding(ch, b.amt, err, fund)
+
+		// waiters are woken up once their funds are reserved
+		b.mcid, b.err = mcid, err
+		close(b.done)
+	}()
+}
+
+// reserveLocked reserves amt of the channel funds, must be called with
+// fundLk held
+func (pm *Manager) reserveLocked(ch address.Address, amt types.BigInt) {
+	if amt.Nil() || amt.Sign() <= 0 {
+		return
+	}
+
+	r, ok := pm.reserved[ch]
+	if !ok {
+		r = types.NewInt(0)
+	}
+	pm.reserved[ch] = types.BigAdd(r, amt)
+}
+
+// reservedFunds returns the channel funds reserved for vouchers which weren't
+// created yet
+func (pm *Manager) reservedFunds(ch address.Address) types.BigInt {
+	pm.fundLk.Lock()
+	defer pm.fundLk.Unlock()
+
+	r, ok := pm.reserved[ch]
+	if !ok {
+		return types.NewInt(0)
+	}
+	return r
+}
+
+// ReleaseFunds releases amt of the funds reserved in the channel. Funds are
+// released when vouchers spending them are created, callers which end up not
+// spending all funds they asked for release the rest
+func (pm *Manager) ReleaseFunds(ch address.Address, amt types.BigInt) {
+	pm.fundLk.Lock()
+	defer pm.fundLk.Unlock()
+
+	r, ok := pm.reserved[ch]
+	if !ok || amt.Nil() || amt.Sign() <= 0 {
+		return
+	}
+	if !r.GreaterThan(amt) {
+		delete(pm.reserved, ch)
+		return
+	}
+	pm.reserved[ch] = types.BigSub(r, amt)
+}
+
+// fund adds funds to the channel when less than amt is available on top of
+// the funds already reserved
+func (pm *Manager) fund(ctx context.Context, ch address.Address, from address.Address, amt types.BigInt) (cid.Cid, error) {
+	avail, err := pm.AvailableFunds(ctx, ch)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("getting available funds: %w", err)
+	}
+
+	need := types.BigSub(types.BigAdd(pm.reservedFunds(ch), amt), avail)
+	if !need.GreaterThan(types.NewInt(0)) {
+		return cid.Undef, nil
+	}
+
+	topUp, err := pm.topUpAmount(ctx, from, need)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	return pm.addFunds(ctx, ch, from, topUp)
+}
+
+// topUpAmount returns the amount to add when need is missing, following the
+// funding policy
+func (pm *Manager) topUpAmount(ctx context.Context, from address.Address, need types.BigInt) (types.BigInt, error) {
+	if pm.policy.MinTopUp.Nil() || !pm.policy.MinTopUp.GreaterThan(need) {
+		return need, nil
+	}
+
+	bal, err := pm.wallet.WalletBalance(ctx, from)
+	if err != nil {
+		return types.EmptyInt, xerrors.Errorf("getting wallet balance: %w", err)
+	}
+	if bal.LessThan(pm.policy.MinTopUp) {
+		// don't fail payments the wallet can afford because of the policy
+		return need, nil
+	}
+
+	return pm.policy.MinTopUp, nil
+}
+
+// AddFunds adds amt to an outbound channel, regardless of the funding policy
+func (pm *Manager) AddFunds(ctx context.Context, ch address.Address, amt types.BigInt) (cid.Cid, error) {
+	ci, err := pm.store.getChannelInfo(ch)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if ci.Direction != DirOutbound {
+		return cid.Undef, xerrors.Errorf("can only add funds to outbound channels")
+	}
+
+	return pm.addFunds(ctx, ch, ci.Control, amt)
+}
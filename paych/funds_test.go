@@ -0,0 +1,176 @@
+package paych
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func testManager() *Manager {
+	return &Manager{
+		funding:  map[address.Address]*fundingQueue{},
+		reserved: map[address.Address]types.BigInt{},
+	}
+}
+
+func testChannel(t *testing.T) address.Address {
+	ch, err := address.NewIDAddress(100)
+	require.NoError(t, err)
+	return ch
+}
+
+// testFunder records amounts funded, each call blocks until released
+type testFunder struct {
+	t *testing.T
+
+	lk      sync.Mutex
+	amounts []types.BigInt
+
+	started chan struct{}
+	release chan error
+}
+
+func newTestFunder(t *testing.T) *testFunder {
+	return &testFunder{
+		t:       t,
+		started: make(chan struct{}, 16),
+		release: make(chan error),
+	}
+}
+
+func (tf *testFunder) fund(ctx context.Context, amt types.BigInt) (cid.Cid, error) {
+	tf.lk.Lock()
+	tf.amounts = append(tf.amounts, amt)
+	n := len(tf.amounts)
+	tf.lk.Unlock()
+
+	tf.started <- struct{}{}
+	if err := <-tf.release; err != nil {
+		return cid.Undef, err
+	}
+
+	c, err := cid.V1Builder{Codec: cid.Raw, MhType: mh.SHA2_256}.Sum([]byte{byte(n)})
+	require.NoError(tf.t, err)
+	return c, nil
+}
+
+type fundResult struct {
+	mcid cid.Cid
+	err  error
+}
+
+func (tf *testFunder) request(pm *Manager, ch address.Address, amt uint64) chan fundResult {
+	out := make(chan fundResult, 1)
+	go func() {
+		mcid, err := pm.batchFunding(context.Background(), ch, types.NewInt(amt), tf.fund)
+		out <- fundResult{mcid, err}
+	}()
+	return out
+}
+
+// waitQueued waits until amt is batched for the next message
+func waitQueued(t *testing.T, pm *Manager, ch address.Address, amt uint64) {
+	for i := 0; i < 100; i++ {
+		pm.fundLk.Lock()
+		q := pm.funding[ch]
+		queued := q != nil && q.next != nil && types.BigCmp(q.next.amt, types.NewInt(amt)) == 0
+		pm.fundLk.Unlock()
+		if queued {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%d wasn't queued", amt)
+}
+
+func TestFundingBatches(t *testing.T) {
+	pm := testManager()
+	ch := testChannel(t)
+	tf := newTestFunder(t)
+
+	first := tf.request(pm, ch, 10)
+	<-tf.started
+
+	// requests made while funds are added are batched
+	second := tf.request(pm, ch, 20)
+	waitQueued(t, pm, ch, 20)
+	third := tf.request(pm, ch, 30)
+	waitQueued(t, pm, ch, 50)
+
+	tf.release <- nil
+	r1 := <-first
+	require.NoError(t, r1.err)
+	require.Equal(t, types.NewInt(10), pm.reservedFunds(ch))
+
+	// the batch is funded with a single message, once the first funds are
+	// reserved
+	<-tf.started
+	tf.release <- nil
+	r2, r3 := <-second, <-third
+	require.NoError(t, r2.err)
+	require.Equal(t, r2, r3)
+	require.NotEqual(t, r1.mcid, r2.mcid)
+
+	require.Equal(t, []types.BigInt{types.NewInt(10), types.NewInt(50)}, tf.amounts)
+	require.Equal(t, types.NewInt(60), pm.reservedFunds(ch))
+
+	// the queue is cleaned up when there's nothing left to fund
+	pm.fundLk.Lock()
+	require.Empty(t, pm.funding)
+	pm.fundLk.Unlock()
+}
+
+func TestFundingFailure(t *testing.T) {
+	pm := testManager()
+	ch := testChannel(t)
+	tf := newTestFunder(t)
+
+	first := tf.request(pm, ch, 10)
+	<-tf.started
+	second := tf.request(pm, ch, 20)
+	waitQueued(t, pm, ch, 20)
+
+	// failed requests don't reserve funds, and don't fail the batch
+	tf.release <- xerrors.New("not enough funds")
+	require.Error(t, (<-first).err)
+	require.Equal(t, types.NewInt(0), pm.reservedFunds(ch))
+
+	<-tf.started
+	tf.release <- nil
+	require.NoError(t, (<-second).err)
+	require.Equal(t, types.NewInt(20), pm.reservedFunds(ch))
+}
+
+func TestReleaseFunds(t *testing.T) {
+	pm := testManager()
+	ch := testChannel(t)
+
+	pm.fundLk.Lock()
+	pm.reserveLocked(ch, types.NewInt(100))
+	pm.reserveLocked(ch, types.NewInt(0))
+	pm.reserveLocked(ch, types.EmptyInt)
+	pm.fundLk.Unlock()
+	require.Equal(t, types.NewInt(100), pm.reservedFunds(ch))
+
+	// funds are released as vouchers spend them
+	pm.ReleaseFunds(ch, types.NewInt(30))
+	require.Equal(t, types.NewInt(70), pm.reservedFunds(ch))
+
+	// releasing nothing, or more than is reserved
+	pm.ReleaseFunds(ch, types.BigSub(types.NewInt(10), types.NewInt(20)))
+	pm.ReleaseFunds(ch, types.EmptyInt)
+	require.Equal(t, types.NewInt(70), pm.reservedFunds(ch))
+
+	pm.ReleaseFunds(ch, types.NewInt(100))
+	require.Equal(t, types.NewInt(0), pm.reservedFunds(ch))
+	require.Empty(t, pm.reserved)
+}
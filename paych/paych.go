@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"sync"
 
+	"github.com/ipfs/go-cid"
 	"golang.org/x/xerrors"
 
 	logging "github.com/ipfs/go-log"
@@ -30,22 +32,31 @@ type ManagerApi struct {
 }
 
 type Manager struct {
-	store *Store
-	sm    *stmgr.StateManager
+	store  *Store
+	sm     *stmgr.StateManager
+	policy FundingPolicy
 
 	mpool  full.MpoolAPI
 	wallet full.WalletAPI
 	state  full.StateAPI
+
+	fundLk   sync.Mutex
+	funding  map[address.Address]*fundingQueue
+	reserved map[address.Address]types.BigInt
 }
 
-func NewManager(sm *stmgr.StateManager, pchstore *Store, api ManagerApi) *Manager {
+func NewManager(sm *stmgr.StateManager, pchstore *Store, policy FundingPolicy, api ManagerApi) *Manager {
 	return &Manager{
-		store: pchstore,
-		sm:    sm,
+		store:  pchstore,
+		sm:     sm,
+		policy: policy,
 
 		mpool:  api.MpoolAPI,
 		wallet: api.WalletAPI,
 		state:  api.StateAPI,
+
+		funding:  map[address.Address]*fundingQueue{},
+		reserved: map[address.Address]types.BigInt{},
 	}
 }
 
@@ -181,18 +192,10 @@ func (pm *Manager) CheckVoucherSpendable(ctx context.Context, ch address.Address
 	}
 
 	if sv.Extra != nil && proof == nil {
-		known, err := pm.ListVouchers(ctx, ch)
+		proof, err = pm.VoucherProof(ctx, ch, sv)
 		if err != nil {
 			return false, err
 		}
-
-		for _, v := range known {
-			if v.Proof != nil && v.Voucher.Equals(sv) {
-				log.Info("CheckVoucherSpendable: using stored proof")
-				proof = v.Proof
-				break
-			}
-		}
 		if proof == nil {
 			log.Warn("CheckVoucherSpendable: nil proof for voucher with validation")
 		}
@@ -224,6 +227,44 @@ func (pm *Manager) CheckVoucherSpendable(ctx context.Context, ch address.Address
 	return true, nil
 }
 
+// VoucherProof returns the proof stored with a voucher, or nil if the voucher
+// was stored without one
+func (pm *Manager) VoucherProof(ctx context.Context, ch address.Address, sv *types.SignedVoucher) ([]byte, error) {
+	known, err := pm.ListVouchers(ctx, ch)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range known {
+		if v.Proof != nil && v.Voucher.Equals(sv) {
+			return v.Proof, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// ChannelSender returns the address messages updating the channel are sent
+// from. Channels paying storage miner actors are controlled by the worker
+// of the miner
+func (pm *Manager) ChannelSender(ctx context.Context, ch address.Address) (address.Address, error) {
+	ci, err := pm.store.getChannelInfo(ch)
+	if err != nil {
+		return address.Undef, err
+	}
+
+	ts := pm.sm.ChainStore().GetHeaviestTipSet()
+	act, err := pm.sm.GetActor(ci.Control, ts)
+	if err != nil {
+		return address.Undef, xerrors.Errorf("getting channel control actor: %w", err)
+	}
+	if act.Code != actors.StorageMinerCodeCid {
+		return ci.Control, nil
+	}
+
+	return stmgr.GetMinerWorker(ctx, pm.sm, ts.ParentState(), ci.Control)
+}
+
 func (pm *Manager) getPaychOwner(ctx context.Context, ch address.Address) (address.Address, error) {
 	ret, err := pm.sm.Call(ctx, &types.Message{
 		From:   ch,
@@ -304,7 +345,15 @@ func (pm *Manager) AddVoucher(ctx context.Context, ch address.Address, sv *types
 		ci.NextLane = sv.Lane + 1
 	}
 
-	return delta, pm.store.putChannelInfo(ci)
+	if err := pm.store.putChannelInfo(ci); err != nil {
+		return delta, err
+	}
+
+	if ci.Direction == DirOutbound {
+		// the voucher spends funds reserved when getting the channel
+		pm.ReleaseFunds(ch, delta)
+	}
+	return delta, nil
 }
 
 func (pm *Manager) AllocateLane(ch address.Address) (uint64, error) {
@@ -346,3 +395,50 @@ func (pm *Manager) NextNonceForLane(ctx context.Context, ch address.Address, lan
 
 	return maxnonce + 1, nil
 }
+
+// Settle calls Close on the channel. On inbound channels the Watcher submits
+// our best vouchers before the channel closes, and collects the funds
+func (pm *Manager) Settle(ctx context.Context, ch address.Address) (cid.Cid, error) {
+	_, st, err := pm.loadPaychState(ctx, ch)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if st.ClosingAt != 0 {
+		return cid.Undef, xerrors.Errorf("channel already closing at %d", st.ClosingAt)
+	}
+
+	from, err := pm.ChannelSender(ctx, ch)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	smsg, err := pm.mpool.MpoolPushMessage(ctx, &types.Message{
+		From:     from,
+		To:       ch,
+		Value:    types.NewInt(0),
+		Method:   actors.PCAMethods.Close,
+		GasLimit: types.NewInt(500),
+		GasPrice: types.NewInt(0),
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	return smsg.Cid(), nil
+}
+
+// Collect sends the funds of a closed channel to both parties
+func (pm *Manager) Collect(ctx context.Context, ch address.Address) (cid.Cid, error) {
+	_, st, err := pm.loadPaychState(ctx, ch)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if st.ClosingAt == 0 {
+		return cid.Undef, xerrors.Errorf("channel isn't closing")
+	}
+	if h := pm.sm.ChainStore().GetHeaviestTipSet().Height(); h < st.ClosingAt {
+		return cid.Undef, xerrors.Errorf("channel closes at %d, current height is %d", st.ClosingAt, h)
+	}
+
+	return pm.collect(ctx, ch)
+}
@@ -70,7 +70,7 @@ func (pm *Manager) createPaych(ctx context.Context, from, to address.Address, am
 	return paychaddr, mcid, nil
 }
 
-func (pm *Manager) addFunds(ctx context.Context, ch address.Address, from address.Address, amt types.BigInt) error {
+func (pm *Manager) addFunds(ctx context.Context, ch address.Address, from address.Address, amt types.BigInt) (cid.Cid, error) {
 	msg := &types.Message{
 		To:       ch,
 		From:     from,
@@ -82,38 +82,70 @@ func (pm *Manager) addFunds(ctx context.Context, ch address.Address, from addres
 
 	smsg, err := pm.mpool.MpoolPushMessage(ctx, msg)
 	if err != nil {
-		return err
+		return cid.Undef, err
 	}
 
-	mwait, err := pm.state.StateWaitMsg(ctx, smsg.Cid()) // TODO: wait outside the store lock!
+	mwait, err := pm.state.StateWaitMsg(ctx, smsg.Cid())
 	if err != nil {
-		return err
+		return cid.Undef, err
 	}
 
 	if mwait.Receipt.ExitCode != 0 {
-		return fmt.Errorf("voucher channel creation failed: adding funds (exit code %d)", mwait.Receipt.ExitCode)
+		return cid.Undef, fmt.Errorf("adding funds to payment channel failed (exit code %d)", mwait.Receipt.ExitCode)
 	}
 
-	return nil
+	return smsg.Cid(), nil
 }
 
+// GetPaych returns an outbound channel from from to to with at least
+// ensureFree available, and reserves it for vouchers the caller creates.
+// Existing channels are reused, and only topped up when they don't have
+// enough funds left
 func (pm *Manager) GetPaych(ctx context.Context, from, to address.Address, ensureFree types.BigInt) (address.Address, cid.Cid, error) {
 	pm.store.lk.Lock()
-	defer pm.store.lk.Unlock()
 
 	ch, err := pm.store.findChan(func(ci *ChannelInfo) bool {
 		if ci.Direction != DirOutbound {
 			return false
 		}
-		return ci.Control == from && ci.Target == to
+		if ci.Control != from || ci.Target != to {
+			return false
+		}
+
+		// closing channels can't be reused
+		_, st, err := pm.loadPaychState(ctx, ci.Channel)
+		if err != nil {
+			log.Warnf("loading state of payment channel %s: %s", ci.Channel, err)
+			return false
+		}
+		return st.ClosingAt == 0
 	})
 	if err != nil {
+		pm.store.lk.Unlock()
 		return address.Undef, cid.Undef, err
 	}
-	if ch != address.Undef {
-		// TODO: Track available funds
-		return ch, cid.Undef, pm.addFunds(ctx, ch, from, ensureFree)
+	if ch == address.Undef {
+		// creating under the store lock, so only one channel gets created
+		defer pm.store.lk.Unlock()
+
+		amt, err := pm.topUpAmount(ctx, from, ensureFree)
+		if err != nil {
+			return address.Undef, cid.Undef, err
+		}
+		ch, mcid, err := pm.createPaych(ctx, from, to, amt)
+		if err != nil {
+			return address.Undef, cid.Undef, err
+		}
+
+		pm.fundLk.Lock()
+		pm.reserveLocked(ch, ensureFree)
+		pm.fundLk.Unlock()
+		return ch, mcid, nil
 	}
+	pm.store.lk.Unlock()
 
-	return pm.createPaych(ctx, from, to, ensureFree)
+	if _, err := pm.ensureFunds(ctx, ch, from, ensureFree); err != nil {
+		return address.Undef, cid.Undef, err
+	}
+	return ch, cid.Undef, nil
 }
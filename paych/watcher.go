@@ -0,0 +1,400 @@
+package paych
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	pubsub "github.com/whyrusleeping/pubsub"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/events"
+	"github.com/filecoin-project/lotus/chain/store"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/pubsubutil"
+)
+
+// watcherConfidence is the number of epochs the watcher waits for before
+// acting on chain events
+const watcherConfidence = 3
+
+const settleUpdatesTopic = "settleupdate"
+
+type watcherApi interface {
+	ChainNotify(context.Context) (<-chan []*store.HeadChange, error)
+	ChainGetBlockMessages(context.Context, cid.Cid) (*api.BlockMessages, error)
+	ChainGetTipSetByHeight(context.Context, uint64, *types.TipSet) (*types.TipSet, error)
+}
+
+// Watcher settles inbound payment channels closed by the payer. When Close is
+// called on a channel, the best spendable voucher of each lane is submitted
+// before the channel closes, and the funds are collected once it's closed
+type Watcher struct {
+	api watcherApi
+	pm  *Manager
+
+	events  *events.Events
+	updates *pubsub.PubSub
+
+	// only accessed from the run goroutine
+	watched  map[address.Address]bool
+	settling map[address.Address]bool
+
+	// channels to watch are queued by Watch, track is signalled when the
+	// queue isn't empty
+	trackLk  sync.Mutex
+	toTrack  []address.Address
+	track    chan struct{}
+	closing  chan address.Address
+	collects chan address.Address
+
+	stop chan struct{}
+}
+
+func NewWatcher(api watcherApi, pm *Manager) *Watcher {
+	return &Watcher{
+		api: api,
+		pm:  pm,
+
+		updates: pubsub.New(16),
+
+		watched:  map[address.Address]bool{},
+		settling: map[address.Address]bool{},
+
+		track:    make(chan struct{}, 1),
+		closing:  make(chan address.Address),
+		collects: make(chan address.Address),
+
+		stop: make(chan struct{}),
+	}
+}
+
+func (w *Watcher) Run(ctx context.Context) {
+	go w.run(ctx)
+}
+
+func (w *Watcher) run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w.events = events.NewEvents(ctx, w.api)
+
+	chs, err := w.pm.ListChannels()
+	if err != nil {
+		log.Errorf("paych watcher: listing channels: %s", err)
+		return
+	}
+	for _, ch := range chs {
+		w.watch(ctx, ch)
+	}
+
+	for {
+		select {
+		case <-w.track:
+			w.trackLk.Lock()
+			toTrack := w.toTrack
+			w.toTrack = nil
+			w.trackLk.Unlock()
+
+			for _, ch := range toTrack {
+				w.watch(ctx, ch)
+			}
+		case ch := <-w.closing:
+			w.settle(ctx, ch)
+		case ch := <-w.collects:
+			w.collect(ctx, ch)
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Watch starts watching a newly tracked inbound channel. It doesn't block,
+// channels are queued until the watcher picks them up
+func (w *Watcher) Watch(ch address.Address) {
+	select {
+	case <-w.stop:
+		log.Warnf("paych watcher: not watching channel %s, the watcher was stopped", ch)
+		return
+	default:
+	}
+
+	w.trackLk.Lock()
+	w.toTrack = append(w.toTrack, ch)
+	w.trackLk.Unlock()
+
+	select {
+	case w.track <- struct{}{}:
+	default:
+		// the watcher was already signalled
+	}
+}
+
+// watch waits for Close to be called on an inbound channel. Channels which
+// are already closing are settled right away
+func (w *Watcher) watch(ctx context.Context, ch address.Address) {
+	if w.watched[ch] {
+		return
+	}
+
+	ci, err := w.pm.GetChannelInfo(ch)
+	if err != nil {
+		log.Errorf("paych watcher: getting channel %s: %s", ch, err)
+		return
+	}
+	if ci.Direction != DirInbound {
+		return
+	}
+
+	closing := func() {
+		go func() {
+			select {
+			case w.closing <- ch:
+			case <-w.stop:
+			}
+		}()
+	}
+
+	check := func(ts *types.TipSet) (bool, bool, error) {
+		_, st, err := w.pm.loadPaychState(ctx, ch)
+		if err != nil {
+			return false, false, err
+		}
+		if st.ClosingAt != 0 {
+			closing()
+			return true, false, nil
+		}
+		return false, true, nil
+	}
+	called := func(msg *types.Message, ts *types.TipSet, curH uint64) (bool, error) {
+		// settle checks the channel state, as the Close call might have failed
+		closing()
+		return true, nil
+	}
+	revert := func(ts *types.TipSet) error {
+		log.Warnf("paych watcher: Close call on channel %s reverted", ch)
+		return nil
+	}
+
+	if err := w.events.Called(check, called, revert, watcherConfidence, events.NoTimeout, ch, actors.PCAMethods.Close); err != nil {
+		log.Errorf("paych watcher: watching channel %s: %s", ch, err)
+		return
+	}
+	w.watched[ch] = true
+}
+
+// settle submits our best vouchers to a closing channel, and schedules the
+// funds to be collected when it's closed
+func (w *Watcher) settle(ctx context.Context, ch address.Address) {
+	if w.settling[ch] {
+		return
+	}
+
+	_, st, err := w.pm.loadPaychState(ctx, ch)
+	if err != nil {
+		w.fail(ch, xerrors.Errorf("loading channel state: %w", err))
+		return
+	}
+	if st.ClosingAt == 0 {
+		return
+	}
+	w.settling[ch] = true
+
+	log.Infof("paych watcher: channel %s is closing at %d", ch, st.ClosingAt)
+	w.updates.Pub(api.PaychSettleUpdate{
+		Channel:   ch,
+		State:     api.PaychSettleClosing,
+		ClosingAt: st.ClosingAt,
+	}, settleUpdatesTopic)
+
+	if ts := w.pm.sm.ChainStore().GetHeaviestTipSet(); ts.Height() < st.ClosingAt {
+		w.submitVouchers(ctx, ch, st)
+	}
+
+	collect := func(ts *types.TipSet, curH uint64) error {
+		go func() {
+			select {
+			case w.collects <- ch:
+			case <-w.stop:
+			}
+		}()
+		return nil
+	}
+	revert := func(ts *types.TipSet) error {
+		// Collect will fail if the chain went back before ClosingAt
+		return nil
+	}
+
+	if err := w.events.ChainAt(collect, revert, watcherConfidence, st.ClosingAt); err != nil {
+		w.fail(ch, xerrors.Errorf("scheduling collect: %w", err))
+	}
+}
+
+// submitVouchers submits the highest spendable voucher of each lane which
+// pays more than what was already redeemed
+func (w *Watcher) submitVouchers(ctx context.Context, ch address.Address, st *actors.PaymentChannelActorState) {
+	vouchers, err := w.pm.ListVouchers(ctx, ch)
+	if err != nil {
+		w.fail(ch, xerrors.Errorf("listing vouchers: %w", err))
+		return
+	}
+
+	best := map[uint64]*VoucherInfo{}
+	for _, vi := range vouchers {
+		sv := vi.Voucher
+		if len(sv.SecretPreimage) > 0 {
+			// we can't know the secret
+			continue
+		}
+
+		ls, ok := st.LaneStates[fmt.Sprint(sv.Lane)]
+		if ok && (ls.Closed || ls.Nonce >= sv.Nonce || !sv.Amount.GreaterThan(ls.Redeemed)) {
+			continue
+		}
+		if b, ok := best[sv.Lane]; ok && !sv.Amount.GreaterThan(b.Voucher.Amount) {
+			continue
+		}
+
+		spendable, err := w.pm.CheckVoucherSpendable(ctx, ch, sv, nil, vi.Proof)
+		if err != nil {
+			log.Warnf("paych watcher: checking voucher on lane %d of %s: %s", sv.Lane, ch, err)
+			continue
+		}
+		if !spendable {
+			continue
+		}
+		best[sv.Lane] = vi
+	}
+
+	for lane, vi := range best {
+		mcid, err := w.pm.submitVoucher(ctx, ch, vi)
+		if err != nil {
+			w.fail(ch, xerrors.Errorf("submitting voucher on lane %d: %w", lane, err))
+			continue
+		}
+
+		log.Infof("paych watcher: submitted voucher for %s on lane %d of %s", vi.Voucher.Amount, lane, ch)
+		w.updates.Pub(api.PaychSettleUpdate{
+			Channel:   ch,
+			State:     api.PaychSettleVoucherSubmitted,
+			ClosingAt: st.ClosingAt,
+			Lane:      lane,
+			Amount:    vi.Voucher.Amount,
+			Message:   &mcid,
+		}, settleUpdatesTopic)
+	}
+}
+
+// collect collects the funds of a closed channel
+func (w *Watcher) collect(ctx context.Context, ch address.Address) {
+	_, st, err := w.pm.loadPaychState(ctx, ch)
+	if err != nil {
+		w.fail(ch, xerrors.Errorf("loading channel state: %w", err))
+		return
+	}
+
+	update := api.PaychSettleUpdate{
+		Channel:   ch,
+		State:     api.PaychSettleCollected,
+		ClosingAt: st.ClosingAt,
+		Amount:    st.ToSend,
+	}
+
+	// with nothing to send to us, the rest of the funds are for the payer to
+	// collect
+	if st.ToSend.GreaterThan(types.NewInt(0)) {
+		mcid, err := w.pm.collect(ctx, ch)
+		if err != nil {
+			w.fail(ch, xerrors.Errorf("collecting funds: %w", err))
+			return
+		}
+		update.Message = &mcid
+	}
+
+	log.Infof("paych watcher: collected %s from channel %s", st.ToSend, ch)
+	w.updates.Pub(update, settleUpdatesTopic)
+}
+
+func (w *Watcher) fail(ch address.Address, err error) {
+	log.Errorf("paych watcher: settling channel %s: %s", ch, err)
+	w.updates.Pub(api.PaychSettleUpdate{
+		Channel: ch,
+		State:   api.PaychSettleError,
+		Err:     err.Error(),
+	}, settleUpdatesTopic)
+}
+
+// SubscribeUpdates returns a channel receiving updates on settlement of
+// inbound channels. The channel is closed when ctx is cancelled
+func (w *Watcher) SubscribeUpdates(ctx context.Context) <-chan api.PaychSettleUpdate {
+	out := make(chan api.PaychSettleUpdate, 16)
+	pubsubutil.Subscribe(ctx, w.updates, settleUpdatesTopic, func(val interface{}) {
+		select {
+		case out <- val.(api.PaychSettleUpdate):
+		case <-ctx.Done():
+		}
+	}, func() {
+		close(out)
+	})
+
+	return out
+}
+
+func (w *Watcher) Stop() {
+	close(w.stop)
+}
+
+func (pm *Manager) submitVoucher(ctx context.Context, ch address.Address, vi *VoucherInfo) (cid.Cid, error) {
+	from, err := pm.ChannelSender(ctx, ch)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	enc, err := actors.SerializeParams(&actors.PCAUpdateChannelStateParams{
+		Sv:    *vi.Voucher,
+		Proof: vi.Proof,
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	smsg, err := pm.mpool.MpoolPushMessage(ctx, &types.Message{
+		From:     from,
+		To:       ch,
+		Value:    types.NewInt(0),
+		Method:   actors.PCAMethods.UpdateChannelState,
+		Params:   enc,
+		GasLimit: types.NewInt(100000),
+		GasPrice: types.NewInt(0),
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	return smsg.Cid(), nil
+}
+
+func (pm *Manager) collect(ctx context.Context, ch address.Address) (cid.Cid, error) {
+	from, err := pm.ChannelSender(ctx, ch)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	smsg, err := pm.mpool.MpoolPushMessage(ctx, &types.Message{
+		From:     from,
+		To:       ch,
+		Value:    types.NewInt(0),
+		Method:   actors.PCAMethods.Collect,
+		GasLimit: types.NewInt(1000),
+		GasPrice: types.NewInt(0),
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	return smsg.Cid(), nil
+}
@@ -0,0 +1,34 @@
+package paych
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/chain/address"
+)
+
+func TestWatchDoesntBlock(t *testing.T) {
+	w := NewWatcher(nil, nil)
+
+	var chs []address.Address
+	for i := 0; i < 100; i++ {
+		ch, err := address.NewIDAddress(uint64(100 + i))
+		require.NoError(t, err)
+		chs = append(chs, ch)
+	}
+
+	// channels are queued until the watcher picks them up
+	for _, ch := range chs {
+		w.Watch(ch)
+	}
+	require.Equal(t, chs, w.toTrack)
+	require.Len(t, w.track, 1)
+
+	// and dropped once it's stopped
+	w.Stop()
+	ch, err := address.NewIDAddress(300)
+	require.NoError(t, err)
+	w.Watch(ch)
+	require.Len(t, w.toTrack, len(chs))
+}
@@ -5,8 +5,9 @@ import (
 	"io"
 	"io/ioutil"
 
-	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
 	cbor "github.com/ipfs/go-ipld-cbor"
 	logging "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p-core/host"
@@ -19,22 +20,52 @@ import (
 	"github.com/filecoin-project/lotus/chain/address"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/lib/cborrpc"
+	"github.com/filecoin-project/lotus/lib/statestore"
+	"github.com/filecoin-project/lotus/node/impl/full"
 	payapi "github.com/filecoin-project/lotus/node/impl/paych"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
 	"github.com/filecoin-project/lotus/paych"
 	"github.com/filecoin-project/lotus/retrieval/discovery"
 )
 
 var log = logging.Logger("retrieval")
 
+// ClientConfig limits what the client pays for retrievals
+type ClientConfig struct {
+	// MaxPricePerByte is checked before each payment, nil means no limit
+	MaxPricePerByte types.BigInt
+
+	// MaxUnsealPrice is the highest unseal price paid, nil means no limit
+	MaxUnsealPrice types.BigInt
+
+	// MaxPaymentInterval is the most bytes paid for before they are
+	// received, 0 means no limit
+	MaxPaymentInterval uint64
+}
+
 type Client struct {
 	h host.Host
 
 	pmgr   *paych.Manager
 	payapi payapi.PaychAPI
+	state  full.StateAPI
+
+	cfg ClientConfig
+
+	deals *statestore.StateStore
 }
 
-func NewClient(h host.Host, pmgr *paych.Manager, payapi payapi.PaychAPI) *Client {
-	return &Client{h: h, pmgr: pmgr, payapi: payapi}
+func NewClient(h host.Host, pmgr *paych.Manager, payapi payapi.PaychAPI, state full.StateAPI, cfg ClientConfig, ds dtypes.MetadataDS) *Client {
+	return &Client{
+		h:      h,
+		pmgr:   pmgr,
+		payapi: payapi,
+		state:  state,
+
+		cfg: cfg,
+
+		deals: statestore.New(namespace.Wrap(ds, datastore.NewKey("/retrieval/client"))),
+	}
 }
 
 func (c *Client) Query(ctx context.Context, p discovery.RetrievalPeer, data cid.Cid) api.QueryOffer {
@@ -66,17 +97,47 @@ func (c *Client) Query(ctx context.Context, p discovery.RetrievalPeer, data cid.
 		return api.QueryOffer{Err: err.Error(), Miner: p.Address, MinerPeerID: p.ID}
 	}
 
+	miner := p.Address
+	if miner.Empty() {
+		// peers found by provider records are learned about here, the peer
+		// has to be the one the miner registered on chain
+		pid, err := c.state.StateMinerPeerID(ctx, resp.Miner, nil)
+		if err != nil {
+			log.Warn(err)
+			return api.QueryOffer{Err: err.Error(), Miner: p.Address, MinerPeerID: p.ID}
+		}
+		if pid != p.ID {
+			err := xerrors.Errorf("peer %s answered as miner %s, which has peer id %s", p.ID, resp.Miner, pid)
+			log.Warn(err)
+			return api.QueryOffer{Err: err.Error(), Miner: p.Address, MinerPeerID: p.ID}
+		}
+		miner = resp.Miner
+	}
+	if resp.Miner != miner {
+		err := xerrors.Errorf("peer %s answered as miner %s, expected %s", p.ID, resp.Miner, miner)
+		log.Warn(err)
+		return api.QueryOffer{Err: err.Error(), Miner: p.Address, MinerPeerID: p.ID}
+	}
+	if resp.Status != Available {
+		return api.QueryOffer{Err: "data not available", Miner: miner, MinerPeerID: p.ID}
+	}
+
 	return api.QueryOffer{
-		Root:        data,
-		Size:        resp.Size,
-		MinPrice:    resp.MinPrice,
-		Miner:       p.Address, // TODO: check
-		MinerPeerID: p.ID,
+		Root:          data,
+		Size:          resp.Size,
+		Serialization: resp.Serialization,
+		MinPrice:      resp.MinPrice,
+		Miner:         miner,
+		MinerPeerID:   p.ID,
+
+		UnsealPrice:     resp.UnsealPrice,
+		PaymentInterval: resp.PaymentInterval,
 	}
 }
 
 type clientStream struct {
 	payapi payapi.PaychAPI
+	pmgr   *paych.Manager
 	stream network.Stream
 
 	root   cid.Cid
@@ -87,6 +148,15 @@ type clientStream struct {
 	lane        uint64
 	total       types.BigInt
 	transferred types.BigInt
+	unsealPrice types.BigInt // paid with the first payment
+	lastPayment types.BigInt
+	funds       types.BigInt // reserved in the channel for the retrieval
+
+	maxPricePerByte types.BigInt
+	paidData        types.BigInt // paid for data, without the unseal price
+	paidBytes       uint64
+
+	record dealRecord
 
 	windowSize uint64 // how much we "trust" the peer
 	verifier   BlockVerifier
@@ -104,65 +174,170 @@ type clientStream struct {
 // < ..Blocks
 // > DealProposal(...)
 // < ...
-func (c *Client) RetrieveUnixfs(ctx context.Context, root cid.Cid, size uint64, total types.BigInt, miner peer.ID, client, minerAddr address.Address, out io.Writer) error {
-	s, err := c.h.NewStream(ctx, miner, ProtocolID)
+func (c *Client) RetrieveUnixfs(ctx context.Context, root cid.Cid, size uint64, terms Terms, miner peer.ID, client, minerAddr address.Address, out io.Writer) error {
+	return c.RetrieveUnixfsRange(ctx, root, size, terms, 0, size, miner, client, minerAddr, out, nil)
+}
+
+// RetrieveUnixfsRange retrieves length bytes of a unixfs file starting at
+// offset, paying for the range at the rate of terms.Total for the whole file.
+// progress is called after each exchange with the number of bytes written
+// to out so far, all of which were verified
+func (c *Client) RetrieveUnixfsRange(ctx context.Context, root cid.Cid, size uint64, terms Terms, offset, length uint64, miner peer.ID, client, minerAddr address.Address, out io.Writer, progress func(written uint64) error) (err error) {
+	if offset+length > size {
+		return xerrors.Errorf("range %d+%d is past the end of the file (%d bytes)", offset, length, size)
+	}
+
+	// deals have to start on chunk boundaries, data before the offset is
+	// dropped by the verifier
+	from := offset - offset%build.UnixfsChunkSize
+	end := offset + length
+
+	funds := types.BigDiv(types.BigMul(terms.Total, types.NewInt(end-from)), types.NewInt(size))
+
+	cst, err := c.openStream(ctx, root, size, terms, funds, miner, client, minerAddr)
 	if err != nil {
 		return err
 	}
-	defer s.Close()
+	defer cst.close()
+	defer cst.resetOnCancel(ctx)()
+	defer func() {
+		cst.record.finish(err)
+	}()
+
+	// unixfs windows are whole leaves
+	cst.windowSize -= cst.windowSize % build.UnixfsChunkSize
+	if cst.windowSize == 0 {
+		cst.windowSize = build.UnixfsChunkSize
+	}
+
+	cst.offset = from
+	cst.verifier = NewUnixfsRangeVerifier(root, from, offset, length)
+
+	cw := &countingWriter{w: out}
+
+	for cst.offset < end {
+		toFetch := cst.windowSize
+		if toFetch+cst.offset > end {
+			toFetch = end - cst.offset
+		}
+		log.Infof("Retrieve %dB @%d", toFetch, cst.offset)
+
+		err := cst.doOneExchange(ctx, toFetch, cw)
+		if err != nil {
+			return xerrors.Errorf("retrieval exchange: %w", err)
+		}
+
+		cst.offset += toFetch
+		cst.record.update(toFetch, types.EmptyInt)
+
+		if progress != nil {
+			if err := progress(cw.n); err != nil {
+				return xerrors.Errorf("recording retrieval progress: %w", err)
+			}
+		}
+	}
+	log.Info("RETRIEVE SUCCESSFUL")
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += uint64(n)
+	return n, err
+}
+
+// openStream opens a deal stream with the miner, and sets up payment of up
+// to funds, plus the unseal price. Payments are made at the rate of
+// terms.Total for size bytes
+func (c *Client) openStream(ctx context.Context, root cid.Cid, size uint64, terms Terms, funds types.BigInt, miner peer.ID, client, minerAddr address.Address) (*clientStream, error) {
+	if !terms.UnsealPrice.Nil() && !c.cfg.MaxUnsealPrice.Nil() && types.BigCmp(terms.UnsealPrice, c.cfg.MaxUnsealPrice) > 0 {
+		return nil, xerrors.Errorf("unseal price %s is over the max of %s", terms.UnsealPrice, c.cfg.MaxUnsealPrice)
+	}
+
+	s, err := c.h.NewStream(ctx, miner, ProtocolID)
+	if err != nil {
+		return nil, err
+	}
 
-	initialOffset := uint64(0) // TODO: Check how much data we have locally
-	// TODO: Support in handler
-	// TODO: Allow client to specify this
+	if !terms.UnsealPrice.Nil() {
+		funds = types.BigAdd(funds, terms.UnsealPrice)
+	}
+
+	// windows are paid for before receiving the data, so the miner doesn't
+	// get to choose how much the client risks
+	windowSize := terms.PaymentInterval
+	if c.cfg.MaxPaymentInterval != 0 && windowSize > c.cfg.MaxPaymentInterval {
+		windowSize = c.cfg.MaxPaymentInterval
+	}
+	if windowSize == 0 {
+		windowSize = build.UnixfsChunkSize
+	}
 
-	paych, _, err := c.pmgr.GetPaych(ctx, client, minerAddr, total)
+	paych, _, err := c.pmgr.GetPaych(ctx, client, minerAddr, funds)
 	if err != nil {
-		return xerrors.Errorf("getting payment channel: %w", err)
+		_ = s.Reset()
+		return nil, xerrors.Errorf("getting payment channel: %w", err)
 	}
 	lane, err := c.pmgr.AllocateLane(paych)
 	if err != nil {
-		return xerrors.Errorf("allocating payment lane: %w", err)
+		_ = s.Reset()
+		return nil, xerrors.Errorf("allocating payment lane: %w", err)
 	}
 
-	cst := clientStream{
+	return &clientStream{
 		payapi: c.payapi,
+		pmgr:   c.pmgr,
 		stream: s,
 
-		root:   root,
-		size:   types.NewInt(size),
-		offset: initialOffset,
+		root: root,
+		size: types.NewInt(size),
 
 		paych:       paych,
 		lane:        lane,
-		total:       total,
+		total:       terms.Total,
 		transferred: types.NewInt(0),
+		unsealPrice: terms.UnsealPrice,
+		funds:       funds,
 
-		windowSize: build.UnixfsChunkSize,
-		verifier:   &UnixFs0Verifier{Root: root},
-	}
+		maxPricePerByte: c.cfg.MaxPricePerByte,
+		paidData:        types.NewInt(0),
 
-	for cst.offset != size+initialOffset {
-		toFetch := cst.windowSize
-		if toFetch+cst.offset > size {
-			toFetch = size - cst.offset
-		}
-		log.Infof("Retrieve %dB @%d", toFetch, cst.offset)
+		windowSize: windowSize,
 
-		err := cst.doOneExchange(ctx, toFetch, out)
-		if err != nil {
-			return xerrors.Errorf("retrieval exchange: %w", err)
-		}
+		record: dealRecord{deals: c.deals},
+	}, nil
+}
 
-		cst.offset += toFetch
-	}
-	log.Info("RETRIEVE SUCCESSFUL")
-	return nil
+// close closes the deal stream, and releases channel funds reserved for the
+// retrieval which weren't paid
+func (cst *clientStream) close() error {
+	cst.pmgr.ReleaseFunds(cst.paych, types.BigSub(cst.funds, cst.transferred))
+	return cst.stream.Close()
+}
+
+// resetOnCancel resets the deal stream when ctx is cancelled, so blocked
+// reads return. The returned function stops watching the context
+func (cst *clientStream) resetOnCancel(ctx context.Context) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = cst.stream.Reset()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
 }
 
 func (cst *clientStream) doOneExchange(ctx context.Context, toFetch uint64, out io.Writer) error {
 	payAmount := types.BigDiv(types.BigMul(cst.total, types.NewInt(toFetch)), cst.size)
 
-	payment, err := cst.setupPayment(ctx, payAmount)
+	payment, err := cst.setupPayment(ctx, payAmount, toFetch)
 	if err != nil {
 		return xerrors.Errorf("setting up retrieval payment: %w", err)
 	}
@@ -178,9 +353,29 @@ func (cst *clientStream) doOneExchange(ctx context.Context, toFetch uint64, out
 		},
 	}
 
+	if err := cst.propose(deal); err != nil {
+		return err
+	}
+
+	log.Info("Retrieval accepted, fetching blocks")
+
+	return cst.fetchBlocks(toFetch, out)
+
+	// TODO: maybe increase miner window size after success
+}
+
+// propose sends a deal proposal and waits for the miner to accept it
+func (cst *clientStream) propose(deal DealProposal) error {
+	if !cst.record.started() {
+		if err := cst.record.begin(deal, cst.stream.Conn().RemotePeer()); err != nil {
+			return err
+		}
+	}
+
 	if err := cborrpc.WriteCborRPC(cst.stream, deal); err != nil {
 		return err
 	}
+	cst.record.update(0, cst.lastPayment)
 
 	var resp DealResponse
 	if err := cborrpc.ReadCborRPC(cst.stream, &resp); err != nil {
@@ -201,11 +396,7 @@ func (cst *clientStream) doOneExchange(ctx context.Context, toFetch uint64, out
 		return xerrors.New("storage deal response had no Accepted section")
 	}
 
-	log.Info("Retrieval accepted, fetching blocks")
-
-	return cst.fetchBlocks(toFetch, out)
-
-	// TODO: maybe increase miner window size after success
+	return nil
 }
 
 func (cst *clientStream) fetchBlocks(toFetch uint64, out io.Writer) error {
@@ -214,8 +405,8 @@ func (cst *clientStream) fetchBlocks(toFetch uint64, out io.Writer) error {
 	for i := uint64(0); i < blocksToFetch; {
 		log.Infof("block %d of %d", i+1, blocksToFetch)
 
-		var block Block
-		if err := cborrpc.ReadCborRPC(cst.stream, &block); err != nil {
+		block, err := readBlock(cst.stream)
+		if err != nil {
 			return xerrors.Errorf("reading fetchBlock response: %w", err)
 		}
 
@@ -231,19 +422,7 @@ func (cst *clientStream) fetchBlocks(toFetch uint64, out io.Writer) error {
 }
 
 func (cst *clientStream) consumeBlockMessage(block Block, out io.Writer) (uint64, error) {
-	prefix, err := cid.PrefixFromBytes(block.Prefix)
-	if err != nil {
-		return 0, err
-	}
-
-	cid, err := prefix.Sum(block.Data)
-
-	blk, err := blocks.NewBlockWithCid(block.Data, cid)
-	if err != nil {
-		return 0, err
-	}
-
-	internal, err := cst.verifier.Verify(context.TODO(), blk, out)
+	internal, err := cst.verifier.Verify(context.TODO(), block, out)
 	if err != nil {
 		log.Warnf("block verify failed: %s", err)
 		return 0, err
@@ -259,15 +438,34 @@ func (cst *clientStream) consumeBlockMessage(block Block, out io.Writer) (uint64
 	return 1, nil
 }
 
-func (cst *clientStream) setupPayment(ctx context.Context, toSend types.BigInt) (api.PaymentInfo, error) {
+// setupPayment creates a voucher paying toSend for the next size bytes, plus
+// the unseal price with the first payment
+func (cst *clientStream) setupPayment(ctx context.Context, toSend types.BigInt, size uint64) (api.PaymentInfo, error) {
+	// checked before every payment, so a miner can't get paid more than the
+	// max price per byte, whatever terms it offered
+	if !cst.maxPricePerByte.Nil() {
+		limit := types.BigMul(cst.maxPricePerByte, types.NewInt(cst.paidBytes+size))
+		if types.BigCmp(types.BigAdd(cst.paidData, toSend), limit) > 0 {
+			return api.PaymentInfo{}, xerrors.Errorf("paying %s for %d bytes is over the max price of %s per byte", toSend, size, cst.maxPricePerByte)
+		}
+	}
+
 	amount := types.BigAdd(cst.transferred, toSend)
+	if !cst.unsealPrice.Nil() {
+		// the miner only unseals data after getting paid for it
+		amount = types.BigAdd(amount, cst.unsealPrice)
+	}
 
 	sv, err := cst.payapi.PaychVoucherCreate(ctx, cst.paych, amount, cst.lane)
 	if err != nil {
 		return api.PaymentInfo{}, err
 	}
 
+	cst.lastPayment = types.BigSub(amount, cst.transferred)
 	cst.transferred = amount
+	cst.unsealPrice = types.EmptyInt
+	cst.paidData = types.BigAdd(cst.paidData, toSend)
+	cst.paidBytes += size
 
 	return api.PaymentInfo{
 		Channel:        cst.paych,
@@ -0,0 +1,186 @@
+package retrieval
+
+import (
+	"context"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/cborrpc"
+	"github.com/filecoin-project/lotus/storage/sectorblocks"
+)
+
+// Raw and IPLD DAGs are sent block by block in depth-first order, the same
+// order they are stored in pieces. Window sizes are counted in bytes of the
+// retrieved data.
+//
+// > DealProposal{DAG: {Mode, Offset, Size}, Payment}
+// < Resp{Accept}
+// < ..Blocks
+// > DealProposal(...)
+// < ...
+
+func (hnd *handlerDeal) handleDAG(ctx context.Context, deal DealProposal) error {
+	offer := deal.Params.DAG
+
+	ser, err := sectorblocks.SerializationFor(offer.Mode)
+	if err != nil {
+		return err
+	}
+	if ser == sectorblocks.SerializationUnixfs0 {
+		return xerrors.New("unixfs files must be retrieved with unixfs deals")
+	}
+
+	if hnd.open != deal.Ref || hnd.dag == nil || hnd.ser != ser || hnd.at != offer.Offset {
+		log.Infof("opening dag for sending (open '%s') (@%d, want %d)", hnd.open, hnd.at, offer.Offset)
+		if err := hnd.openDAG(deal.Ref, ser, offer.Offset); err != nil {
+			return err
+		}
+	}
+
+	if offer.Offset+offer.Size > hnd.size {
+		return xerrors.Errorf("tried to read too much %d+%d > %d", offer.Offset, offer.Size, hnd.size)
+	}
+
+	resp := DealResponse{
+		Status: Accepted,
+	}
+	if err := cborrpc.WriteCborRPC(hnd.stream, resp); err != nil {
+		log.Errorf("Retrieval query: Write Accepted resp: %s", err)
+		return err
+	}
+
+	end := offer.Offset + offer.Size
+	for hnd.at < end {
+		nd, err := hnd.dag.Next(ctx)
+		if err != nil {
+			if err == io.EOF {
+				return xerrors.Errorf("dag ended at %d, before the requested %d bytes", hnd.at, end)
+			}
+			return err
+		}
+
+		if err := hnd.sendBlock(ctx, nd); err != nil {
+			return err
+		}
+
+		hnd.at += sectorblocks.TransferSize(ser, nd)
+	}
+
+	return nil
+}
+
+func (hnd *handlerDeal) openDAG(root cid.Cid, ser sectorblocks.SealSerialization, offset uint64) error {
+	if offset != 0 {
+		// TODO: seek, skipping subtrees ending before the offset
+		return xerrors.New("sending dags from nonzero offset not supported yet")
+	}
+
+	info, err := hnd.m.sectorBlocks.GetPieceInfo(root)
+	if err != nil {
+		return err
+	}
+	if info.Serialization != ser {
+		return xerrors.Errorf("dag stored in '%s' mode, not '%s'", info.Serialization.Mode(), ser.Mode())
+	}
+
+	hnd.ufs = nil
+	hnd.sel = nil
+	hnd.dag = sectorblocks.NewDAGWalker(hnd.sealedDAG(), root, ser == sectorblocks.SerializationIPLD)
+	hnd.ser = ser
+	hnd.open = root
+	hnd.at = 0
+	hnd.size = info.Size
+
+	return nil
+}
+
+// RetrieveDAG retrieves a DAG stored in raw or IPLD mode. In raw mode data of
+// the leaves is written to out, in IPLD mode the DAG is written as a CAR file
+func (c *Client) RetrieveDAG(ctx context.Context, root cid.Cid, mode api.SerializationMode, size uint64, terms Terms, miner peer.ID, client, minerAddr address.Address, out io.Writer) (err error) {
+	verifier, err := NewDAGVerifier(root, mode)
+	if err != nil {
+		return err
+	}
+
+	cst, err := c.openStream(ctx, root, size, terms, terms.Total, miner, client, minerAddr)
+	if err != nil {
+		return err
+	}
+	defer cst.close()
+	defer cst.resetOnCancel(ctx)()
+	defer func() {
+		cst.record.finish(err)
+	}()
+
+	for cst.offset < size {
+		toFetch := cst.windowSize
+		if toFetch+cst.offset > size {
+			toFetch = size - cst.offset
+		}
+		log.Infof("Retrieve %dB @%d", toFetch, cst.offset)
+
+		fetched, err := cst.doOneDAGExchange(ctx, mode, toFetch, verifier, out)
+		if err != nil {
+			return xerrors.Errorf("retrieval exchange: %w", err)
+		}
+
+		cst.offset += fetched
+		cst.record.update(fetched, types.EmptyInt)
+	}
+
+	if !verifier.Done() {
+		return xerrors.New("miner didn't send the whole dag")
+	}
+
+	log.Info("RETRIEVE SUCCESSFUL")
+	return nil
+}
+
+func (cst *clientStream) doOneDAGExchange(ctx context.Context, mode api.SerializationMode, toFetch uint64, verifier *DAGVerifier, out io.Writer) (uint64, error) {
+	payAmount := types.BigDiv(types.BigMul(cst.total, types.NewInt(toFetch)), cst.size)
+
+	payment, err := cst.setupPayment(ctx, payAmount, toFetch)
+	if err != nil {
+		return 0, xerrors.Errorf("setting up retrieval payment: %w", err)
+	}
+
+	deal := DealProposal{
+		Payment: payment,
+		Ref:     cst.root,
+		Params: RetParams{
+			DAG: &DAGOffer{
+				Mode:   mode,
+				Offset: cst.offset,
+				Size:   toFetch,
+			},
+		},
+	}
+
+	if err := cst.propose(deal); err != nil {
+		return 0, err
+	}
+
+	var fetched uint64
+	for fetched < toFetch {
+		block, err := readBlock(cst.stream)
+		if err != nil {
+			return 0, xerrors.Errorf("reading fetchBlock response: %w", err)
+		}
+
+		n, err := verifier.Verify(ctx, block, out)
+		if err != nil {
+			log.Warnf("block verify failed: %s", err)
+			return 0, err
+		}
+
+		fetched += n
+	}
+
+	return fetched, nil
+}
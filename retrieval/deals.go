@@ -0,0 +1,160 @@
+package retrieval
+
+import (
+	"math"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/statestore"
+)
+
+func init() {
+	cbor.RegisterCborType(Deal{})
+}
+
+// Deal is the record of a retrieval deal stream kept by clients and miners.
+// Deals are identified by the CID of the first proposal on the stream, which
+// both sides know
+type Deal struct {
+	ID    cid.Cid
+	State api.RetrievalState
+	Err   string
+
+	Root cid.Cid
+	Peer peer.ID
+
+	Paych address.Address
+	Lane  uint64
+
+	Transferred uint64
+	Paid        types.BigInt
+}
+
+func (d *Deal) Info() api.RetrievalDeal {
+	return api.RetrievalDeal{
+		ID:    d.ID,
+		State: d.State,
+		Err:   d.Err,
+
+		Root: d.Root,
+		Peer: d.Peer,
+
+		Paych: d.Paych,
+		Lane:  d.Lane,
+
+		Transferred: d.Transferred,
+		Paid:        d.Paid,
+	}
+}
+
+func proposalID(deal DealProposal) (cid.Cid, error) {
+	nd, err := cbor.WrapObject(deal, math.MaxUint64, -1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return nd.Cid(), nil
+}
+
+// dealRecord tracks a deal on one side of a deal stream
+type dealRecord struct {
+	deals *statestore.StateStore
+	id    cid.Cid
+}
+
+// begin starts tracking the deal with the first proposal on the stream
+func (r *dealRecord) begin(deal DealProposal, p peer.ID) error {
+	id, err := proposalID(deal)
+	if err != nil {
+		return xerrors.Errorf("computing retrieval deal id: %w", err)
+	}
+
+	d := &Deal{
+		ID:    id,
+		State: api.RetrievalOngoing,
+
+		Root: deal.Ref,
+		Peer: p,
+
+		Paych: deal.Payment.Channel,
+		Paid:  types.NewInt(0),
+	}
+	if len(deal.Payment.Vouchers) > 0 {
+		d.Lane = deal.Payment.Vouchers[0].Lane
+	}
+
+	if err := r.deals.Begin(id, d); err != nil {
+		return xerrors.Errorf("recording retrieval deal %s: %w", id, err)
+	}
+	r.id = id
+	return nil
+}
+
+func (r *dealRecord) started() bool {
+	return r.id.Defined()
+}
+
+// update records bytes transferred and funds paid since the last update
+func (r *dealRecord) update(transferred uint64, paid types.BigInt) {
+	if !r.started() {
+		return
+	}
+
+	err := r.deals.Mutate(r.id, func(d *Deal) error {
+		d.Transferred += transferred
+		if !paid.Nil() {
+			d.Paid = types.BigAdd(d.Paid, paid)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("updating retrieval deal %s: %s", r.id, err)
+	}
+}
+
+// finish records the outcome of the deal stream
+func (r *dealRecord) finish(derr error) {
+	if !r.started() {
+		return
+	}
+
+	err := r.deals.Mutate(r.id, func(d *Deal) error {
+		d.State = api.RetrievalCompleted
+		if derr != nil {
+			d.State = api.RetrievalFailed
+			d.Err = derr.Error()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("finishing retrieval deal %s: %s", r.id, err)
+	}
+}
+
+func listDeals(deals *statestore.StateStore) ([]api.RetrievalDeal, error) {
+	var ds []Deal
+	if err := deals.List(&ds); err != nil {
+		return nil, err
+	}
+
+	out := make([]api.RetrievalDeal, len(ds))
+	for i, d := range ds {
+		out[i] = d.Info()
+	}
+	return out, nil
+}
+
+// ListDeals lists retrieval deals made by the client
+func (c *Client) ListDeals() ([]api.RetrievalDeal, error) {
+	return listDeals(c.deals)
+}
+
+// ListDeals lists retrieval deals served by the miner
+func (m *Miner) ListDeals() ([]api.RetrievalDeal, error) {
+	return listDeals(m.deals)
+}
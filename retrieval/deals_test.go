@@ -0,0 +1,86 @@
+package retrieval
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/statestore"
+)
+
+func testProposal(t *testing.T) DealProposal {
+	root, err := cid.V1Builder{Codec: cid.Raw, MhType: mh.SHA2_256}.Sum([]byte("root"))
+	require.NoError(t, err)
+	ch, err := address.NewIDAddress(100)
+	require.NoError(t, err)
+
+	return DealProposal{
+		Payment: api.PaymentInfo{
+			Channel:  ch,
+			Vouchers: []*types.SignedVoucher{{Lane: 3, Amount: types.NewInt(10)}},
+		},
+		Ref: root,
+		Params: RetParams{
+			Unixfs0: &Unixfs0Offer{Size: chunk},
+		},
+	}
+}
+
+func TestDealRecord(t *testing.T) {
+	prop := testProposal(t)
+
+	client := &dealRecord{deals: statestore.New(datastore.NewMapDatastore())}
+	miner := &dealRecord{deals: statestore.New(datastore.NewMapDatastore())}
+
+	// updates before the first proposal aren't recorded anywhere
+	client.update(chunk, types.NewInt(10))
+	client.finish(nil)
+	require.False(t, client.started())
+
+	require.NoError(t, client.begin(prop, peer.ID("miner")))
+	require.NoError(t, miner.begin(prop, peer.ID("client")))
+	require.True(t, client.started())
+	require.Equal(t, client.id, miner.id, "both sides know the deal by the same id")
+
+	client.update(0, types.NewInt(10))
+	client.update(chunk, types.EmptyInt)
+	client.update(0, types.NewInt(15))
+	client.update(chunk/2, types.EmptyInt)
+	client.finish(nil)
+
+	deals, err := listDeals(client.deals)
+	require.NoError(t, err)
+	require.Equal(t, []api.RetrievalDeal{{
+		ID:    client.id,
+		State: api.RetrievalCompleted,
+
+		Root: prop.Ref,
+		Peer: peer.ID("miner"),
+
+		Paych: prop.Payment.Channel,
+		Lane:  3,
+
+		Transferred: chunk + chunk/2,
+		Paid:        types.NewInt(25),
+	}}, deals)
+
+	miner.update(0, types.NewInt(10))
+	miner.finish(xerrors.New("stream reset"))
+
+	deals, err = listDeals(miner.deals)
+	require.NoError(t, err)
+	require.Len(t, deals, 1)
+	require.Equal(t, api.RetrievalFailed, deals[0].State)
+	require.Equal(t, "stream reset", deals[0].Err)
+	require.Equal(t, peer.ID("client"), deals[0].Peer)
+	require.Equal(t, uint64(0), deals[0].Transferred)
+	require.Equal(t, types.NewInt(10), deals[0].Paid)
+}
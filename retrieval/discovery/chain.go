@@ -0,0 +1,87 @@
+package discovery
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// chainQueryParallel limits how many miners are queried at once
+const chainQueryParallel = 16
+
+type chainAPI interface {
+	StateListMiners(context.Context, *types.TipSet) ([]address.Address, error)
+	StateMinerPeerID(ctx context.Context, m address.Address, ts *types.TipSet) (peer.ID, error)
+}
+
+// Querier asks retrieval peers about data they store
+type Querier interface {
+	Query(ctx context.Context, p RetrievalPeer, data cid.Cid) api.QueryOffer
+}
+
+// Chain finds peers by asking all miners listed on chain whether they have
+// the data. Peers are returned with their offers
+type Chain struct {
+	api chainAPI
+	q   Querier
+}
+
+func NewChain(api chainAPI, q Querier) *Chain {
+	return &Chain{api: api, q: q}
+}
+
+func (c *Chain) GetPeers(ctx context.Context, data cid.Cid) ([]RetrievalPeer, error) {
+	miners, err := c.api.StateListMiners(ctx, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("listing miners: %w", err)
+	}
+
+	found := make([]*RetrievalPeer, len(miners))
+	throttle := make(chan struct{}, chainQueryParallel)
+
+	var wg sync.WaitGroup
+	wg.Add(len(miners))
+	for i, miner := range miners {
+		go func(i int, miner address.Address) {
+			defer wg.Done()
+
+			select {
+			case throttle <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-throttle }()
+
+			pid, err := c.api.StateMinerPeerID(ctx, miner, nil)
+			if err != nil || pid == "" {
+				return
+			}
+
+			p := RetrievalPeer{Address: miner, ID: pid}
+			offer := c.q.Query(ctx, p, data)
+			if offer.Err != "" {
+				return
+			}
+			p.Offer = &offer
+			found[i] = &p
+		}(i, miner)
+	}
+	wg.Wait()
+
+	out := []RetrievalPeer{}
+	for _, p := range found {
+		if p != nil {
+			out = append(out, *p)
+		}
+	}
+	return out, nil
+}
+
+var _ PeerResolver = &Chain{}
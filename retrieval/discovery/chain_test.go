@@ -0,0 +1,109 @@
+package discovery
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type testChain struct {
+	miners map[address.Address]peer.ID
+
+	lk      sync.Mutex
+	queried map[address.Address]int
+	has     map[address.Address]bool
+}
+
+func (tc *testChain) StateListMiners(context.Context, *types.TipSet) ([]address.Address, error) {
+	var out []address.Address
+	for m := range tc.miners {
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+func (tc *testChain) StateMinerPeerID(ctx context.Context, m address.Address, ts *types.TipSet) (peer.ID, error) {
+	return tc.miners[m], nil
+}
+
+func (tc *testChain) Query(ctx context.Context, p RetrievalPeer, data cid.Cid) api.QueryOffer {
+	tc.lk.Lock()
+	defer tc.lk.Unlock()
+
+	tc.queried[p.Address]++
+	if !tc.has[p.Address] {
+		return api.QueryOffer{Err: "data not available", Miner: p.Address, MinerPeerID: p.ID}
+	}
+	return api.QueryOffer{Root: data, Size: 100, Miner: p.Address, MinerPeerID: p.ID}
+}
+
+func testAddr(t *testing.T, id uint64) address.Address {
+	a, err := address.NewIDAddress(id)
+	require.NoError(t, err)
+	return a
+}
+
+func testData(t *testing.T) cid.Cid {
+	c, err := cid.V1Builder{Codec: cid.Raw, MhType: mh.SHA2_256}.Sum([]byte("data"))
+	require.NoError(t, err)
+	return c
+}
+
+func TestChainOffers(t *testing.T) {
+	m1, m2, m3 := testAddr(t, 101), testAddr(t, 102), testAddr(t, 103)
+	tc := &testChain{
+		miners: map[address.Address]peer.ID{
+			m1: peer.ID("m1"),
+			m2: peer.ID("m2"),
+			m3: "", // no peer ID set
+		},
+		queried: map[address.Address]int{},
+		has:     map[address.Address]bool{m1: true, m3: true},
+	}
+
+	data := testData(t)
+	peers, err := NewChain(tc, tc).GetPeers(context.Background(), data)
+	require.NoError(t, err)
+
+	require.Len(t, peers, 1)
+	require.Equal(t, m1, peers[0].Address)
+	require.Equal(t, peer.ID("m1"), peers[0].ID)
+	require.NotNil(t, peers[0].Offer)
+	require.Equal(t, data, peers[0].Offer.Root)
+	require.Equal(t, uint64(100), peers[0].Offer.Size)
+
+	require.Equal(t, map[address.Address]int{m1: 1, m2: 1}, tc.queried)
+}
+
+type staticResolver []RetrievalPeer
+
+func (sr staticResolver) GetPeers(ctx context.Context, data cid.Cid) ([]RetrievalPeer, error) {
+	return sr, nil
+}
+
+func TestMultiKeepsOffers(t *testing.T) {
+	m1, m2 := testAddr(t, 101), testAddr(t, 102)
+	offer := &api.QueryOffer{Size: 100, Miner: m1, MinerPeerID: peer.ID("m1")}
+
+	// peers known locally are also found on chain, with an offer
+	local := staticResolver{{Address: m1}, {Address: m2, ID: peer.ID("m2")}}
+	chain := staticResolver{{Address: m1, ID: peer.ID("m1"), Offer: offer}}
+	dht := staticResolver{{ID: peer.ID("m2")}, {ID: peer.ID("m3")}}
+
+	peers, err := Multi(local, chain, dht).GetPeers(context.Background(), testData(t))
+	require.NoError(t, err)
+	require.Equal(t, []RetrievalPeer{
+		{Address: m1, ID: peer.ID("m1"), Offer: offer},
+		{Address: m2, ID: peer.ID("m2")},
+		{ID: peer.ID("m3")},
+	}, peers)
+}
@@ -0,0 +1,32 @@
+package discovery
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+// dhtMaxProviders limits how many provider records are looked up
+const dhtMaxProviders = 20
+
+// DHT finds peers by provider records of the data. Miners are identified
+// only by peer ID, their addresses are learned when querying them
+type DHT struct {
+	rt routing.ContentRouting
+}
+
+func NewDHT(rt routing.ContentRouting) *DHT {
+	return &DHT{rt: rt}
+}
+
+func (d *DHT) GetPeers(ctx context.Context, data cid.Cid) ([]RetrievalPeer, error) {
+	out := []RetrievalPeer{}
+	for info := range d.rt.FindProvidersAsync(ctx, data, dhtMaxProviders) {
+		out = append(out, RetrievalPeer{ID: info.ID})
+	}
+
+	return out, ctx.Err()
+}
+
+var _ PeerResolver = &DHT{}
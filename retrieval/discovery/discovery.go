@@ -1,10 +1,16 @@
 package discovery
 
 import (
-	"github.com/filecoin-project/lotus/chain/address"
+	"context"
+	"sync"
+	"time"
+
 	"github.com/ipfs/go-cid"
 	cbor "github.com/ipfs/go-ipld-cbor"
 	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/address"
 )
 
 func init() {
@@ -12,14 +18,109 @@ func init() {
 }
 
 type RetrievalPeer struct {
-	Address address.Address
-	ID      peer.ID // optional
+	Address address.Address // undefined if not known, learned when querying the peer
+	ID      peer.ID         // optional
+
+	// Offer is set by resolvers which query peers to find them, so that
+	// they don't have to be queried again. It isn't stored
+	Offer *api.QueryOffer
 }
 
 type PeerResolver interface {
-	GetPeers(data cid.Cid) ([]RetrievalPeer, error) // TODO: channel
+	GetPeers(ctx context.Context, data cid.Cid) ([]RetrievalPeer, error) // TODO: channel
+}
+
+type timeoutResolver struct {
+	r       PeerResolver
+	timeout time.Duration
 }
 
-func Multi(r PeerResolver) PeerResolver { // TODO: actually support multiple mechanisms
-	return r
+// Timeout limits how long the resolver can take to find peers
+func Timeout(r PeerResolver, timeout time.Duration) PeerResolver {
+	return &timeoutResolver{r: r, timeout: timeout}
 }
+
+func (t *timeoutResolver) GetPeers(ctx context.Context, data cid.Cid) ([]RetrievalPeer, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	return t.r.GetPeers(ctx, data)
+}
+
+type multiResolver struct {
+	resolvers []PeerResolver
+}
+
+// Multi finds peers with all resolvers in parallel. Peers are deduplicated
+// and returned in the order of resolvers they were found by. Failing
+// resolvers are skipped, unless all of them fail
+func Multi(rs ...PeerResolver) PeerResolver {
+	return &multiResolver{resolvers: rs}
+}
+
+func (m *multiResolver) GetPeers(ctx context.Context, data cid.Cid) ([]RetrievalPeer, error) {
+	found := make([][]RetrievalPeer, len(m.resolvers))
+	errs := make([]error, len(m.resolvers))
+
+	var wg sync.WaitGroup
+	wg.Add(len(m.resolvers))
+	for i, r := range m.resolvers {
+		go func(i int, r PeerResolver) {
+			defer wg.Done()
+			found[i], errs[i] = r.GetPeers(ctx, data)
+		}(i, r)
+	}
+	wg.Wait()
+
+	var out []RetrievalPeer
+	var lastErr error
+	failed := 0
+	for i, peers := range found {
+		if errs[i] != nil {
+			log.Warnf("finding retrieval peers for %s: %s", data, errs[i])
+			lastErr = errs[i]
+			failed++
+			continue
+		}
+
+		for _, p := range peers {
+			out = mergePeer(out, p)
+		}
+	}
+
+	if failed > 0 && failed == len(m.resolvers) {
+		return nil, lastErr
+	}
+	if out == nil {
+		out = []RetrievalPeer{}
+	}
+	return out, nil
+}
+
+// mergePeer adds p to peers, unless it's already there. Peers are matched by
+// peer ID or miner address, missing fields of known peers are filled in
+func mergePeer(peers []RetrievalPeer, p RetrievalPeer) []RetrievalPeer {
+	for i, known := range peers {
+		sameID := p.ID != "" && p.ID == known.ID
+		sameAddr := !p.Address.Empty() && p.Address == known.Address
+		if !sameID && !sameAddr {
+			continue
+		}
+
+		if known.ID == "" {
+			peers[i].ID = p.ID
+		}
+		if known.Address.Empty() {
+			peers[i].Address = p.Address
+		}
+		if known.Offer == nil {
+			peers[i].Offer = p.Offer
+		}
+		return peers
+	}
+
+	return append(peers, p)
+}
+
+var _ PeerResolver = &multiResolver{}
+var _ PeerResolver = &timeoutResolver{}
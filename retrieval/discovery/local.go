@@ -1,6 +1,9 @@
 package discovery
 
 import (
+	"context"
+	"sync"
+
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/namespace"
@@ -13,8 +16,10 @@ import (
 
 var log = logging.Logger("ret-discovery")
 
+// Local tracks miners we made storage deals with, for each stored CID
 type Local struct {
 	ds datastore.Datastore
+	lk sync.Mutex
 }
 
 func NewLocal(ds dtypes.MetadataDS) *Local {
@@ -22,12 +27,26 @@ func NewLocal(ds dtypes.MetadataDS) *Local {
 }
 
 func (l *Local) AddPeer(cid cid.Cid, peer RetrievalPeer) error {
-	// TODO: allow multiple peers here
-	//  (implement an util for tracking map[thing][]otherThing, use in sectorBlockstore too)
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	peer.Offer = nil
+
+	peers, err := l.getPeers(cid)
+	if err != nil {
+		return err
+	}
 
-	log.Warn("Tracking multiple retrieval peers not implemented")
+	for i, p := range peers {
+		if p.Address == peer.Address {
+			peers = append(peers[:i], peers[i+1:]...)
+			break
+		}
+	}
+	// most recent deals first
+	peers = append([]RetrievalPeer{peer}, peers...)
 
-	entry, err := cbor.DumpObject(peer)
+	entry, err := cbor.DumpObject(peers)
 	if err != nil {
 		return err
 	}
@@ -35,7 +54,14 @@ func (l *Local) AddPeer(cid cid.Cid, peer RetrievalPeer) error {
 	return l.ds.Put(dshelp.CidToDsKey(cid), entry)
 }
 
-func (l *Local) GetPeers(data cid.Cid) ([]RetrievalPeer, error) {
+func (l *Local) GetPeers(ctx context.Context, data cid.Cid) ([]RetrievalPeer, error) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	return l.getPeers(data)
+}
+
+func (l *Local) getPeers(data cid.Cid) ([]RetrievalPeer, error) {
 	entry, err := l.ds.Get(dshelp.CidToDsKey(data))
 	if err == datastore.ErrNotFound {
 		return []RetrievalPeer{}, nil
@@ -43,11 +69,17 @@ func (l *Local) GetPeers(data cid.Cid) ([]RetrievalPeer, error) {
 	if err != nil {
 		return nil, err
 	}
-	var peer RetrievalPeer
-	if err := cbor.DecodeInto(entry, &peer); err != nil {
-		return nil, err
+
+	var peers []RetrievalPeer
+	if err := cbor.DecodeInto(entry, &peers); err != nil {
+		// entries written before multiple peers were tracked hold one peer
+		var peer RetrievalPeer
+		if err := cbor.DecodeInto(entry, &peer); err != nil {
+			return nil, err
+		}
+		peers = []RetrievalPeer{peer}
 	}
-	return []RetrievalPeer{peer}, nil
+	return peers, nil
 }
 
 var _ PeerResolver = &Local{}
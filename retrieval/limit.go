@@ -0,0 +1,51 @@
+package retrieval
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter limits the rate of sent bytes. Each send is delayed until bytes
+// sent before it fit within the rate
+type rateLimiter struct {
+	lk sync.Mutex
+
+	rate uint64    // bytes per second, 0 means no limit
+	next time.Time // when bytes sent so far fit within the rate
+}
+
+func newRateLimiter(rate uint64) *rateLimiter {
+	return &rateLimiter{rate: rate}
+}
+
+// wait blocks until n bytes can be sent
+func (r *rateLimiter) wait(ctx context.Context, n uint64) error {
+	if r.rate == 0 {
+		return nil
+	}
+
+	r.lk.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	at := r.next
+	r.next = r.next.Add(time.Duration(n) * time.Second / time.Duration(r.rate))
+	r.lk.Unlock()
+
+	delay := at.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -3,36 +3,107 @@ package retrieval
 import (
 	"context"
 	"io"
+	"sync"
 
 	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	ipld "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-merkledag"
-	unixfile "github.com/ipfs/go-unixfs/file"
 	"github.com/libp2p/go-libp2p-core/network"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/lotus/api"
-	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/address"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/lib/cborrpc"
+	"github.com/filecoin-project/lotus/lib/statestore"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
 	"github.com/filecoin-project/lotus/storage/sectorblocks"
 )
 
+// MinerConfig sets retrieval prices of the miner
+type MinerConfig struct {
+	PricePerByte types.BigInt
+
+	// UnsealPrice has to be paid upfront before sealed data gets unsealed
+	UnsealPrice types.BigInt
+
+	// PaymentInterval is the most bytes sent per payment, at least one
+	// unixfs chunk
+	PaymentInterval uint64
+
+	// MaxBytesPerSecond limits the rate data is sent at to all clients,
+	// MaxStreamBytesPerSecond to each deal stream. 0 means no limit
+	MaxBytesPerSecond       uint64
+	MaxStreamBytesPerSecond uint64
+}
+
 type Miner struct {
 	sectorBlocks *sectorblocks.SectorBlocks
 	full         api.FullNode
+	maddr        address.Address
+
+	pricePerByte    types.BigInt
+	unsealPrice     types.BigInt
+	paymentInterval uint64
 
-	pricePerByte types.BigInt
-	// TODO: Unseal price
+	limiter         *rateLimiter
+	streamRateLimit uint64
+
+	// clients retrieving from several deal streams at once pay the unseal
+	// price once, on one of the streams. Payments are set to true once used
+	// to unseal a piece, and are dropped when the piece is removed from the
+	// unsealed cache
+	unsealLk     sync.Mutex
+	unsealPaid   map[unsealPayment]bool
+	unsealPieces map[string]map[unsealPayment]struct{} // piece key -> payments
+
+	deals *statestore.StateStore
+}
+
+// unsealPayment identifies the payment channel which paid for unsealing data
+// under root
+type unsealPayment struct {
+	paych address.Address
+	root  cid.Cid
 }
 
-func NewMiner(sblks *sectorblocks.SectorBlocks, full api.FullNode) *Miner {
-	return &Miner{
+func NewMiner(sblks *sectorblocks.SectorBlocks, full api.FullNode, maddr address.Address, cfg MinerConfig, ds dtypes.MetadataDS) *Miner {
+	m := &Miner{
 		sectorBlocks: sblks,
 		full:         full,
+		maddr:        maddr,
+
+		deals: statestore.New(namespace.Wrap(ds, datastore.NewKey("/retrieval/miner"))),
+
+		pricePerByte:    cfg.PricePerByte,
+		unsealPrice:     cfg.UnsealPrice,
+		paymentInterval: cfg.PaymentInterval,
 
-		pricePerByte: types.NewInt(2), // TODO: allow setting
+		limiter:         newRateLimiter(cfg.MaxBytesPerSecond),
+		streamRateLimit: cfg.MaxStreamBytesPerSecond,
+
+		unsealPaid:   map[unsealPayment]bool{},
+		unsealPieces: map[string]map[unsealPayment]struct{}{},
+	}
+
+	sblks.OnUnsealedRemoved(m.unsealRemoved)
+
+	return m
+}
+
+// unsealRemoved drops payments used to unseal a piece when it's no longer
+// unsealed, so clients pay again for unsealing it
+func (m *Miner) unsealRemoved(pieceKey string) {
+	m.unsealLk.Lock()
+	defer m.unsealLk.Unlock()
+
+	for key := range m.unsealPieces[pieceKey] {
+		delete(m.unsealPaid, key)
 	}
+	delete(m.unsealPieces, pieceKey)
 }
 
 func writeErr(stream network.Stream, err error) {
@@ -52,21 +123,33 @@ func (m *Miner) HandleQueryStream(stream network.Stream) {
 		return
 	}
 
-	size, err := m.sectorBlocks.GetSize(query.Piece)
+	info, err := m.sectorBlocks.GetPieceInfo(query.Piece)
 	if err != nil && err != sectorblocks.ErrNotFound {
-		log.Errorf("Retrieval query: GetRefs: %s", err)
+		log.Errorf("Retrieval query: GetPieceInfo: %s", err)
 		return
 	}
 
 	answer := QueryResponse{
 		Status: Unavailable,
+		Miner:  m.maddr,
 	}
 	if err == nil {
 		answer.Status = Available
 
-		// TODO: get price, look for already unsealed ref to reduce work
-		answer.MinPrice = types.BigMul(types.NewInt(uint64(size)), m.pricePerByte)
-		answer.Size = uint64(size) // TODO: verify on intermediate
+		answer.MinPrice = types.BigMul(types.NewInt(info.Size), m.pricePerByte)
+		answer.Size = info.Size // TODO: verify on intermediate
+		answer.Serialization = info.Serialization.Mode()
+		answer.PaymentInterval = m.paymentInterval
+
+		answer.UnsealPrice = types.NewInt(0)
+		unsealed, err := m.sectorBlocks.IsUnsealed(query.Piece)
+		if err != nil {
+			log.Errorf("Retrieval query: IsUnsealed: %s", err)
+			return
+		}
+		if !unsealed {
+			answer.UnsealPrice = m.unsealPrice
+		}
 	}
 
 	if err := cborrpc.WriteCborRPC(stream, answer); err != nil {
@@ -79,35 +162,63 @@ type handlerDeal struct {
 	m      *Miner
 	stream network.Stream
 
-	ufsr sectorblocks.UnixfsReader
+	ufs  *unixfsWalker
+	ds   ipld.DAGService
+	dag  *sectorblocks.DAGWalker
+	ser  sectorblocks.SealSerialization
 	open cid.Cid
 	at   uint64
 	size uint64
+
+	sel      *selectorWalker
+	selPath  []string
+	selDepth int64
+
+	// unsealKey identifies unseal payments for the current deal
+	unsealKey unsealPayment
+	// unsealPayments were made on this stream
+	unsealPayments []unsealPayment
+
+	limiter *rateLimiter
+
+	record dealRecord
 }
 
 func (m *Miner) HandleDealStream(stream network.Stream) {
 	defer stream.Close()
 
+	// cancelled when the stream is done, so waits for sending don't outlive
+	// it
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
 	hnd := &handlerDeal{
 		m: m,
 
 		stream: stream,
+
+		limiter: newRateLimiter(m.streamRateLimit),
+
+		record: dealRecord{deals: m.deals},
 	}
+	defer hnd.dropUnsealPayments()
 
 	var err error
 	more := true
 
 	for more {
-		more, err = hnd.handleNext() // TODO: 'more' bool
+		more, err = hnd.handleNext(ctx) // TODO: 'more' bool
 		if err != nil {
 			writeErr(stream, err)
+			hnd.record.finish(err)
 			return
 		}
 	}
 
+	hnd.record.finish(nil)
 }
 
-func (hnd *handlerDeal) handleNext() (bool, error) {
+func (hnd *handlerDeal) handleNext(ctx context.Context) (bool, error) {
 	var deal DealProposal
 	if err := cborrpc.ReadCborRPC(hnd.stream, &deal); err != nil {
 		if err == io.EOF { // client sent all deals
@@ -116,26 +227,57 @@ func (hnd *handlerDeal) handleNext() (bool, error) {
 		return false, err
 	}
 
-	if deal.Params.Unixfs0 == nil {
+	var size uint64
+	switch {
+	case deal.Params.Unixfs0 != nil:
+		size = deal.Params.Unixfs0.Size
+	case deal.Params.DAG != nil:
+		size = deal.Params.DAG.Size
+	case deal.Params.Selector != nil:
+		size = deal.Params.Selector.Size
+	default:
 		return false, xerrors.New("unknown deal type")
 	}
 
-	unixfs0 := deal.Params.Unixfs0
+	if size > hnd.m.paymentInterval {
+		return false, xerrors.Errorf("deal for %d bytes is larger than the payment interval of %d bytes", size, hnd.m.paymentInterval)
+	}
+
+	paid, err := hnd.processPayment(ctx, deal, size)
+	if err != nil {
+		return false, err
+	}
+
+	if !hnd.record.started() {
+		if err := hnd.record.begin(deal, hnd.stream.Conn().RemotePeer()); err != nil {
+			return false, err
+		}
+	}
+	hnd.record.update(0, paid)
 
-	if len(deal.Payment.Vouchers) != 1 {
-		return false, xerrors.Errorf("expected one signed voucher, got %d", len(deal.Payment.Vouchers))
+	if deal.Params.DAG != nil {
+		if err := hnd.handleDAG(ctx, deal); err != nil {
+			return false, err
+		}
+		hnd.record.update(size, types.EmptyInt)
+		return true, nil
 	}
 
-	expPayment := types.BigMul(hnd.m.pricePerByte, types.NewInt(deal.Params.Unixfs0.Size))
-	if _, err := hnd.m.full.PaychVoucherAdd(context.TODO(), deal.Payment.Channel, deal.Payment.Vouchers[0], nil, expPayment); err != nil {
-		return false, xerrors.Errorf("processing retrieval payment: %w", err)
+	if deal.Params.Selector != nil {
+		if err := hnd.handleSelector(ctx, deal); err != nil {
+			return false, err
+		}
+		hnd.record.update(size, types.EmptyInt)
+		return true, nil
 	}
 
+	unixfs0 := deal.Params.Unixfs0
+
 	// If the file isn't open (new deal stream), isn't the right file, or isn't
 	// at the right offset, (re)open it
-	if hnd.open != deal.Ref || hnd.at != unixfs0.Offset {
+	if hnd.open != deal.Ref || hnd.ufs == nil || hnd.at != unixfs0.Offset {
 		log.Infof("opening file for sending (open '%s') (@%d, want %d)", hnd.open, hnd.at, unixfs0.Offset)
-		if err := hnd.openFile(deal); err != nil {
+		if err := hnd.openFile(ctx, deal); err != nil {
 			return false, err
 		}
 	}
@@ -144,55 +286,114 @@ func (hnd *handlerDeal) handleNext() (bool, error) {
 		return false, xerrors.Errorf("tried to read too much %d+%d > %d", unixfs0.Offset, unixfs0.Size, hnd.size)
 	}
 
-	err := hnd.accept(deal)
+	err = hnd.accept(ctx, deal)
 	if err != nil {
 		return false, err
 	}
+	hnd.record.update(size, types.EmptyInt)
 	return true, nil
 }
 
-func (hnd *handlerDeal) openFile(deal DealProposal) error {
-	unixfs0 := deal.Params.Unixfs0
+// processPayment adds the voucher paying for size bytes of the deal, and
+// records whether the unseal price was paid on top of it. Unseal payments are
+// shared by streams from the same payment channel. It returns the amount paid
+func (hnd *handlerDeal) processPayment(ctx context.Context, deal DealProposal, size uint64) (types.BigInt, error) {
+	payment := deal.Payment
+	if len(payment.Vouchers) != 1 {
+		return types.EmptyInt, xerrors.Errorf("expected one signed voucher, got %d", len(payment.Vouchers))
+	}
 
-	if unixfs0.Offset != 0 {
-		// TODO: Implement SeekBlock (like ReadBlock) in go-unixfs
-		return xerrors.New("sending merkle proofs for nonzero offset not supported yet")
+	expPayment := types.BigMul(hnd.m.pricePerByte, types.NewInt(size))
+	paid, err := hnd.m.full.PaychVoucherAdd(ctx, payment.Channel, payment.Vouchers[0], nil, expPayment)
+	if err != nil {
+		return types.EmptyInt, xerrors.Errorf("processing retrieval payment: %w", err)
 	}
-	hnd.at = unixfs0.Offset
 
-	bstore := hnd.m.sectorBlocks.SealedBlockstore(func() error {
-		return nil // TODO: approve unsealing based on amount paid
-	})
+	hnd.unsealKey = unsealPayment{paych: payment.Channel, root: deal.Ref}
+	if types.BigSub(paid, expPayment).LessThan(hnd.m.unsealPrice) {
+		return paid, nil
+	}
 
-	ds := merkledag.NewDAGService(blockservice.New(bstore, nil))
-	rootNd, err := ds.Get(context.TODO(), deal.Ref)
-	if err != nil {
-		return err
+	hnd.m.unsealLk.Lock()
+	defer hnd.m.unsealLk.Unlock()
+
+	if _, ok := hnd.m.unsealPaid[hnd.unsealKey]; !ok {
+		hnd.m.unsealPaid[hnd.unsealKey] = false
+		hnd.unsealPayments = append(hnd.unsealPayments, hnd.unsealKey)
 	}
 
-	fsr, err := unixfile.NewUnixfsFile(context.TODO(), ds, rootNd)
-	if err != nil {
-		return err
+	return paid, nil
+}
+
+// approveUnseal allows unsealing the piece once the unseal price was paid
+func (hnd *handlerDeal) approveUnseal(pieceKey string) error {
+	hnd.m.unsealLk.Lock()
+	defer hnd.m.unsealLk.Unlock()
+
+	if _, ok := hnd.m.unsealPaid[hnd.unsealKey]; !ok {
+		return xerrors.Errorf("unsealing data requires paying the unseal price of %s upfront", hnd.m.unsealPrice)
 	}
 
-	var ok bool
-	hnd.ufsr, ok = fsr.(sectorblocks.UnixfsReader)
+	hnd.m.unsealPaid[hnd.unsealKey] = true
+	payments, ok := hnd.m.unsealPieces[pieceKey]
 	if !ok {
-		return xerrors.Errorf("file %s didn't implement sectorblocks.UnixfsReader", deal.Ref)
+		payments = map[unsealPayment]struct{}{}
+		hnd.m.unsealPieces[pieceKey] = payments
+	}
+	payments[hnd.unsealKey] = struct{}{}
+
+	return nil
+}
+
+// dropUnsealPayments forgets unseal payments made on the stream which weren't
+// used to unseal anything. Used payments are kept while the pieces they
+// unsealed stay in the unsealed cache
+func (hnd *handlerDeal) dropUnsealPayments() {
+	hnd.m.unsealLk.Lock()
+	defer hnd.m.unsealLk.Unlock()
+
+	for _, key := range hnd.unsealPayments {
+		if used, ok := hnd.m.unsealPaid[key]; ok && !used {
+			delete(hnd.m.unsealPaid, key)
+		}
+	}
+}
+
+func (hnd *handlerDeal) openFile(ctx context.Context, deal DealProposal) error {
+	unixfs0 := deal.Params.Unixfs0
+
+	hnd.dag = nil
+	hnd.sel = nil
+
+	ds := hnd.sealedDAG()
+	rootNd, err := ds.Get(ctx, deal.Ref)
+	if err != nil {
+		return err
 	}
 
-	isize, err := hnd.ufsr.Size()
+	hnd.size, err = unixfsFileSize(rootNd)
 	if err != nil {
 		return err
 	}
-	hnd.size = uint64(isize)
+	if unixfs0.Offset > hnd.size {
+		return xerrors.Errorf("offset %d is past the end of the file (%d bytes)", unixfs0.Offset, hnd.size)
+	}
 
+	hnd.ds = ds
+	hnd.ufs = newUnixfsWalker(deal.Ref, unixfs0.Offset)
+	hnd.at = unixfs0.Offset
 	hnd.open = deal.Ref
 
 	return nil
 }
 
-func (hnd *handlerDeal) accept(deal DealProposal) error {
+func (hnd *handlerDeal) sealedDAG() ipld.DAGService {
+	bstore := hnd.m.sectorBlocks.SealedBlockstore(hnd.approveUnseal)
+
+	return merkledag.NewDAGService(blockservice.New(bstore, nil))
+}
+
+func (hnd *handlerDeal) accept(ctx context.Context, deal DealProposal) error {
 	unixfs0 := deal.Params.Unixfs0
 
 	resp := DealResponse{
@@ -203,38 +404,58 @@ func (hnd *handlerDeal) accept(deal DealProposal) error {
 		return err
 	}
 
-	blocksToSend := (unixfs0.Size + build.UnixfsChunkSize - 1) / build.UnixfsChunkSize
-	for i := uint64(0); i < blocksToSend; {
-		data, offset, nd, err := hnd.ufsr.ReadBlock(context.TODO())
+	end := unixfs0.Offset + unixfs0.Size
+	for hnd.at < end {
+		ref, err := hnd.ufs.next()
 		if err != nil {
+			if err == io.EOF {
+				return xerrors.Errorf("file ended at %d, before the requested %d bytes", hnd.at, end)
+			}
 			return err
 		}
 
-		log.Infof("sending block for a deal: %s", nd.Cid())
+		nd, err := hnd.ds.Get(ctx, ref.c)
+		if err != nil {
+			return xerrors.Errorf("getting file node %s: %w", ref.c, err)
+		}
 
-		if offset != unixfs0.Offset {
-			return xerrors.Errorf("ReadBlock on wrong offset: want %d, got %d", unixfs0.Offset, offset)
+		data, err := hnd.ufs.visit(nd, ref)
+		if err != nil {
+			return err
 		}
 
-		/*if uint64(len(data)) != deal.Unixfs0.Size { // TODO: Fix for internal nodes (and any other node too)
-			writeErr(stream, xerrors.Errorf("ReadBlock data with wrong size: want %d, got %d", deal.Unixfs0.Size, len(data)))
-			return
-		}*/
+		log.Infof("sending block for a deal: %s", nd.Cid())
 
-		block := Block{
-			Prefix: nd.Cid().Prefix().Bytes(),
-			Data:   nd.RawData(),
+		if len(data) > 0 && ref.start != hnd.at {
+			return xerrors.Errorf("file block at wrong offset: want %d, got %d", hnd.at, ref.start)
 		}
 
-		if err := cborrpc.WriteCborRPC(hnd.stream, block); err != nil {
+		if err := hnd.sendBlock(ctx, nd); err != nil {
 			return err
 		}
 
 		if len(data) > 0 { // don't count internal nodes
 			hnd.at += uint64(len(data))
-			i++
 		}
 	}
 
 	return nil
 }
+
+// sendBlock sends a block to the client once it fits within the transfer
+// rate limits
+func (hnd *handlerDeal) sendBlock(ctx context.Context, nd ipld.Node) error {
+	n := uint64(len(nd.RawData()))
+	if err := hnd.m.limiter.wait(ctx, n); err != nil {
+		return err
+	}
+	if err := hnd.limiter.wait(ctx, n); err != nil {
+		return err
+	}
+
+	block := Block{
+		Prefix: nd.Cid().Prefix().Bytes(),
+		Data:   nd.RawData(),
+	}
+	return cborrpc.WriteCborRPC(hnd.stream, block)
+}
@@ -0,0 +1,180 @@
+package retrieval
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// voucherApi adds vouchers the way the payment channel manager does, paying
+// the difference from the best voucher on the lane
+type voucherApi struct {
+	api.FullNode
+
+	lanes map[uint64]types.BigInt
+}
+
+func (va *voucherApi) PaychVoucherAdd(ctx context.Context, ch address.Address, sv *types.SignedVoucher, proof []byte, minDelta types.BigInt) (types.BigInt, error) {
+	prev, ok := va.lanes[sv.Lane]
+	if !ok {
+		prev = types.NewInt(0)
+	}
+
+	delta := types.BigSub(sv.Amount, prev)
+	if delta.LessThan(minDelta) {
+		return types.EmptyInt, xerrors.Errorf("voucher pays %s, expected at least %s", delta, minDelta)
+	}
+
+	va.lanes[sv.Lane] = sv.Amount
+	return delta, nil
+}
+
+func testMiner() *Miner {
+	return &Miner{
+		full: &voucherApi{lanes: map[uint64]types.BigInt{}},
+
+		pricePerByte:    types.NewInt(2),
+		unsealPrice:     types.NewInt(1000),
+		paymentInterval: chunk,
+
+		unsealPaid:   map[unsealPayment]bool{},
+		unsealPieces: map[string]map[unsealPayment]struct{}{},
+	}
+}
+
+func testHandlerDeal() *handlerDeal {
+	return &handlerDeal{m: testMiner()}
+}
+
+func payment(lane uint64, amount uint64) DealProposal {
+	return DealProposal{
+		Payment: api.PaymentInfo{Vouchers: []*types.SignedVoucher{{Lane: lane, Amount: types.NewInt(amount)}}},
+	}
+}
+
+// paymentFrom returns a payment from the channel for data under root
+func paymentFrom(ch address.Address, root cid.Cid, lane uint64, amount uint64) DealProposal {
+	deal := payment(lane, amount)
+	deal.Payment.Channel = ch
+	deal.Ref = root
+	return deal
+}
+
+func TestUnsealPayment(t *testing.T) {
+	ctx := context.Background()
+
+	// data payments alone don't pay for unsealing
+	hnd := testHandlerDeal()
+	paid, err := hnd.processPayment(ctx, payment(0, 200), 100)
+	require.NoError(t, err)
+	require.Equal(t, types.NewInt(200), paid)
+	require.Error(t, hnd.approveUnseal("piece"))
+
+	// until a payment covers the unseal price on top of the data
+	_, err = hnd.processPayment(ctx, payment(0, 1399), 100)
+	require.NoError(t, err)
+	require.Error(t, hnd.approveUnseal("piece"))
+	_, err = hnd.processPayment(ctx, payment(0, 2599), 100)
+	require.NoError(t, err)
+	require.NoError(t, hnd.approveUnseal("piece"))
+
+	// the unseal price is usually paid with the first payment
+	hnd = testHandlerDeal()
+	paid, err = hnd.processPayment(ctx, payment(0, 1200), 100)
+	require.NoError(t, err)
+	require.Equal(t, types.NewInt(1200), paid)
+	require.NoError(t, hnd.approveUnseal("piece"))
+
+	_, err = hnd.processPayment(ctx, payment(0, 1400), 100)
+	require.NoError(t, err)
+	require.NoError(t, hnd.approveUnseal("piece"))
+
+	// payments below the price per byte are rejected
+	_, err = hnd.processPayment(ctx, payment(0, 1450), 100)
+	require.Error(t, err)
+
+	_, err = hnd.processPayment(ctx, DealProposal{}, 100)
+	require.Error(t, err)
+}
+
+func TestUnsealPaidOnce(t *testing.T) {
+	ctx := context.Background()
+	m := testMiner()
+
+	ch1, err := address.NewIDAddress(100)
+	require.NoError(t, err)
+	ch2, err := address.NewIDAddress(101)
+	require.NoError(t, err)
+	root := testProposal(t).Ref
+	other, err := cid.V1Builder{Codec: cid.Raw, MhType: mh.SHA2_256}.Sum([]byte("other"))
+	require.NoError(t, err)
+
+	// the first stream pays the unseal price
+	first := &handlerDeal{m: m}
+	_, err = first.processPayment(ctx, paymentFrom(ch1, root, 0, 1200), 100)
+	require.NoError(t, err)
+	require.NoError(t, first.approveUnseal("piece"))
+
+	// other streams from the same channel don't pay it again
+	second := &handlerDeal{m: m}
+	_, err = second.processPayment(ctx, paymentFrom(ch1, root, 1, 200), 100)
+	require.NoError(t, err)
+	require.NoError(t, second.approveUnseal("piece"))
+
+	// unless they retrieve other data
+	third := &handlerDeal{m: m}
+	_, err = third.processPayment(ctx, paymentFrom(ch1, other, 2, 200), 100)
+	require.NoError(t, err)
+	require.Error(t, third.approveUnseal("piece"))
+
+	// other clients have to pay it
+	fourth := &handlerDeal{m: m}
+	_, err = fourth.processPayment(ctx, paymentFrom(ch2, root, 3, 200), 100)
+	require.NoError(t, err)
+	require.Error(t, fourth.approveUnseal("piece"))
+}
+
+func TestUnsealPaymentDropped(t *testing.T) {
+	ctx := context.Background()
+	m := testMiner()
+
+	ch, err := address.NewIDAddress(100)
+	require.NoError(t, err)
+	root := testProposal(t).Ref
+
+	first := &handlerDeal{m: m}
+	_, err = first.processPayment(ctx, paymentFrom(ch, root, 0, 1200), 100)
+	require.NoError(t, err)
+	require.NoError(t, first.approveUnseal("piece"))
+
+	// payments used for unsealing are kept after the stream ends
+	first.dropUnsealPayments()
+	second := &handlerDeal{m: m}
+	_, err = second.processPayment(ctx, paymentFrom(ch, root, 1, 200), 100)
+	require.NoError(t, err)
+	require.NoError(t, second.approveUnseal("piece"))
+
+	// until the piece is removed from the unsealed cache
+	m.unsealRemoved("other")
+	require.NoError(t, second.approveUnseal("piece"))
+	m.unsealRemoved("piece")
+	require.Error(t, second.approveUnseal("piece"))
+	require.Empty(t, m.unsealPaid)
+	require.Empty(t, m.unsealPieces)
+
+	// unused payments are dropped when the stream ends
+	third := &handlerDeal{m: m}
+	_, err = third.processPayment(ctx, paymentFrom(ch, root, 2, 1200), 100)
+	require.NoError(t, err)
+	third.dropUnsealPayments()
+	require.Empty(t, m.unsealPaid)
+	require.Error(t, third.approveUnseal("piece"))
+}
@@ -0,0 +1,246 @@
+package retrieval
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// Ranges of unixfs files can be retrieved from several miners at once. The
+// range is split into segments, each retrieved on a separate deal stream,
+// paid from the payment channel with the miner serving it. Miners which fail
+// or stall are dropped, and the part of their segment which wasn't received
+// yet is retrieved from another miner.
+
+// segmentsPerSource is how many segments there are for each miner retrieving
+// in parallel, so faster miners can retrieve more of the data
+const segmentsPerSource = 4
+
+// StallTimeout is how long a miner can go without sending verified data
+// before the retrieval from it is abandoned
+var StallTimeout = time.Minute
+
+// Source is a miner to retrieve data from, with the terms it offered
+type Source struct {
+	Terms Terms
+
+	Miner     peer.ID
+	MinerAddr address.Address
+}
+
+type segment struct {
+	offset uint64
+	length uint64
+}
+
+// segmentQueue hands out segments left to retrieve to miners
+type segmentQueue struct {
+	lk   sync.Mutex
+	cond *sync.Cond
+
+	pending []segment
+	running int
+
+	sources []Source
+	next    int // next source to start when one fails
+	errs    []error
+}
+
+// take returns the next segment to retrieve, waiting for segments of running
+// retrievals to be requeued. It returns false when there's nothing left
+func (q *segmentQueue) take() (segment, bool) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	for len(q.pending) == 0 {
+		if q.running == 0 {
+			return segment{}, false
+		}
+		q.cond.Wait()
+	}
+
+	seg := q.pending[0]
+	q.pending = q.pending[1:]
+	q.running++
+	return seg, true
+}
+
+// done records the end of a segment retrieval, rest is the part of the
+// segment which wasn't retrieved
+func (q *segmentQueue) done(rest segment) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	q.running--
+	if rest.length > 0 {
+		q.pending = append(q.pending, rest)
+	}
+	q.cond.Broadcast()
+}
+
+// fail records the error of a dropped source, and returns the source to
+// fall back to, if there are any left
+func (q *segmentQueue) fail(err error) (Source, bool) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	q.errs = append(q.errs, err)
+	if q.next >= len(q.sources) {
+		return Source{}, false
+	}
+
+	src := q.sources[q.next]
+	q.next++
+	return src, true
+}
+
+// splitRange splits a file range into n segments. Segments between the first
+// and the last start on chunk boundaries, so no data is retrieved twice
+func splitRange(offset, length uint64, n int) []segment {
+	from := offset - offset%build.UnixfsChunkSize
+	end := offset + length
+
+	chunks := (end - from + build.UnixfsChunkSize - 1) / build.UnixfsChunkSize
+	perSegment := (chunks + uint64(n) - 1) / uint64(n)
+	if perSegment == 0 {
+		perSegment = 1
+	}
+
+	var out []segment
+	for at := from; at < end; at += perSegment * build.UnixfsChunkSize {
+		start, stop := at, at+perSegment*build.UnixfsChunkSize
+		if start < offset {
+			start = offset
+		}
+		if stop > end {
+			stop = end
+		}
+		out = append(out, segment{offset: start, length: stop - start})
+	}
+	return out
+}
+
+// RetrieveUnixfsParallel retrieves length bytes of a unixfs file starting at
+// offset from up to parallel miners at once, writing data at its offset in
+// the range to out. Sources are used in order, the ones past the first
+// parallel are only used when other miners fail
+func (c *Client) RetrieveUnixfsParallel(ctx context.Context, root cid.Cid, size uint64, offset, length uint64, sources []Source, parallel int, client address.Address, out io.WriterAt) error {
+	if offset+length > size {
+		return xerrors.Errorf("range %d+%d is past the end of the file (%d bytes)", offset, length, size)
+	}
+	if len(sources) == 0 {
+		return xerrors.New("no miners to retrieve from")
+	}
+	if parallel <= 0 || parallel > len(sources) {
+		parallel = len(sources)
+	}
+
+	q := &segmentQueue{
+		pending: splitRange(offset, length, parallel*segmentsPerSource),
+		sources: sources,
+		next:    parallel,
+	}
+	q.cond = sync.NewCond(&q.lk)
+
+	var wg sync.WaitGroup
+	var run func(src Source)
+	run = func(src Source) {
+		defer wg.Done()
+
+		for {
+			seg, ok := q.take()
+			if !ok {
+				return
+			}
+
+			written, err := c.retrieveSegment(ctx, root, size, seg, src, client, offset, out)
+			q.done(segment{offset: seg.offset + written, length: seg.length - written})
+
+			// the unseal price was paid with the first segment, the miner
+			// knows it was paid from our payment channel
+			src.Terms.UnsealPrice = types.EmptyInt
+
+			if err == nil {
+				continue
+			}
+
+			log.Warnf("retrieval from miner %s failed, %d bytes left in segment @%d: %s", src.MinerAddr, seg.length-written, seg.offset+written, err)
+
+			next, ok := q.fail(xerrors.Errorf("miner %s: %w", src.MinerAddr, err))
+			if ok && ctx.Err() == nil {
+				wg.Add(1)
+				go run(next)
+			}
+			return
+		}
+	}
+
+	wg.Add(parallel)
+	for _, src := range sources[:parallel] {
+		go run(src)
+	}
+	wg.Wait()
+
+	if len(q.pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return xerrors.Errorf("all miners failed, last error: %w", q.errs[len(q.errs)-1])
+	}
+
+	log.Info("RETRIEVE SUCCESSFUL")
+	return nil
+}
+
+// retrieveSegment retrieves a segment of a range starting at rangeStart. It
+// returns the number of bytes of the segment written to out
+func (c *Client) retrieveSegment(ctx context.Context, root cid.Cid, size uint64, seg segment, src Source, client address.Address, rangeStart uint64, out io.WriterAt) (uint64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stalled := false
+	var lk sync.Mutex
+	watchdog := time.AfterFunc(StallTimeout, func() {
+		lk.Lock()
+		stalled = true
+		lk.Unlock()
+		cancel()
+	})
+	defer watchdog.Stop()
+
+	var written uint64
+	w := &offsetWriter{w: out, at: int64(seg.offset - rangeStart)}
+	err := c.RetrieveUnixfsRange(ctx, root, size, src.Terms, seg.offset, seg.length, src.Miner, client, src.MinerAddr, w, func(n uint64) error {
+		written = n
+		watchdog.Reset(StallTimeout)
+		return nil
+	})
+
+	lk.Lock()
+	defer lk.Unlock()
+	if err != nil && stalled {
+		err = xerrors.Errorf("no data for %s: %w", StallTimeout, err)
+	}
+	return written, err
+}
+
+// offsetWriter writes sequentially to a WriterAt, starting at an offset
+type offsetWriter struct {
+	w  io.WriterAt
+	at int64
+}
+
+func (ow *offsetWriter) Write(p []byte) (int, error) {
+	n, err := ow.w.WriteAt(p, ow.at)
+	ow.at += int64(n)
+	return n, err
+}
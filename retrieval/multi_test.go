@@ -0,0 +1,106 @@
+package retrieval
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestSplitRange(t *testing.T) {
+	check := func(offset, length uint64, n int) []segment {
+		segs := splitRange(offset, length, n)
+		require.True(t, len(segs) <= n)
+
+		// segments cover the range without gaps or overlaps
+		at := offset
+		for i, seg := range segs {
+			require.Equal(t, at, seg.offset)
+			require.NotZero(t, seg.length)
+			if i > 0 {
+				require.Zero(t, seg.offset%chunk, "segment %d isn't aligned", i)
+			}
+			at += seg.length
+		}
+		require.Equal(t, offset+length, at)
+		return segs
+	}
+
+	require.Equal(t, []segment{
+		{offset: 0, length: chunk},
+		{offset: chunk, length: chunk},
+		{offset: 2 * chunk, length: chunk},
+		{offset: 3 * chunk, length: chunk},
+	}, check(0, 4*chunk, 4))
+
+	require.Equal(t, []segment{
+		{offset: 100, length: 3*chunk - 100},
+		{offset: 3 * chunk, length: chunk + 100},
+	}, check(100, 4*chunk, 2))
+
+	// more segments than chunks
+	require.Len(t, check(chunk+10, chunk/2, 8), 1)
+	require.Len(t, check(chunk-10, 20, 8), 2)
+
+	check(12345, 10*chunk+678, 16)
+	check(3*chunk, 7*chunk, 3)
+}
+
+func TestSegmentQueue(t *testing.T) {
+	q := &segmentQueue{
+		pending: []segment{{offset: 0, length: chunk}, {offset: chunk, length: chunk}},
+		sources: []Source{{Terms: Terms{Total: types.NewInt(1)}}, {Terms: Terms{Total: types.NewInt(2)}}, {Terms: Terms{Total: types.NewInt(3)}}},
+		next:    2,
+	}
+	q.cond = sync.NewCond(&q.lk)
+
+	first, ok := q.take()
+	require.True(t, ok)
+	require.Equal(t, uint64(0), first.offset)
+	second, ok := q.take()
+	require.True(t, ok)
+	require.Equal(t, chunk, second.offset)
+
+	// nothing is pending, but the running retrievals can still requeue
+	// segments
+	taken := make(chan segment)
+	go func() {
+		seg, ok := q.take()
+		require.True(t, ok)
+		taken <- seg
+	}()
+
+	select {
+	case <-taken:
+		t.Fatal("segment taken while none were pending")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// the first retrieval failed half way, the rest is retrieved by another
+	// miner
+	q.done(segment{offset: chunk / 2, length: chunk / 2})
+	require.Equal(t, segment{offset: chunk / 2, length: chunk / 2}, <-taken)
+
+	src, ok := q.fail(xerrors.New("first miner failed"))
+	require.True(t, ok)
+	require.Equal(t, types.NewInt(3), src.Terms.Total)
+
+	_, ok = q.fail(xerrors.New("second miner failed"))
+	require.False(t, ok, "no more sources to fall back to")
+	require.Len(t, q.errs, 2)
+
+	// waiting retrievals end when all segments are done
+	ended := make(chan bool)
+	go func() {
+		_, ok := q.take()
+		ended <- ok
+	}()
+
+	q.done(segment{})
+	q.done(segment{})
+	require.False(t, <-ended)
+}
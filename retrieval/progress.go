@@ -0,0 +1,75 @@
+package retrieval
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+)
+
+// Progress of a retrieval is stored next to the output file while the
+// retrieval is in progress. Only data which was verified is counted, so an
+// interrupted retrieval can be resumed after truncating the output file to
+// Written bytes.
+type Progress struct {
+	Root cid.Cid
+
+	// Offset and Length of the retrieved range of the file
+	Offset uint64
+	Length uint64
+
+	// Written is the number of bytes of the range written to the output file
+	Written uint64
+}
+
+func progressPath(out string) string {
+	return out + ".progress"
+}
+
+// LoadProgress reads progress of a retrieval writing to the out file. It
+// returns nil if there is no retrieval in progress
+func LoadProgress(out string) (*Progress, error) {
+	b, err := ioutil.ReadFile(progressPath(out))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var p Progress
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, xerrors.Errorf("decoding retrieval progress: %w", err)
+	}
+	return &p, nil
+}
+
+// Resumes returns true if the progress is of a retrieval of the given range
+func (p *Progress) Resumes(root cid.Cid, offset, length uint64) bool {
+	return p.Root.Equals(root) && p.Offset == offset && p.Length == length && p.Written <= length
+}
+
+// Save atomically replaces the progress file of the out file
+func (p *Progress) Save(out string) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	tmp := progressPath(out) + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, progressPath(out))
+}
+
+// RemoveProgress removes the progress file of a finished retrieval
+func RemoveProgress(out string) error {
+	err := os.Remove(progressPath(out))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,332 @@
+package retrieval
+
+import (
+	"context"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/cborrpc"
+	"github.com/filecoin-project/lotus/storage/sectorblocks"
+)
+
+// Sub-DAGs selected by a path and recursion depth are sent block by block,
+// path blocks first. Window sizes are counted in bytes of sent blocks.
+//
+// > DealProposal{Selector: {Path, Depth, Offset, Size}, Payment}
+// < Resp{Accept}
+// < ..Blocks
+// > DealProposal(...)
+// < ...
+
+type selectorRef struct {
+	c cid.Cid
+
+	path  []string // rest of the path to resolve, starting at this block
+	depth int64    // links followed from the selected block
+}
+
+// selectorWalker walks blocks selected by a path and recursion depth in the
+// order they are sent. Repeated blocks are only visited once when all links
+// are followed, with limited depth they are visited again, so both sides
+// agree on which blocks are in the selection
+type selectorWalker struct {
+	depth int64
+	stack []selectorRef
+	seen  *cid.Set
+}
+
+func newSelectorWalker(root cid.Cid, path []string, depth int64) *selectorWalker {
+	w := &selectorWalker{
+		depth: depth,
+		stack: []selectorRef{{c: root, path: path}},
+	}
+	if depth < 0 {
+		w.seen = cid.NewSet()
+	}
+	return w
+}
+
+// next returns the next block to visit, or io.EOF when the traversal ended.
+// The block has to be passed to visit before calling next again
+func (w *selectorWalker) next() (selectorRef, error) {
+	for len(w.stack) > 0 {
+		ref := w.stack[len(w.stack)-1]
+		w.stack = w.stack[:len(w.stack)-1]
+
+		if w.seen != nil && len(ref.path) == 0 && !w.seen.Visit(ref.c) {
+			continue
+		}
+		return ref, nil
+	}
+
+	return selectorRef{}, io.EOF
+}
+
+// done returns true when there are no more blocks to visit
+func (w *selectorWalker) done() bool {
+	for len(w.stack) > 0 {
+		ref := w.stack[len(w.stack)-1]
+		if w.seen == nil || len(ref.path) > 0 || !w.seen.Has(ref.c) {
+			return false
+		}
+		w.stack = w.stack[:len(w.stack)-1]
+	}
+	return true
+}
+
+// visit queues blocks linked from the node which are part of the selection
+func (w *selectorWalker) visit(nd ipld.Node, ref selectorRef) error {
+	if len(ref.path) > 0 {
+		v, rest, err := nd.Resolve(ref.path)
+		if err != nil {
+			return xerrors.Errorf("resolving path %v in block %s: %w", ref.path, ref.c, err)
+		}
+
+		lnk, ok := v.(*ipld.Link)
+		if !ok {
+			return xerrors.Errorf("path %v ends inside block %s, not at a link", ref.path, ref.c)
+		}
+
+		w.stack = append(w.stack, selectorRef{c: lnk.Cid, path: rest})
+		return nil
+	}
+
+	if w.depth >= 0 && ref.depth >= w.depth {
+		return nil
+	}
+
+	links := sectorblocks.OrderedLinks(nd)
+	for i := len(links) - 1; i >= 0; i-- {
+		w.stack = append(w.stack, selectorRef{c: links[i].Cid, depth: ref.depth + 1})
+	}
+	return nil
+}
+
+// SelectorVerifier checks that blocks selected by a path and recursion depth
+// are received in traversal order, and writes them to a CAR file
+type SelectorVerifier struct {
+	root   cid.Cid
+	walker *selectorWalker
+
+	headerWritten bool
+}
+
+func NewSelectorVerifier(root cid.Cid, path []string, depth int64) *SelectorVerifier {
+	return &SelectorVerifier{
+		root:   root,
+		walker: newSelectorWalker(root, path, depth),
+	}
+}
+
+// Verify checks the next selected block and writes it to out. It returns the
+// number of bytes counted towards the retrieval size
+func (v *SelectorVerifier) Verify(ctx context.Context, block Block, out io.Writer) (uint64, error) {
+	ref, err := v.walker.next()
+	if err == io.EOF {
+		return 0, xerrors.New("selector verifier: unexpected block after the traversal ended")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	blk, err := checkBlock(ref.c, block)
+	if err != nil {
+		return 0, xerrors.Errorf("selector verifier: %w", err)
+	}
+
+	nd, err := ipld.Decode(blk)
+	if err != nil {
+		return 0, xerrors.Errorf("selector verifier: decoding block %s: %w", blk.Cid(), err)
+	}
+	if err := v.walker.visit(nd, ref); err != nil {
+		return 0, xerrors.Errorf("selector verifier: %w", err)
+	}
+
+	if !v.headerWritten {
+		if err := writeCarHeader(out, v.root); err != nil {
+			return 0, err
+		}
+		v.headerWritten = true
+	}
+
+	if _, err := out.Write(sectorblocks.CarSection(nd)); err != nil {
+		return 0, err
+	}
+	if _, err := out.Write(nd.RawData()); err != nil {
+		return 0, err
+	}
+
+	return uint64(len(nd.RawData())), nil
+}
+
+// Done returns true when all selected blocks were received
+func (v *SelectorVerifier) Done() bool {
+	return v.walker.done()
+}
+
+func (hnd *handlerDeal) handleSelector(ctx context.Context, deal DealProposal) error {
+	offer := deal.Params.Selector
+
+	if hnd.open != deal.Ref || hnd.sel == nil || !hnd.sameSelector(offer) || hnd.at != offer.Offset {
+		log.Infof("opening selector for sending (open '%s') (@%d, want %d)", hnd.open, hnd.at, offer.Offset)
+		if offer.Offset != 0 {
+			// TODO: replay the traversal up to the offset
+			return xerrors.New("sending selected blocks from nonzero offset not supported yet")
+		}
+
+		hnd.ufs = nil
+		hnd.dag = nil
+		hnd.ds = hnd.sealedDAG()
+		hnd.sel = newSelectorWalker(deal.Ref, offer.Path, offer.Depth)
+		hnd.selPath = offer.Path
+		hnd.selDepth = offer.Depth
+		hnd.open = deal.Ref
+		hnd.at = 0
+	}
+
+	resp := DealResponse{
+		Status: Accepted,
+	}
+	if err := cborrpc.WriteCborRPC(hnd.stream, resp); err != nil {
+		log.Errorf("Retrieval query: Write Accepted resp: %s", err)
+		return err
+	}
+
+	end := offer.Offset + offer.Size
+	for hnd.at < end {
+		ref, err := hnd.sel.next()
+		if err == io.EOF {
+			// the traversal ended, the client knows it too
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		nd, err := hnd.ds.Get(ctx, ref.c)
+		if err != nil {
+			return xerrors.Errorf("getting selected block %s: %w", ref.c, err)
+		}
+		if err := hnd.sel.visit(nd, ref); err != nil {
+			return err
+		}
+
+		if err := hnd.sendBlock(ctx, nd); err != nil {
+			return err
+		}
+
+		hnd.at += uint64(len(nd.RawData()))
+	}
+
+	return nil
+}
+
+func (hnd *handlerDeal) sameSelector(offer *SelectorOffer) bool {
+	if offer.Depth != hnd.selDepth || len(offer.Path) != len(hnd.selPath) {
+		return false
+	}
+	for i := range offer.Path {
+		if offer.Path[i] != hnd.selPath[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// RetrieveSelector retrieves blocks of a DAG selected by a path from the root
+// and a recursion depth, and writes them to out as a CAR file with the root.
+// Retrieved bytes are paid at the rate of terms.Total for size bytes
+func (c *Client) RetrieveSelector(ctx context.Context, root cid.Cid, path []string, depth int64, size uint64, terms Terms, miner peer.ID, client, minerAddr address.Address, out io.Writer) (err error) {
+	verifier := NewSelectorVerifier(root, path, depth)
+
+	cst, err := c.openStream(ctx, root, size, terms, terms.Total, miner, client, minerAddr)
+	if err != nil {
+		return err
+	}
+	defer cst.close()
+	defer cst.resetOnCancel(ctx)()
+	defer func() {
+		cst.record.finish(err)
+	}()
+
+	for !verifier.Done() {
+		log.Infof("Retrieve %dB @%d", cst.windowSize, cst.offset)
+
+		fetched, err := cst.doOneSelectorExchange(ctx, path, depth, cst.windowSize, verifier, out)
+		if err != nil {
+			return xerrors.Errorf("retrieval exchange: %w", err)
+		}
+
+		cst.offset += fetched
+		cst.record.update(fetched, types.EmptyInt)
+	}
+
+	log.Info("RETRIEVE SUCCESSFUL")
+	return nil
+}
+
+// selectorPayment returns how much to pay for a window of toFetch bytes, at
+// the rate of total for size bytes. Windows are paid upfront and the
+// traversal can end in the middle of one, so payments stop at total
+func selectorPayment(total types.BigInt, paid types.BigInt, toFetch uint64, size types.BigInt) types.BigInt {
+	amount := types.BigDiv(types.BigMul(total, types.NewInt(toFetch)), size)
+
+	left := types.BigSub(total, paid)
+	if left.Sign() <= 0 {
+		return types.NewInt(0)
+	}
+	if amount.GreaterThan(left) {
+		return left
+	}
+	return amount
+}
+
+func (cst *clientStream) doOneSelectorExchange(ctx context.Context, path []string, depth int64, toFetch uint64, verifier *SelectorVerifier, out io.Writer) (uint64, error) {
+	payAmount := selectorPayment(cst.total, cst.paidData, toFetch, cst.size)
+
+	payment, err := cst.setupPayment(ctx, payAmount, toFetch)
+	if err != nil {
+		return 0, xerrors.Errorf("setting up retrieval payment: %w", err)
+	}
+
+	deal := DealProposal{
+		Payment: payment,
+		Ref:     cst.root,
+		Params: RetParams{
+			Selector: &SelectorOffer{
+				Path:   path,
+				Depth:  depth,
+				Offset: cst.offset,
+				Size:   toFetch,
+			},
+		},
+	}
+
+	if err := cst.propose(deal); err != nil {
+		return 0, err
+	}
+
+	var fetched uint64
+	for fetched < toFetch && !verifier.Done() {
+		block, err := readBlock(cst.stream)
+		if err != nil {
+			return 0, xerrors.Errorf("reading fetchBlock response: %w", err)
+		}
+
+		n, err := verifier.Verify(ctx, block, out)
+		if err != nil {
+			log.Warnf("block verify failed: %s", err)
+			return 0, err
+		}
+
+		fetched += n
+	}
+
+	return fetched, nil
+}
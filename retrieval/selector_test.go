@@ -0,0 +1,158 @@
+package retrieval
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	ipld "github.com/ipfs/go-ipld-format"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type selectorDag struct {
+	dag ipld.DAGService
+
+	root, mid, leaf1, leaf2 cid.Cid
+}
+
+// newSelectorDag builds a DAG where mid links to leaf1 twice:
+//
+//	root {sub: {mid}, other: leaf2}
+//	mid  {x: leaf1, y: leaf2, z: leaf1}
+func newSelectorDag(t *testing.T) *selectorDag {
+	sd := &selectorDag{dag: testDag()}
+
+	add := func(obj interface{}) cid.Cid {
+		nd, err := cbor.WrapObject(obj, mh.SHA2_256, -1)
+		require.NoError(t, err)
+		require.NoError(t, sd.dag.Add(context.Background(), nd))
+		return nd.Cid()
+	}
+
+	sd.leaf1 = add(map[string]interface{}{"v": 1})
+	sd.leaf2 = add(map[string]interface{}{"v": 2})
+	sd.mid = add(map[string]interface{}{"x": sd.leaf1, "y": sd.leaf2, "z": sd.leaf1})
+	sd.root = add(map[string]interface{}{
+		"sub":   map[string]interface{}{"mid": sd.mid},
+		"other": sd.leaf2,
+	})
+
+	return sd
+}
+
+// walk returns the blocks visited by the walker
+func (sd *selectorDag) walk(t *testing.T, w *selectorWalker) ([]cid.Cid, error) {
+	var out []cid.Cid
+	for {
+		ref, err := w.next()
+		if err == io.EOF {
+			return out, nil
+		}
+		require.NoError(t, err)
+
+		nd, err := sd.dag.Get(context.Background(), ref.c)
+		require.NoError(t, err)
+		if err := w.visit(nd, ref); err != nil {
+			return out, err
+		}
+		out = append(out, ref.c)
+	}
+}
+
+func TestSelectorWalker(t *testing.T) {
+	sd := newSelectorDag(t)
+
+	// all links are followed, repeated blocks are visited once
+	visited, err := sd.walk(t, newSelectorWalker(sd.root, []string{"sub", "mid"}, -1))
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{sd.root, sd.mid, sd.leaf1, sd.leaf2}, visited)
+
+	// path blocks are visited, links of the selected block aren't followed
+	visited, err = sd.walk(t, newSelectorWalker(sd.root, []string{"sub", "mid"}, 0))
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{sd.root, sd.mid}, visited)
+
+	// with limited depth repeated blocks are visited again
+	visited, err = sd.walk(t, newSelectorWalker(sd.root, []string{"sub", "mid"}, 1))
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{sd.root, sd.mid, sd.leaf1, sd.leaf2, sd.leaf1}, visited)
+
+	// the whole DAG, links are walked in path order
+	visited, err = sd.walk(t, newSelectorWalker(sd.root, nil, -1))
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{sd.root, sd.leaf2, sd.mid, sd.leaf1}, visited)
+
+	// paths have to end at a link
+	_, err = sd.walk(t, newSelectorWalker(sd.root, []string{"sub"}, -1))
+	require.Error(t, err)
+	_, err = sd.walk(t, newSelectorWalker(sd.root, []string{"missing"}, -1))
+	require.Error(t, err)
+}
+
+func TestSelectorWalkerDone(t *testing.T) {
+	sd := newSelectorDag(t)
+	w := newSelectorWalker(sd.root, []string{"sub", "mid"}, -1)
+
+	for _, c := range []cid.Cid{sd.root, sd.mid, sd.leaf1} {
+		require.False(t, w.done())
+
+		ref, err := w.next()
+		require.NoError(t, err)
+		require.Equal(t, c, ref.c)
+
+		nd, err := sd.dag.Get(context.Background(), ref.c)
+		require.NoError(t, err)
+		require.NoError(t, w.visit(nd, ref))
+	}
+	require.False(t, w.done())
+
+	ref, err := w.next()
+	require.NoError(t, err)
+	require.Equal(t, sd.leaf2, ref.c)
+
+	// only the repeated link to leaf1 is left
+	require.True(t, w.done())
+}
+
+func TestSelectorVerifier(t *testing.T) {
+	sd := newSelectorDag(t)
+	v := NewSelectorVerifier(sd.root, []string{"sub", "mid"}, -1)
+
+	var out bytes.Buffer
+	var size uint64
+	for _, c := range []cid.Cid{sd.root, sd.mid, sd.leaf1, sd.leaf2} {
+		require.False(t, v.Done())
+
+		nd, err := sd.dag.Get(context.Background(), c)
+		require.NoError(t, err)
+
+		n, err := v.Verify(context.Background(), Block{Prefix: c.Prefix().Bytes(), Data: nd.RawData()}, &out)
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(nd.RawData())), n)
+		size += n
+	}
+	require.True(t, v.Done())
+	require.True(t, uint64(out.Len()) > size, "CAR output has the header and sections")
+
+	// blocks out of order are rejected
+	v = NewSelectorVerifier(sd.root, []string{"sub", "mid"}, -1)
+	nd, err := sd.dag.Get(context.Background(), sd.mid)
+	require.NoError(t, err)
+	_, err = v.Verify(context.Background(), Block{Prefix: sd.mid.Prefix().Bytes(), Data: nd.RawData()}, &out)
+	require.Error(t, err)
+}
+
+func TestSelectorPayment(t *testing.T) {
+	total := types.NewInt(100)
+	size := types.NewInt(1000)
+
+	require.Equal(t, types.NewInt(30), selectorPayment(total, types.NewInt(0), 300, size))
+	require.Equal(t, types.NewInt(10), selectorPayment(total, types.NewInt(90), 300, size))
+	require.Equal(t, types.NewInt(0), selectorPayment(total, types.NewInt(100), 300, size))
+}
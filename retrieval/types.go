@@ -5,6 +5,7 @@ import (
 	"github.com/ipfs/go-cid"
 	cbor "github.com/ipfs/go-ipld-cbor"
 
+	"github.com/filecoin-project/lotus/chain/address"
 	"github.com/filecoin-project/lotus/chain/types"
 )
 
@@ -31,6 +32,8 @@ func init() {
 	cbor.RegisterCborType(Query{})
 	cbor.RegisterCborType(QueryResponse{})
 	cbor.RegisterCborType(Unixfs0Offer{})
+	cbor.RegisterCborType(DAGOffer{})
+	cbor.RegisterCborType(SelectorOffer{})
 
 	cbor.RegisterCborType(DealProposal{})
 	cbor.RegisterCborType(DealResponse{})
@@ -44,12 +47,19 @@ type Query struct {
 
 type QueryResponse struct {
 	Status QueryResponseStatus
+	Miner  address.Address
 
-	Size uint64 // TODO: spec
-	// TODO: unseal price (+spec)
+	Size          uint64 // TODO: spec
+	Serialization api.SerializationMode
 	// TODO: sectors to unseal
 	// TODO: address to send money for the deal?
 	MinPrice types.BigInt
+
+	// UnsealPrice has to be paid with the first payment on a deal stream, it
+	// is zero when the data is already unsealed
+	UnsealPrice types.BigInt
+	// PaymentInterval is the most bytes the miner sends per deal proposal
+	PaymentInterval uint64
 }
 
 type Unixfs0Offer struct {
@@ -57,8 +67,35 @@ type Unixfs0Offer struct {
 	Size   uint64
 }
 
+// DAGOffer requests blocks of a DAG stored in raw or IPLD mode, in
+// depth-first order. Offset and Size count bytes of the retrieved data: raw
+// leaves in raw mode, all blocks in IPLD mode. Blocks are always sent whole,
+// so the last block sent may go past Offset+Size
+type DAGOffer struct {
+	Mode   api.SerializationMode
+	Offset uint64
+	Size   uint64
+}
+
+// SelectorOffer requests blocks of an arbitrary IPLD DAG selected by a path
+// from the deal root, and a recursion depth from the block the path ends
+// at. Blocks on the path are sent first, followed by the selected sub-DAG in
+// depth-first order. Offset and Size count bytes of all blocks sent, blocks
+// are always sent whole. The miner stops sending when the traversal ends
+type SelectorOffer struct {
+	Path []string
+	// Depth is the number of links followed from the selected block, -1
+	// follows all links
+	Depth int64
+
+	Offset uint64
+	Size   uint64
+}
+
 type RetParams struct {
-	Unixfs0 *Unixfs0Offer
+	Unixfs0  *Unixfs0Offer
+	DAG      *DAGOffer
+	Selector *SelectorOffer
 }
 
 type DealProposal struct {
@@ -68,6 +105,16 @@ type DealProposal struct {
 	Params RetParams
 }
 
+// Terms of a retrieval offered by the miner in the query response
+type Terms struct {
+	// Total is the price of all data in the piece, retrieved bytes are paid
+	// at the same rate
+	Total types.BigInt
+
+	UnsealPrice     types.BigInt
+	PaymentInterval uint64
+}
+
 type DealResponse struct {
 	Status  int
 	Message string
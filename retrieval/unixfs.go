@@ -0,0 +1,192 @@
+package retrieval
+
+import (
+	"context"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	pb "github.com/ipfs/go-unixfs/pb"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/build"
+)
+
+// Unixfs files are sent in depth-first order, starting from the root.
+// Subtrees ending before the offset of the first deal on a stream are
+// skipped, so the intermediate nodes sent for a ranged retrieval form a
+// merkle proof of the first leaf sent.
+
+type unixfsRef struct {
+	c     cid.Cid
+	start uint64 // file offset of the first byte of data under the node
+}
+
+// unixfsWalker walks the blocks of a unixfs file in the order they are sent
+type unixfsWalker struct {
+	skip  uint64
+	stack []unixfsRef
+}
+
+func newUnixfsWalker(root cid.Cid, skip uint64) *unixfsWalker {
+	return &unixfsWalker{
+		skip:  skip,
+		stack: []unixfsRef{{c: root}},
+	}
+}
+
+// next returns the next block to visit, or io.EOF when the whole file was
+// visited. The block has to be passed to visit before calling next again
+func (w *unixfsWalker) next() (unixfsRef, error) {
+	if len(w.stack) == 0 {
+		return unixfsRef{}, io.EOF
+	}
+
+	ref := w.stack[len(w.stack)-1]
+	w.stack = w.stack[:len(w.stack)-1]
+	return ref, nil
+}
+
+// visit checks a unixfs file node, queues its children which end after the
+// skipped offset, and returns file data stored in the node. Data is nil for
+// intermediate nodes
+func (w *unixfsWalker) visit(nd ipld.Node, ref unixfsRef) ([]byte, error) {
+	switch nd := nd.(type) {
+	case *merkledag.RawNode:
+		return leafData(nd.RawData(), ref)
+
+	case *merkledag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+		if err != nil {
+			return nil, xerrors.Errorf("decoding unixfs node %s: %w", nd.Cid(), err)
+		}
+		if fsn.Type() != pb.Data_File && fsn.Type() != pb.Data_Raw {
+			return nil, xerrors.Errorf("unixfs node %s isn't a file", nd.Cid())
+		}
+
+		links := nd.Links()
+		if len(links) == 0 {
+			return leafData(fsn.Data(), ref)
+		}
+		if len(fsn.Data()) > 0 {
+			return nil, xerrors.Errorf("internal node %s with data", nd.Cid())
+		}
+		if fsn.NumChildren() != len(links) {
+			return nil, xerrors.Errorf("internal node %s has %d links, but %d block sizes", nd.Cid(), len(links), fsn.NumChildren())
+		}
+
+		var children []unixfsRef
+		at := ref.start
+		for i, l := range links {
+			end := at + fsn.BlockSize(i)
+			if end > w.skip {
+				children = append(children, unixfsRef{c: l.Cid, start: at})
+			}
+			at = end
+		}
+
+		for i := len(children) - 1; i >= 0; i-- {
+			w.stack = append(w.stack, children[i])
+		}
+		return nil, nil
+
+	default:
+		return nil, xerrors.Errorf("unixfs node %s has unknown type", nd.Cid())
+	}
+}
+
+// leafData checks that a leaf is aligned to build.UnixfsChunkSize. Ranged
+// retrievals start on chunk boundaries, which only works when all leaves
+// but the last one are whole chunks
+func leafData(data []byte, ref unixfsRef) ([]byte, error) {
+	if ref.start%build.UnixfsChunkSize != 0 || uint64(len(data)) > build.UnixfsChunkSize {
+		return nil, xerrors.Errorf("unixfs leaf %s at offset %d (%d bytes) isn't aligned to %d byte chunks", ref.c, ref.start, len(data), build.UnixfsChunkSize)
+	}
+	return data, nil
+}
+
+// unixfsFileSize returns the size of the file with the given root node
+func unixfsFileSize(nd ipld.Node) (uint64, error) {
+	switch nd := nd.(type) {
+	case *merkledag.RawNode:
+		return uint64(len(nd.RawData())), nil
+	case *merkledag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(nd.Data())
+		if err != nil {
+			return 0, err
+		}
+		return fsn.FileSize(), nil
+	default:
+		return 0, xerrors.Errorf("unixfs node %s has unknown type", nd.Cid())
+	}
+}
+
+// UnixfsRangeVerifier checks that blocks of a unixfs file are received in
+// the order they are linked in, starting from the root, and writes the
+// requested range of the file data
+type UnixfsRangeVerifier struct {
+	walker *unixfsWalker
+
+	offset uint64
+	end    uint64
+}
+
+// NewUnixfsRangeVerifier creates a verifier for length bytes of a file
+// starting at offset. from is the offset of the first deal on the stream,
+// which must be aligned to chunk boundaries, and at most offset
+func NewUnixfsRangeVerifier(root cid.Cid, from, offset, length uint64) *UnixfsRangeVerifier {
+	return &UnixfsRangeVerifier{
+		walker: newUnixfsWalker(root, from),
+
+		offset: offset,
+		end:    offset + length,
+	}
+}
+
+func (v *UnixfsRangeVerifier) Verify(ctx context.Context, block Block, out io.Writer) (bool, error) {
+	ref, err := v.walker.next()
+	if err == io.EOF {
+		return false, xerrors.New("unixfs verifier: unexpected block after the whole file was received")
+	}
+	if err != nil {
+		return false, err
+	}
+
+	blk, err := checkBlock(ref.c, block)
+	if err != nil {
+		return false, xerrors.Errorf("unixfs verifier: %w", err)
+	}
+
+	nd, err := ipld.Decode(blk)
+	if err != nil {
+		return false, xerrors.Errorf("unixfs verifier: decoding block %s: %w", blk.Cid(), err)
+	}
+
+	data, err := v.walker.visit(nd, ref)
+	if err != nil {
+		return false, xerrors.Errorf("unixfs verifier: %w", err)
+	}
+	if len(data) == 0 {
+		return true, nil
+	}
+
+	// only write the requested part of the leaf
+	start, end := ref.start, ref.start+uint64(len(data))
+	if start < v.offset {
+		start = v.offset
+	}
+	if end > v.end {
+		end = v.end
+	}
+	if start < end {
+		if _, err := out.Write(data[start-ref.start : end-ref.start]); err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+var _ BlockVerifier = &UnixfsRangeVerifier{}
@@ -0,0 +1,203 @@
+package retrieval
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	ihelper "github.com/ipfs/go-unixfs/importer/helpers"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/build"
+)
+
+const chunk = build.UnixfsChunkSize
+
+func testDag() ipld.DAGService {
+	return merkledag.NewDAGService(blockservice.New(blockstore.NewBlockstore(datastore.NewMapDatastore()), nil))
+}
+
+// testFile imports data with two links per node, so that files of a few
+// chunks have intermediate nodes
+func testFile(t *testing.T, dag ipld.DAGService, data []byte, chunkSize int64) cid.Cid {
+	params := ihelper.DagBuilderParams{
+		Maxlinks:  2,
+		RawLeaves: true,
+		Dagserv:   dag,
+	}
+
+	db, err := params.New(chunker.NewSizeSplitter(bytes.NewReader(data), chunkSize))
+	require.NoError(t, err)
+	nd, err := balanced.Layout(db)
+	require.NoError(t, err)
+
+	return nd.Cid()
+}
+
+func testData(size uint64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+// sendBlocks returns the blocks a miner sends for a file starting at from
+func sendBlocks(t *testing.T, dag ipld.DAGService, root cid.Cid, from uint64) []Block {
+	w := newUnixfsWalker(root, from)
+
+	var out []Block
+	for {
+		ref, err := w.next()
+		if err == io.EOF {
+			return out
+		}
+		require.NoError(t, err)
+
+		nd, err := dag.Get(context.Background(), ref.c)
+		require.NoError(t, err)
+		_, err = w.visit(nd, ref)
+		require.NoError(t, err)
+
+		out = append(out, Block{Prefix: nd.Cid().Prefix().Bytes(), Data: nd.RawData()})
+	}
+}
+
+func verifyRange(t *testing.T, blocks []Block, root cid.Cid, offset, length uint64) ([]byte, error) {
+	from := offset - offset%chunk
+	v := NewUnixfsRangeVerifier(root, from, offset, length)
+
+	var out bytes.Buffer
+	for _, b := range blocks {
+		if _, err := v.Verify(context.Background(), b, &out); err != nil {
+			return nil, err
+		}
+	}
+	return out.Bytes(), nil
+}
+
+func TestUnixfsWalkerSkip(t *testing.T) {
+	dag := testDag()
+	data := testData(4 * chunk)
+	root := testFile(t, dag, data, int64(chunk))
+
+	// root, two intermediate nodes, four leaves
+	require.Len(t, sendBlocks(t, dag, root, 0), 7)
+
+	// the first subtree ends before the second chunk ends
+	require.Len(t, sendBlocks(t, dag, root, chunk), 6)
+
+	// the first subtree is skipped
+	require.Len(t, sendBlocks(t, dag, root, 2*chunk), 4)
+	require.Len(t, sendBlocks(t, dag, root, 3*chunk), 3)
+}
+
+func TestUnixfsRangeVerifier(t *testing.T) {
+	dag := testDag()
+	size := 3*chunk + chunk/2
+	data := testData(size)
+	root := testFile(t, dag, data, int64(chunk))
+
+	check := func(offset, length uint64) {
+		out, err := verifyRange(t, sendBlocks(t, dag, root, offset-offset%chunk), root, offset, length)
+		require.NoError(t, err)
+		require.Equal(t, data[offset:offset+length], out)
+	}
+
+	// whole file
+	check(0, size)
+
+	// offset inside a leaf
+	check(chunk+100, chunk)
+	check(100, 10)
+
+	// the last partial leaf
+	check(3*chunk+10, chunk/2-10)
+	check(2*chunk+chunk/2, size-2*chunk-chunk/2)
+
+	// blocks which don't match the file are rejected
+	blocks := sendBlocks(t, dag, root, 0)
+	blocks[1], blocks[2] = blocks[2], blocks[1]
+	_, err := verifyRange(t, blocks, root, 0, size)
+	require.Error(t, err)
+}
+
+func TestUnixfsMisaligned(t *testing.T) {
+	dag := testDag()
+	data := testData(chunk + 1000)
+	root := testFile(t, dag, data, int64(chunk/2+1))
+
+	_, err := verifyRange(t, sendBlocks(t, dag, testFile(t, dag, data, int64(chunk)), 0), root, 0, uint64(len(data)))
+	require.Error(t, err, "blocks of a differently chunked file")
+
+	nd, err := dag.Get(context.Background(), root)
+	require.NoError(t, err)
+
+	w := newUnixfsWalker(root, 0)
+	ref, err := w.next()
+	require.NoError(t, err)
+	_, err = w.visit(nd, ref)
+	require.NoError(t, err)
+
+	var visitErr error
+	for visitErr == nil {
+		ref, err := w.next()
+		require.NoError(t, err, "misaligned leaf should be rejected before the file ends")
+
+		nd, err := dag.Get(context.Background(), ref.c)
+		require.NoError(t, err)
+		_, visitErr = w.visit(nd, ref)
+	}
+	require.Contains(t, visitErr.Error(), "aligned")
+}
+
+func TestUnixfsResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrieval-progress")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	outPath := filepath.Join(dir, "out")
+
+	dag := testDag()
+	size := 3*chunk + 500
+	data := testData(size)
+	root := testFile(t, dag, data, int64(chunk))
+
+	p, err := LoadProgress(outPath)
+	require.NoError(t, err)
+	require.Nil(t, p)
+
+	// the first part of the range was written before the retrieval stopped
+	offset, length := uint64(100), size-200
+	written := chunk + 1234
+	require.NoError(t, (&Progress{Root: root, Offset: offset, Length: length, Written: written}).Save(outPath))
+
+	p, err = LoadProgress(outPath)
+	require.NoError(t, err)
+	require.True(t, p.Resumes(root, offset, length))
+	require.False(t, p.Resumes(root, offset, length+1))
+	require.False(t, p.Resumes(root, 0, length))
+
+	// the rest of the range is retrieved starting from the chunk the
+	// retrieval stopped in
+	resumeAt := p.Offset + p.Written
+	rest, err := verifyRange(t, sendBlocks(t, dag, root, resumeAt-resumeAt%chunk), root, resumeAt, p.Length-p.Written)
+	require.NoError(t, err)
+	require.Equal(t, data[resumeAt:offset+length], rest)
+
+	require.NoError(t, RemoveProgress(outPath))
+	p, err = LoadProgress(outPath)
+	require.NoError(t, err)
+	require.Nil(t, p)
+	require.NoError(t, RemoveProgress(outPath))
+}
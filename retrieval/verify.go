@@ -1,143 +1,154 @@
 package retrieval
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"io"
 
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
-	"github.com/ipfs/go-merkledag"
-	"github.com/ipfs/go-unixfs"
-	pb "github.com/ipfs/go-unixfs/pb"
 	"golang.org/x/xerrors"
 
-	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/lib/cborrpc"
+	"github.com/filecoin-project/lotus/storage/sectorblocks"
 )
 
-type BlockVerifier interface {
-	Verify(context.Context, blocks.Block, io.Writer) (internal bool, err error)
-}
+// maxBlockSize is the largest block accepted from miners
+const maxBlockSize = 2 << 20
 
-type OptimisticVerifier struct {
-}
+// maxBlockMessage is the largest block message read from miners, the block
+// data with its prefix and cbor framing
+const maxBlockMessage = maxBlockSize + 1024
 
-func (o *OptimisticVerifier) Verify(context.Context, blocks.Block, io.Writer) (bool, error) {
-	// It's probably fine
-	return false, nil
+// BlockVerifier checks blocks received from the miner and writes retrieved
+// data to out
+type BlockVerifier interface {
+	Verify(context.Context, Block, io.Writer) (internal bool, err error)
 }
 
-type UnixFs0Verifier struct {
-	Root    cid.Cid
-	rootBlk blocks.Block
-
-	expect int
-	seen   int
-
-	sub *UnixFs0Verifier
+// readBlock reads a block message from the miner. Reads are limited to the
+// largest block message, so miners can't make us read oversized blocks into
+// memory
+func readBlock(r io.Reader) (Block, error) {
+	var block Block
+	if err := cborrpc.ReadCborRPC(io.LimitReader(r, maxBlockMessage), &block); err != nil {
+		return Block{}, err
+	}
+	return block, nil
 }
 
-func (b *UnixFs0Verifier) verify(ctx context.Context, blk blocks.Block, out io.Writer) (last bool, internal bool, err error) {
-	if b.sub != nil {
-		// TODO: check links here (iff b.sub.sub == nil)
-
-		subLast, internal, err := b.sub.verify(ctx, blk, out)
-		if err != nil {
-			return false, false, err
-		}
-		if subLast {
-			b.sub = nil
-			b.seen++
-		}
-
-		return b.seen == b.expect, internal, nil
+// checkBlock checks that a block received from the miner is the expected
+// block. The prefix sent by the miner isn't trusted, data is hashed with the
+// prefix of the expected CID
+func checkBlock(expect cid.Cid, block Block) (blocks.Block, error) {
+	if len(block.Data) > maxBlockSize {
+		return nil, xerrors.Errorf("block %s is too large: %d bytes", expect, len(block.Data))
 	}
 
-	if b.seen >= b.expect { // this is probably impossible
-		return false, false, xerrors.New("unixfs verifier: too many nodes in level")
+	prefix := expect.Prefix()
+	if !bytes.Equal(block.Prefix, prefix.Bytes()) {
+		return nil, xerrors.Errorf("block prefix didn't match the expected block %s", expect)
 	}
 
-	links, err := b.checkInternal(blk, out)
+	c, err := prefix.Sum(block.Data)
 	if err != nil {
-		return false, false, err
+		return nil, err
+	}
+	if !c.Equals(expect) {
+		return nil, xerrors.Errorf("block CID didn't match: valid %s, got %s", expect, c)
 	}
 
-	if links > 0 { // TODO: check if all links are intermediate (or all aren't)
-		if links > build.UnixfsLinksPerLevel {
-			return false, false, xerrors.New("unixfs verifier: too many links in intermediate node")
-		}
+	return blocks.NewBlockWithCid(block.Data, c)
+}
 
-		if b.seen+1 == b.expect && links != build.UnixfsLinksPerLevel {
-			return false, false, xerrors.New("unixfs verifier: too few nodes in level")
-		}
+// DAGVerifier checks that blocks of raw and IPLD DAGs are received in the
+// order they are linked in, starting from the root
+type DAGVerifier struct {
+	ser    sectorblocks.SealSerialization
+	root   cid.Cid
+	walker *sectorblocks.DAGWalker
 
-		b.sub = &UnixFs0Verifier{
-			Root:    blk.Cid(),
-			rootBlk: blk,
-			expect:  links,
-		}
+	headerWritten bool
+}
 
-		// don't mark as seen yet
-		return false, true, nil
+func NewDAGVerifier(root cid.Cid, mode api.SerializationMode) (*DAGVerifier, error) {
+	ser, err := sectorblocks.SerializationFor(mode)
+	if err != nil {
+		return nil, err
+	}
+	if ser == sectorblocks.SerializationUnixfs0 {
+		return nil, xerrors.New("dag verifier: unixfs files should be verified with UnixfsRangeVerifier")
 	}
 
-	b.seen++
-	return b.seen == b.expect, false, nil
+	return &DAGVerifier{
+		ser:    ser,
+		root:   root,
+		walker: sectorblocks.NewDAGWalker(nil, root, ser == sectorblocks.SerializationIPLD),
+	}, nil
 }
 
-func (b *UnixFs0Verifier) checkInternal(blk blocks.Block, out io.Writer) (int, error) {
-	nd, err := ipld.Decode(blk)
+// Verify checks the next block of the DAG and writes retrieved data to out.
+// It returns the number of bytes counted towards the retrieval size
+func (v *DAGVerifier) Verify(ctx context.Context, block Block, out io.Writer) (uint64, error) {
+	expect, err := v.walker.NextCid()
+	if err == io.EOF {
+		return 0, xerrors.New("dag verifier: unexpected block after the whole dag was received")
+	}
 	if err != nil {
-		log.Warnf("IPLD Decode failed: %s", err)
 		return 0, err
 	}
 
-	// TODO: check size
-	switch nd := nd.(type) {
-	case *merkledag.ProtoNode:
-		fsn, err := unixfs.FSNodeFromBytes(nd.Data())
-		if err != nil {
-			log.Warnf("unixfs.FSNodeFromBytes failed: %s", err)
-			return 0, err
-		}
-		if fsn.Type() != pb.Data_File {
-			return 0, xerrors.New("internal nodes must be a file")
-		}
-		if len(fsn.Data()) > 0 {
-			return 0, xerrors.New("internal node with data")
-		}
-		if len(nd.Links()) == 0 {
-			return 0, xerrors.New("internal node with no links")
-		}
-		return len(nd.Links()), nil
+	blk, err := checkBlock(expect, block)
+	if err != nil {
+		return 0, xerrors.Errorf("dag verifier: %w", err)
+	}
 
-	case *merkledag.RawNode:
-		_, err := out.Write(nd.RawData())
-		return 0, err
-	default:
-		return 0, xerrors.New("verifier: unknown node type")
+	nd, err := ipld.Decode(blk)
+	if err != nil {
+		return 0, xerrors.Errorf("dag verifier: decoding block %s: %w", blk.Cid(), err)
 	}
-}
+	v.walker.AddLinks(nd)
 
-func (b *UnixFs0Verifier) Verify(ctx context.Context, blk blocks.Block, w io.Writer) (bool, error) {
-	// root is special
-	if b.rootBlk == nil {
-		if !b.Root.Equals(blk.Cid()) {
-			return false, xerrors.Errorf("unixfs verifier: root block CID didn't match: valid %s, got %s", b.Root, blk.Cid())
-		}
-		b.rootBlk = blk
-		links, err := b.checkInternal(blk, w)
-		if err != nil {
-			return false, err
+	size := sectorblocks.TransferSize(v.ser, nd)
+	if size == 0 {
+		return 0, nil
+	}
+
+	if v.ser == sectorblocks.SerializationIPLD {
+		if !v.headerWritten {
+			if err := writeCarHeader(out, v.root); err != nil {
+				return 0, err
+			}
+			v.headerWritten = true
 		}
 
-		b.expect = links
-		return links != 0, nil
+		if _, err := out.Write(sectorblocks.CarSection(nd)); err != nil {
+			return 0, err
+		}
 	}
 
-	_, internal, err := b.verify(ctx, blk, w)
-	return internal, err
+	_, err = out.Write(nd.RawData())
+	return size, err
+}
+
+// Done returns true when all blocks of the DAG were received
+func (v *DAGVerifier) Done() bool {
+	return v.walker.Done()
 }
 
-var _ BlockVerifier = &OptimisticVerifier{}
-var _ BlockVerifier = &UnixFs0Verifier{}
+func writeCarHeader(out io.Writer, root cid.Cid) error {
+	hdr, err := sectorblocks.CarHeader(root)
+	if err != nil {
+		return err
+	}
+
+	lb := make([]byte, binary.MaxVarintLen64)
+	if _, err := out.Write(lb[:binary.PutUvarint(lb, uint64(len(hdr)))]); err != nil {
+		return err
+	}
+	_, err = out.Write(hdr)
+	return err
+}
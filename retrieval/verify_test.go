@@ -0,0 +1,35 @@
+package retrieval
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/lib/cborrpc"
+)
+
+func TestReadBlock(t *testing.T) {
+	var buf bytes.Buffer
+
+	// blocks are read one at a time from the stream
+	first := Block{Prefix: []byte{1}, Data: testData(maxBlockSize)}
+	second := Block{Prefix: []byte{2}, Data: []byte("second")}
+	require.NoError(t, cborrpc.WriteCborRPC(&buf, first))
+	require.NoError(t, cborrpc.WriteCborRPC(&buf, second))
+
+	block, err := readBlock(&buf)
+	require.NoError(t, err)
+	require.Equal(t, first, block)
+
+	block, err = readBlock(&buf)
+	require.NoError(t, err)
+	require.Equal(t, second, block)
+
+	// larger blocks aren't read
+	buf.Reset()
+	require.NoError(t, cborrpc.WriteCborRPC(&buf, Block{Prefix: []byte{1}, Data: testData(maxBlockMessage)}))
+	_, err = readBlock(&buf)
+	require.Error(t, err)
+	require.NotZero(t, buf.Len(), "the block is only read up to the limit")
+}
@@ -6,25 +6,33 @@ import (
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
 	logging "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/pkg/errors"
 
 	"github.com/filecoin-project/lotus/api"
-	"github.com/filecoin-project/lotus/build"
-	"github.com/filecoin-project/lotus/chain/actors"
 	"github.com/filecoin-project/lotus/chain/address"
 	"github.com/filecoin-project/lotus/chain/events"
 	"github.com/filecoin-project/lotus/chain/store"
 	"github.com/filecoin-project/lotus/chain/types"
-	"github.com/filecoin-project/lotus/lib/sectorbuilder"
+	"github.com/filecoin-project/lotus/lib/statestore"
 	"github.com/filecoin-project/lotus/storage/commitment"
 	"github.com/filecoin-project/lotus/storage/sector"
 )
 
 var log = logging.Logger("storageminer")
 
-const PoStConfidence = 3
+// Config contains the operator settings of the miner
+type Config struct {
+	Sealing SealPolicy
+
+	// PoStConfidence is the number of epochs to wait for before generating
+	// a PoSt
+	PoStConfidence int
+
+	Redeem RedeemPolicy
+}
 
 type Miner struct {
 	api    storageMinerApi
@@ -33,6 +41,11 @@ type Miner struct {
 	secst *sector.Store
 	commt *commitment.Tracker
 
+	sectors       *statestore.StateStore
+	sectorUpdated chan sectorUpdate
+
+	cfg Config
+
 	maddr address.Address
 
 	worker address.Address
@@ -55,6 +68,7 @@ type storageMinerApi interface {
 	StateMinerProvingPeriodEnd(context.Context, address.Address, *types.TipSet) (uint64, error)
 	StateMinerProvingSet(context.Context, address.Address, *types.TipSet) ([]*api.SectorInfo, error)
 	StateWaitMsg(context.Context, cid.Cid) (*api.MsgWait, error)
+	StateGetActor(ctx context.Context, actor address.Address, ts *types.TipSet) (*types.Actor, error)
 
 	MpoolPushMessage(context.Context, *types.Message) (*types.SignedMessage, error)
 
@@ -63,12 +77,19 @@ type storageMinerApi interface {
 	ChainGetRandomness(context.Context, *types.TipSet, []*types.Ticket, int) ([]byte, error)
 	ChainGetTipSetByHeight(context.Context, uint64, *types.TipSet) (*types.TipSet, error)
 	ChainGetBlockMessages(context.Context, cid.Cid) (*api.BlockMessages, error)
+	ChainReadObj(context.Context, cid.Cid) ([]byte, error)
+
+	PaychList(context.Context) ([]address.Address, error)
+	PaychStatus(context.Context, address.Address) (*api.PaychStatus, error)
+	PaychVoucherList(context.Context, address.Address) ([]*types.SignedVoucher, error)
+	PaychVoucherCheckSpendable(context.Context, address.Address, *types.SignedVoucher, []byte, []byte) (bool, error)
+	PaychVoucherSubmit(context.Context, address.Address, *types.SignedVoucher) (cid.Cid, error)
 
 	WalletBalance(context.Context, address.Address) (types.BigInt, error)
 	WalletHas(context.Context, address.Address) (bool, error)
 }
 
-func NewMiner(api storageMinerApi, addr address.Address, h host.Host, ds datastore.Batching, secst *sector.Store, commt *commitment.Tracker) (*Miner, error) {
+func NewMiner(api storageMinerApi, addr address.Address, h host.Host, ds datastore.Batching, secst *sector.Store, commt *commitment.Tracker, cfg Config) (*Miner, error) {
 	return &Miner{
 		api: api,
 
@@ -77,6 +98,11 @@ func NewMiner(api storageMinerApi, addr address.Address, h host.Host, ds datasto
 		ds:    ds,
 		secst: secst,
 		commt: commt,
+
+		sectors:       statestore.New(namespace.Wrap(ds, datastore.NewKey("/sectors"))),
+		sectorUpdated: make(chan sectorUpdate),
+
+		cfg: cfg,
 	}, nil
 }
 
@@ -87,88 +113,13 @@ func (m *Miner) Run(ctx context.Context) error {
 
 	m.events = events.NewEvents(ctx, m.api)
 
-	go m.handlePostingSealedSectors(ctx)
-	go m.beginPosting(ctx)
-	return nil
-}
-
-func (m *Miner) handlePostingSealedSectors(ctx context.Context) {
-	incoming := m.secst.Incoming()
-	defer m.secst.CloseIncoming(incoming)
-
-	for {
-		select {
-		case sinfo, ok := <-incoming:
-			if !ok {
-				// TODO: set some state variable so that this state can be
-				// visible via some status command
-				log.Warn("sealed sector channel closed, aborting process")
-				return
-			}
-
-			if err := m.commitSector(ctx, sinfo); err != nil {
-				log.Errorf("failed to commit sector: %s", err)
-				continue
-			}
-
-		case <-ctx.Done():
-			log.Warn("exiting seal posting routine")
-			return
-		}
+	if err := m.sectorStateLoop(ctx); err != nil {
+		return errors.Wrap(err, "starting sector state loop")
 	}
-}
-
-func (m *Miner) commitSector(ctx context.Context, sinfo sectorbuilder.SectorSealingStatus) error {
-	log.Info("committing sector")
-
-	ok, err := sectorbuilder.VerifySeal(build.SectorSize, sinfo.CommR[:], sinfo.CommD[:], sinfo.CommRStar[:], m.maddr, sinfo.SectorID, sinfo.Proof)
-	if err != nil {
-		log.Error("failed to verify seal we just created: ", err)
-	}
-	if !ok {
-		log.Error("seal we just created failed verification")
-	}
-
-	params := &actors.CommitSectorParams{
-		SectorID:  sinfo.SectorID,
-		CommD:     sinfo.CommD[:],
-		CommR:     sinfo.CommR[:],
-		CommRStar: sinfo.CommRStar[:],
-		Proof:     sinfo.Proof,
-	}
-	enc, aerr := actors.SerializeParams(params)
-	if aerr != nil {
-		return errors.Wrap(aerr, "could not serialize commit sector parameters")
-	}
-
-	msg := &types.Message{
-		To:       m.maddr,
-		From:     m.worker,
-		Method:   actors.MAMethods.CommitSector,
-		Params:   enc,
-		Value:    types.NewInt(0), // TODO: need to ensure sufficient collateral
-		GasLimit: types.NewInt(1000000 /* i dont know help */),
-		GasPrice: types.NewInt(1),
-	}
-
-	smsg, err := m.api.MpoolPushMessage(ctx, msg)
-	if err != nil {
-		return errors.Wrap(err, "pushing message to mpool")
-	}
-
-	if err := m.commt.TrackCommitSectorMsg(m.maddr, sinfo.SectorID, smsg.Cid()); err != nil {
-		return errors.Wrap(err, "tracking sector commitment")
-	}
-
-	go func() {
-		_, err := m.api.StateWaitMsg(ctx, smsg.Cid())
-		if err != nil {
-			return
-		}
-
-		m.beginPosting(ctx)
-	}()
 
+	go m.runSealScheduler(ctx)
+	go m.runVoucherRedeemer(ctx)
+	go m.beginPosting(ctx)
 	return nil
 }
 
@@ -49,7 +49,7 @@ func (m *Miner) beginPosting(ctx context.Context) {
 		// TODO: Cancel post
 		log.Errorf("TODO: Cancel PoSt, re-run")
 		return nil
-	}, PoStConfidence, ppe-build.PoSTChallangeTime)
+	}, m.cfg.PoStConfidence, ppe-build.PoSTChallangeTime)
 	if err != nil {
 		// TODO: This is BAD, figure something out
 		log.Errorf("scheduling PoSt failed: %s", err)
@@ -88,7 +88,7 @@ func (m *Miner) scheduleNextPost(ppe uint64) {
 		// TODO: Cancel post
 		log.Errorf("TODO: Cancel PoSt, re-run")
 		return nil
-	}, PoStConfidence, ppe-build.PoSTChallangeTime)
+	}, m.cfg.PoStConfidence, ppe-build.PoSTChallangeTime)
 	if err != nil {
 		// TODO: This is BAD, figure something out
 		log.Errorf("scheduling PoSt failed: %+v", err)
@@ -0,0 +1,447 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/go-sectorbuilder/sealing_state"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/sectorbuilder"
+)
+
+func init() {
+	cbor.RegisterCborType(SectorInfo{})
+	cbor.RegisterCborType(api.SectorLog{})
+}
+
+// SectorInfo is the persisted record of a sector going through the
+// Pending -> Packing -> Sealing -> Committing -> Proving pipeline
+type SectorInfo struct {
+	SectorID uint64
+	State    api.SectorState
+
+	FailedAt api.SectorState
+	LastErr  string
+
+	Pieces []string
+
+	CommD     []byte
+	CommR     []byte
+	CommRStar []byte
+	Proof     []byte
+
+	CommitMessage *cid.Cid
+
+	Log []api.SectorLog
+}
+
+func (si *SectorInfo) Status() api.SectorStatus {
+	return api.SectorStatus{
+		SectorID:      si.SectorID,
+		State:         si.State,
+		FailedAt:      si.FailedAt,
+		LastErr:       si.LastErr,
+		Pieces:        si.Pieces,
+		CommD:         si.CommD,
+		CommR:         si.CommR,
+		CommRStar:     si.CommRStar,
+		Proof:         si.Proof,
+		CommitMessage: si.CommitMessage,
+		Log:           si.Log,
+	}
+}
+
+type sectorUpdate struct {
+	newState api.SectorState
+	id       uint64
+	err      error
+	msg      string
+	mut      func(*SectorInfo)
+}
+
+func (m *Miner) sectorStateLoop(ctx context.Context) error {
+	var tracked []SectorInfo
+	if err := m.sectors.List(&tracked); err != nil {
+		return xerrors.Errorf("loading sector list: %w", err)
+	}
+
+	go func() {
+		incoming := m.secst.Incoming()
+		defer m.secst.CloseIncoming(incoming)
+
+		for {
+			select {
+			case status, ok := <-incoming:
+				if !ok {
+					log.Warn("sector status channel closed, aborting sector state loop")
+					return
+				}
+				m.onSealStatus(ctx, status)
+			case update := <-m.sectorUpdated:
+				m.onSectorUpdated(ctx, update)
+			case <-ctx.Done():
+				log.Warn("exiting sector state loop")
+				return
+			}
+		}
+	}()
+
+	for _, si := range tracked {
+		m.restartSector(ctx, si)
+	}
+
+	return nil
+}
+
+func (m *Miner) restartSector(ctx context.Context, si SectorInfo) {
+	switch si.State {
+	case api.SectorPending, api.SectorPacking, api.SectorSealing:
+		// the store will report the current sectorbuilder state on next poll
+		m.secst.Watch(si.SectorID)
+	case api.SectorCommitting:
+		go m.updateSector(ctx, sectorUpdate{
+			newState: api.SectorCommitting,
+			id:       si.SectorID,
+			msg:      "resumed after restart",
+		})
+	}
+}
+
+func (m *Miner) updateSector(ctx context.Context, update sectorUpdate) {
+	select {
+	case m.sectorUpdated <- update:
+	case <-ctx.Done():
+	}
+}
+
+// onSealStatus translates sectorbuilder seal status changes into sector
+// state updates
+func (m *Miner) onSealStatus(ctx context.Context, status sectorbuilder.SectorSealingStatus) {
+	si, err := m.getOrBeginSector(status.SectorID)
+	if err != nil {
+		log.Errorf("tracking sector %d: %s", status.SectorID, err)
+		return
+	}
+
+	update := sectorUpdate{id: status.SectorID}
+
+	switch status.State {
+	case sealing_state.Pending:
+		return
+	case sealing_state.Sealing:
+		if si.State != api.SectorPending && si.State != api.SectorPacking {
+			return
+		}
+		update.newState = api.SectorSealing
+	case sealing_state.Sealed:
+		if si.State != api.SectorPending && si.State != api.SectorPacking && si.State != api.SectorSealing {
+			return
+		}
+		update.newState = api.SectorCommitting
+		update.mut = sealedMutator(status)
+	case sealing_state.Failed:
+		if si.State == api.SectorFailed {
+			return
+		}
+		update.newState = api.SectorFailed
+		update.err = xerrors.Errorf("sealing failed: %s", status.SealErrorMsg)
+	default:
+		log.Warnf("sector %d: unknown seal state %s", status.SectorID, status.State)
+		return
+	}
+
+	m.onSectorUpdated(ctx, update)
+}
+
+func sealedMutator(status sectorbuilder.SectorSealingStatus) func(*SectorInfo) {
+	return func(si *SectorInfo) {
+		si.CommD = status.CommD[:]
+		si.CommR = status.CommR[:]
+		si.CommRStar = status.CommRStar[:]
+		si.Proof = status.Proof
+
+		si.Pieces = make([]string, len(status.Pieces))
+		for i, p := range status.Pieces {
+			si.Pieces[i] = p.Key
+		}
+	}
+}
+
+func (m *Miner) getOrBeginSector(id uint64) (SectorInfo, error) {
+	var si SectorInfo
+
+	has, err := m.sectors.Has(id)
+	if err != nil {
+		return si, err
+	}
+
+	if has {
+		err := m.sectors.Get(id, &si)
+		return si, err
+	}
+
+	si = SectorInfo{
+		SectorID: id,
+		State:    api.SectorPending,
+		Log: []api.SectorLog{{
+			Timestamp: uint64(time.Now().Unix()),
+			State:     api.SectorPending,
+		}},
+	}
+
+	return si, m.sectors.Begin(id, &si)
+}
+
+func (m *Miner) onSectorUpdated(ctx context.Context, update sectorUpdate) {
+	if _, err := m.getOrBeginSector(update.id); err != nil {
+		log.Errorf("tracking sector %d: %s", update.id, err)
+		return
+	}
+
+	var sector SectorInfo
+	var skip bool
+	err := m.sectors.Mutate(update.id, func(si *SectorInfo) error {
+		if update.newState == api.SectorPacking && si.State != api.SectorPending {
+			// sealing already started, don't go back
+			skip = true
+			return nil
+		}
+
+		entry := api.SectorLog{
+			Timestamp: uint64(time.Now().Unix()),
+			State:     update.newState,
+			Message:   update.msg,
+		}
+
+		if update.err != nil {
+			log.Errorf("sector %d failed in state %s: %s", update.id, si.State, update.err)
+
+			si.FailedAt = si.State
+			si.LastErr = update.err.Error()
+			si.State = api.SectorFailed
+
+			entry.State = api.SectorFailed
+			entry.Message = update.err.Error()
+		} else {
+			si.State = update.newState
+		}
+
+		if update.mut != nil {
+			update.mut(si)
+		}
+
+		si.Log = append(si.Log, entry)
+
+		sector = *si
+		return nil
+	})
+	if err != nil {
+		log.Errorf("updating sector %d state: %s", update.id, err)
+		return
+	}
+	if skip {
+		return
+	}
+
+	log.Infof("sector %d is now %s", sector.SectorID, sector.State)
+
+	if update.err != nil {
+		return
+	}
+
+	switch sector.State {
+	case api.SectorCommitting:
+		m.handleSector(ctx, sector, m.committing)
+	}
+}
+
+type sectorHandlerFunc func(ctx context.Context, si SectorInfo) *sectorUpdate
+
+func (m *Miner) handleSector(ctx context.Context, si SectorInfo, cb sectorHandlerFunc) {
+	go func() {
+		update := cb(ctx, si)
+		if update == nil {
+			return
+		}
+
+		update.id = si.SectorID
+		m.updateSector(ctx, *update)
+	}()
+}
+
+// COMMITTING
+
+func (m *Miner) committing(ctx context.Context, si SectorInfo) *sectorUpdate {
+	if si.CommitMessage == nil {
+		mcid, err := m.pushCommit(ctx, si)
+		if err != nil {
+			return &sectorUpdate{err: err}
+		}
+
+		// persist the message before waiting on it so we can resume the wait
+		// after a restart
+		return &sectorUpdate{
+			newState: api.SectorCommitting,
+			msg:      "commit message sent: " + mcid.String(),
+			mut: func(si *SectorInfo) {
+				si.CommitMessage = &mcid
+			},
+		}
+	}
+
+	mw, err := m.api.StateWaitMsg(ctx, *si.CommitMessage)
+	if err != nil {
+		if ctx.Err() != nil {
+			// shutting down, we'll resume waiting on restart
+			return nil
+		}
+		return &sectorUpdate{err: xerrors.Errorf("waiting for commit message: %w", err)}
+	}
+
+	if mw.Receipt.ExitCode != 0 {
+		return &sectorUpdate{
+			err: xerrors.Errorf("commit message %s failed with exit code %d", si.CommitMessage, mw.Receipt.ExitCode),
+			mut: func(si *SectorInfo) {
+				// a retry will need to send a new message
+				si.CommitMessage = nil
+			},
+		}
+	}
+
+	if err := m.commt.TrackCommitSectorMsg(m.maddr, si.SectorID, *si.CommitMessage); err != nil {
+		return &sectorUpdate{err: xerrors.Errorf("tracking sector commitment: %w", err)}
+	}
+
+	go m.beginPosting(ctx)
+
+	return &sectorUpdate{newState: api.SectorProving}
+}
+
+func (m *Miner) pushCommit(ctx context.Context, si SectorInfo) (cid.Cid, error) {
+	log.Infof("committing sector %d", si.SectorID)
+
+	ok, err := sectorbuilder.VerifySeal(build.SectorSize, si.CommR, si.CommD, si.CommRStar, m.maddr, si.SectorID, si.Proof)
+	if err != nil {
+		log.Error("failed to verify seal we just created: ", err)
+	}
+	if !ok {
+		log.Error("seal we just created failed verification")
+	}
+
+	params := &actors.CommitSectorParams{
+		SectorID:  si.SectorID,
+		CommD:     si.CommD,
+		CommR:     si.CommR,
+		CommRStar: si.CommRStar,
+		Proof:     si.Proof,
+	}
+	enc, aerr := actors.SerializeParams(params)
+	if aerr != nil {
+		return cid.Undef, xerrors.Errorf("could not serialize commit sector parameters: %w", aerr)
+	}
+
+	msg := &types.Message{
+		To:       m.maddr,
+		From:     m.worker,
+		Method:   actors.MAMethods.CommitSector,
+		Params:   enc,
+		Value:    types.NewInt(0), // TODO: need to ensure sufficient collateral
+		GasLimit: types.NewInt(1000000 /* i dont know help */),
+		GasPrice: types.NewInt(1),
+	}
+
+	smsg, err := m.api.MpoolPushMessage(ctx, msg)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("pushing message to mpool: %w", err)
+	}
+
+	return smsg.Cid(), nil
+}
+
+// SealStagedSectors starts sealing all staged sectors, moving them to the
+// Packing state
+func (m *Miner) SealStagedSectors(ctx context.Context) error {
+	staged, err := m.secst.SealAllStaged()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range staged {
+		m.secst.Watch(id)
+		m.updateSector(ctx, sectorUpdate{
+			newState: api.SectorPacking,
+			id:       id,
+		})
+	}
+
+	return nil
+}
+
+func (m *Miner) GetSectorInfo(id uint64) (SectorInfo, error) {
+	var out SectorInfo
+	err := m.sectors.Get(id, &out)
+	return out, err
+}
+
+func (m *Miner) ListSectors() ([]SectorInfo, error) {
+	var sectors []SectorInfo
+	if err := m.sectors.List(&sectors); err != nil {
+		return nil, err
+	}
+	return sectors, nil
+}
+
+// RetrySector restarts a failed sector from the step it failed at
+func (m *Miner) RetrySector(ctx context.Context, id uint64) error {
+	si, err := m.GetSectorInfo(id)
+	if err != nil {
+		return err
+	}
+
+	if si.State != api.SectorFailed {
+		return xerrors.Errorf("sector %d is not in failed state (state: %s)", id, si.State)
+	}
+
+	update := sectorUpdate{
+		id:  id,
+		msg: "retry",
+	}
+
+	switch si.FailedAt {
+	case api.SectorCommitting:
+		update.newState = api.SectorCommitting
+	case api.SectorPending, api.SectorPacking, api.SectorSealing:
+		status, err := m.secst.SealStatus(id)
+		if err != nil {
+			return xerrors.Errorf("getting seal status: %w", err)
+		}
+
+		switch status.State {
+		case sealing_state.Pending:
+			update.newState = si.FailedAt
+			m.secst.Watch(id)
+		case sealing_state.Sealing:
+			update.newState = api.SectorSealing
+			m.secst.Watch(id)
+		case sealing_state.Sealed:
+			update.newState = api.SectorCommitting
+			update.mut = sealedMutator(status)
+		default:
+			// TODO: rust-sectorbuilder can't re-seal a sector, once it can we
+			//  should retry here
+			return xerrors.Errorf("sector %d failed to seal in the sectorbuilder (%s), this can't be retried", id, status.SealErrorMsg)
+		}
+	default:
+		return xerrors.Errorf("sector %d failed in state %s which can't be retried", id, si.FailedAt)
+	}
+
+	m.updateSector(ctx, update)
+	return nil
+}
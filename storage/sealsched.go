@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/lib/sectorbuilder"
+)
+
+const sealSchedInterval = 30 * time.Second
+
+// SealPolicy controls when staged sectors get sealed
+type SealPolicy struct {
+	// MaxConcurrentSeals limits the number of sectors being sealed at once,
+	// 0 means no limit
+	MaxConcurrentSeals int
+
+	// MaxWait is how long a partially filled sector can stay staged before
+	// it gets sealed, 0 disables waiting
+	MaxWait time.Duration
+
+	// FillRatio is how full a staged sector needs to be to get sealed right
+	// away, 0 disables this check
+	FillRatio float64
+}
+
+func (m *Miner) runSealScheduler(ctx context.Context) {
+	tick := time.NewTicker(sealSchedInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if err := m.scheduleSeals(ctx); err != nil {
+				log.Errorf("scheduling seals: %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// scheduleSeals starts sealing staged sectors when any of them is full
+// enough, or has waited long enough.
+func (m *Miner) scheduleSeals(ctx context.Context) error {
+	// TODO: rust-sectorbuilder can only seal all staged sectors at once, once
+	//  it can seal sectors individually we should seal them in priority order
+
+	sectors, err := m.ListSectors()
+	if err != nil {
+		return err
+	}
+
+	var sealing int
+	pending := map[uint64]SectorInfo{}
+	for _, si := range sectors {
+		switch si.State {
+		case api.SectorPending:
+			pending[si.SectorID] = si
+		case api.SectorPacking, api.SectorSealing:
+			sealing++
+		}
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// all staged sectors get sealed together. The sector builder keeps at
+	// most MaxConcurrentSeals sectors staged, so with nothing sealing the
+	// batch is within the limit
+	if max := m.cfg.Sealing.MaxConcurrentSeals; max > 0 && sealing+len(pending) > max {
+		if sealing == 0 {
+			// sectors staged with a higher limit before a restart
+			log.Warnf("%d sectors staged, more than MaxConcurrentSeals (%d), sealing them all", len(pending), max)
+		} else {
+			log.Debugf("%d sectors sealing, %d staged, waiting for seals to finish", sealing, len(pending))
+			return nil
+		}
+	}
+
+	staged := map[uint64]sectorStagedInfo{}
+	for _, ss := range m.secst.Staged() {
+		staged[ss.SectorID] = sectorStagedInfo{
+			firstPiece: ss.FirstPiece,
+			bytes:      ss.Bytes,
+			priority:   ss.Priority,
+		}
+	}
+
+	sectorBytes := sectorbuilder.UserBytesForSectorSize(build.SectorSize)
+
+	for id, si := range pending {
+		info, ok := staged[id]
+		if !ok {
+			// sector was staged before a restart, we only know how long it's
+			// been waiting
+			info.firstPiece = time.Unix(int64(si.Log[0].Timestamp), 0)
+		}
+
+		if m.cfg.Sealing.FillRatio > 0 && float64(info.bytes) >= m.cfg.Sealing.FillRatio*float64(sectorBytes) {
+			log.Infof("sealing staged sectors, sector %d is %d%% full", id, info.bytes*100/sectorBytes)
+			return m.SealStagedSectors(ctx)
+		}
+
+		if m.cfg.Sealing.MaxWait > 0 {
+			wait := m.cfg.Sealing.MaxWait / time.Duration(1+info.priority)
+			if time.Since(info.firstPiece) >= wait {
+				log.Infof("sealing staged sectors, sector %d waited for %s (priority %d)", id, time.Since(info.firstPiece).Truncate(time.Second), info.priority)
+				return m.SealStagedSectors(ctx)
+			}
+		}
+	}
+
+	return nil
+}
+
+type sectorStagedInfo struct {
+	firstPiece time.Time
+	bytes      uint64
+	priority   int
+}
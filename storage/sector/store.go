@@ -21,8 +21,10 @@ type Store struct {
 	lk sync.Mutex
 	sb *sectorbuilder.SectorBuilder
 
-	waiting  map[uint64]chan struct{}
-	incoming []chan sectorbuilder.SectorSealingStatus
+	waiting   map[uint64]chan struct{}
+	lastState map[uint64]sectorbuilder.SectorSealingStatus
+	staged    map[uint64]*StagedSector
+	incoming  []chan sectorbuilder.SectorSealingStatus
 	// TODO: outdated chan
 
 	closeCh chan struct{}
@@ -30,9 +32,11 @@ type Store struct {
 
 func NewStore(sb *sectorbuilder.SectorBuilder) *Store {
 	return &Store{
-		sb:      sb,
-		waiting: map[uint64]chan struct{}{},
-		closeCh: make(chan struct{}),
+		sb:        sb,
+		waiting:   map[uint64]chan struct{}{},
+		lastState: map[uint64]sectorbuilder.SectorSealingStatus{},
+		staged:    map[uint64]*StagedSector{},
+		closeCh:   make(chan struct{}),
 	}
 }
 
@@ -52,7 +56,7 @@ func (s *Store) poll() {
 	}
 	s.lk.Unlock()
 
-	var done []sectorbuilder.SectorSealingStatus
+	var changed []sectorbuilder.SectorSealingStatus
 
 	// check status of each
 	for _, sec := range toPoll {
@@ -62,18 +66,31 @@ func (s *Store) poll() {
 			continue
 		}
 
-		if status.State == sealing_state.Sealed {
-			done = append(done, status)
+		s.lk.Lock()
+		last, seen := s.lastState[sec]
+		s.lk.Unlock()
+
+		if !seen || last.State != status.State {
+			changed = append(changed, status)
 		}
 	}
 
 	// send updates
 	s.lk.Lock()
-	for _, sector := range done {
-		watch, ok := s.waiting[sector.SectorID]
-		if ok {
-			close(watch)
-			delete(s.waiting, sector.SectorID)
+	for _, sector := range changed {
+		s.lastState[sector.SectorID] = sector
+
+		if sector.State != sealing_state.Pending {
+			delete(s.staged, sector.SectorID)
+		}
+
+		if sector.State == sealing_state.Sealed || sector.State == sealing_state.Failed {
+			watch, ok := s.waiting[sector.SectorID]
+			if ok {
+				close(watch)
+				delete(s.waiting, sector.SectorID)
+				delete(s.lastState, sector.SectorID)
+			}
 		}
 		for _, c := range s.incoming {
 			c <- sector // TODO: ctx!
@@ -100,21 +117,84 @@ func (s *Store) service() {
 	}
 }
 
-func (s *Store) AddPiece(ref string, size uint64, r io.Reader) (sectorID uint64, err error) {
+// StagedSector describes pieces waiting in a staged sector, it's used to
+// decide when to seal partially filled sectors
+type StagedSector struct {
+	SectorID uint64
+
+	FirstPiece time.Time
+	Bytes      uint64
+	Priority   int // max priority of pieces in the sector
+}
+
+// AddPiece adds a piece to a staged sector. Sectors with higher priority
+// pieces get sealed sooner
+func (s *Store) AddPiece(ref string, size uint64, r io.Reader, priority int) (sectorID uint64, err error) {
 	sectorID, err = s.sb.AddPiece(ref, size, r)
 
 	if err != nil {
 		return 0, err
 	}
 
+	s.Watch(sectorID)
+
+	s.lk.Lock()
+	ss, ok := s.staged[sectorID]
+	if !ok {
+		ss = &StagedSector{
+			SectorID:   sectorID,
+			FirstPiece: time.Now(),
+		}
+		s.staged[sectorID] = ss
+	}
+	ss.Bytes += size
+	if priority > ss.Priority {
+		ss.Priority = priority
+	}
+	s.lk.Unlock()
+
+	return sectorID, nil
+}
+
+// Staged lists the sectors which had pieces added since the node started
+// and haven't started sealing yet
+func (s *Store) Staged() []StagedSector {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	out := make([]StagedSector, 0, len(s.staged))
+	for _, ss := range s.staged {
+		out = append(out, *ss)
+	}
+	return out
+}
+
+// Watch starts tracking seal status changes of a sector. This is used to
+// resume tracking sectors which were being sealed before a restart
+func (s *Store) Watch(sectorID uint64) {
 	s.lk.Lock()
 	_, exists := s.waiting[sectorID]
 	if !exists { // pieces can share sectors
 		s.waiting[sectorID] = make(chan struct{})
 	}
 	s.lk.Unlock()
+}
 
-	return sectorID, nil
+func (s *Store) SealAllStaged() ([]uint64, error) {
+	staged, err := s.sb.GetAllStagedSectors()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.sb.SealAllStagedSectors(); err != nil {
+		return nil, err
+	}
+
+	return staged, nil
+}
+
+func (s *Store) SealStatus(sectorID uint64) (sectorbuilder.SectorSealingStatus, error) {
+	return s.sb.SealStatus(sectorID)
 }
 
 func (s *Store) CloseIncoming(c <-chan sectorbuilder.SectorSealingStatus) {
@@ -11,6 +11,8 @@ import (
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	ipld "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-unixfs"
+	"io/ioutil"
+	"strconv"
 	"sync"
 
 	"github.com/ipfs/go-cid"
@@ -18,18 +20,65 @@ import (
 	dshelp "github.com/ipfs/go-ipfs-ds-help"
 	files "github.com/ipfs/go-ipfs-files"
 	cbor "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/lotus/storage/sector"
+	"github.com/filecoin-project/lotus/storage/unsealed"
 )
 
 type SealSerialization uint8
 
 const (
 	SerializationUnixfs0 SealSerialization = 'u'
+	SerializationRaw     SealSerialization = 'r'
+	SerializationIPLD    SealSerialization = 'i'
 )
 
+var serializationModes = map[api.SerializationMode]SealSerialization{
+	api.SerializationUnixFs: SerializationUnixfs0,
+	api.SerializationRaw:    SerializationRaw,
+	api.SerializationIPLD:   SerializationIPLD,
+}
+
+func SerializationFor(mode api.SerializationMode) (SealSerialization, error) {
+	s, ok := serializationModes[mode]
+	if !ok {
+		return 0, xerrors.Errorf("unknown serialization mode: '%s'", mode)
+	}
+	return s, nil
+}
+
+func (s SealSerialization) Mode() api.SerializationMode {
+	for m, ms := range serializationModes {
+		if ms == s {
+			return m
+		}
+	}
+	return ""
+}
+
+// PieceKey returns the key the piece with DAG under ref is added to the
+// sectorbuilder with
+func PieceKey(s SealSerialization, ref cid.Cid) string {
+	return string(s) + ref.String()
+}
+
+// PieceInfo describes how a DAG was stored
+type PieceInfo struct {
+	Serialization SealSerialization
+
+	// Size is the number of bytes sent when retrieving the DAG
+	Size uint64
+}
+
+func init() {
+	cbor.RegisterCborType(PieceInfo{})
+}
+
 var dsPrefix = datastore.NewKey("/sealedblocks")
 var imBlocksPrefix = datastore.NewKey("/intermediate")
+var pieceInfoPrefix = datastore.NewKey("/pieceinfo")
+var pieceSectorPrefix = datastore.NewKey("/piecesector")
 
 var ErrNotFound = errors.New("not found")
 
@@ -41,25 +90,29 @@ type SectorBlocks struct {
 	unsealed *unsealedBlocks
 	keys     datastore.Batching
 	keyLk    sync.Mutex
+
+	pieces datastore.Datastore
+
+	// sectors maps piece keys to the sectors they were added to
+	sectors datastore.Datastore
 }
 
-func NewSectorBlocks(sectst *sector.Store, ds dtypes.MetadataDS, sb *sectorbuilder.SectorBuilder) *SectorBlocks {
+func NewSectorBlocks(sectst *sector.Store, ds dtypes.MetadataDS, sb *sectorbuilder.SectorBuilder, cache *unsealed.Cache) *SectorBlocks {
 	sbc := &SectorBlocks{
 		Store: sectst,
 
 		intermediate: blockstore.NewBlockstore(namespace.Wrap(ds, imBlocksPrefix)),
 
-		keys: namespace.Wrap(ds, dsPrefix),
+		keys:    namespace.Wrap(ds, dsPrefix),
+		pieces:  namespace.Wrap(ds, pieceInfoPrefix),
+		sectors: namespace.Wrap(ds, pieceSectorPrefix),
 	}
 
-	unsealed := &unsealedBlocks{ // TODO: untangle this
-		sb: sb,
-
-		unsealed:  map[string][]byte{},
-		unsealing: map[string]chan struct{}{},
+	sbc.unsealed = &unsealedBlocks{ // TODO: untangle this
+		sb:    sb,
+		cache: cache,
 	}
 
-	sbc.unsealed = unsealed
 	return sbc
 }
 
@@ -153,7 +206,7 @@ func (r *refStorer) Read(p []byte) (n int, err error) {
 	}
 }
 
-func (st *SectorBlocks) AddUnixfsPiece(ref cid.Cid, r UnixfsReader, keepAtLeast uint64) (sectorID uint64, err error) {
+func (st *SectorBlocks) AddUnixfsPiece(ref cid.Cid, r UnixfsReader, keepAtLeast uint64, priority int) (sectorID uint64, err error) {
 	size, err := r.Size()
 	if err != nil {
 		return 0, err
@@ -161,12 +214,148 @@ func (st *SectorBlocks) AddUnixfsPiece(ref cid.Cid, r UnixfsReader, keepAtLeast
 
 	refst := &refStorer{
 		blockReader:  r,
-		pieceRef:     string(SerializationUnixfs0) + ref.String(),
+		pieceRef:     PieceKey(SerializationUnixfs0, ref),
 		writeRef:     st.writeRef,
 		intermediate: st.intermediate,
 	}
 
-	return st.Store.AddPiece(refst.pieceRef, uint64(size), refst)
+	sectorID, err = st.Store.AddPiece(refst.pieceRef, uint64(size), refst, priority)
+	if err != nil {
+		return 0, err
+	}
+	if err := st.putPieceSector(refst.pieceRef, sectorID); err != nil {
+		return 0, err
+	}
+
+	return sectorID, st.putPieceInfo(ref, PieceInfo{
+		Serialization: SerializationUnixfs0,
+		Size:          uint64(size),
+	})
+}
+
+// AddDagPiece adds a piece with the DAG under ref stored in raw or IPLD mode.
+// In raw mode intermediate nodes are kept outside of the piece
+func (st *SectorBlocks) AddDagPiece(ctx context.Context, ref cid.Cid, s SealSerialization, ng ipld.NodeGetter, keepAtLeast uint64, priority int) (sectorID uint64, err error) {
+	p, err := writeDagPiece(ctx, ng, ref, s, ioutil.Discard, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	pieceRef := PieceKey(s, ref)
+	r := dagPieceReader(ctx, ng, ref, s, func(nd ipld.Node, offset uint64) error {
+		size := TransferSize(s, nd)
+		if size == 0 {
+			// TODO: batch
+			// TODO: GC
+			return st.intermediate.Put(nd)
+		}
+
+		return st.writeRef(nd.Cid(), pieceRef, offset, uint32(size))
+	})
+	defer r.Close() // stops the writer if AddPiece doesn't read everything
+
+	sectorID, err = st.Store.AddPiece(pieceRef, p.pieceSize, r, priority)
+	if err != nil {
+		return 0, err
+	}
+	if err := st.putPieceSector(pieceRef, sectorID); err != nil {
+		return 0, err
+	}
+
+	return sectorID, st.putPieceInfo(ref, PieceInfo{
+		Serialization: s,
+		Size:          p.transferSize,
+	})
+}
+
+func (st *SectorBlocks) putPieceInfo(ref cid.Cid, info PieceInfo) error {
+	b, err := cbor.DumpObject(&info)
+	if err != nil {
+		return err
+	}
+	return st.pieces.Put(dshelp.CidToDsKey(ref), b)
+}
+
+func (st *SectorBlocks) putPieceSector(pieceKey string, sectorID uint64) error {
+	return st.sectors.Put(datastore.NewKey(pieceKey), []byte(strconv.FormatUint(sectorID, 10)))
+}
+
+// PieceSector returns the sector a piece was last added to, or ErrNotFound
+func (st *SectorBlocks) PieceSector(pieceKey string) (uint64, error) {
+	b, err := st.sectors.Get(datastore.NewKey(pieceKey))
+	if err == datastore.ErrNotFound {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(b), 10, 64)
+}
+
+// GetPieceInfo returns information about a DAG stored in a piece
+func (st *SectorBlocks) GetPieceInfo(ref cid.Cid) (PieceInfo, error) {
+	b, err := st.pieces.Get(dshelp.CidToDsKey(ref))
+	switch err {
+	case nil:
+		var info PieceInfo
+		if err := cbor.DecodeInto(b, &info); err != nil {
+			return PieceInfo{}, err
+		}
+		return info, nil
+	case datastore.ErrNotFound:
+		// pieces added before piece info was tracked were all unixfs
+		size, err := st.GetSize(ref)
+		if err != nil {
+			return PieceInfo{}, err
+		}
+
+		return PieceInfo{
+			Serialization: SerializationUnixfs0,
+			Size:          size,
+		}, nil
+	default:
+		return PieceInfo{}, err
+	}
+}
+
+// ListPieces returns roots of DAGs stored in pieces. Pieces added before piece
+// info was tracked aren't listed
+func (st *SectorBlocks) ListPieces() ([]cid.Cid, error) {
+	res, err := st.pieces.Query(query.Query{KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	ents, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]cid.Cid, len(ents))
+	for i, ent := range ents {
+		out[i], err = dshelp.DsKeyToCid(datastore.RawKey(ent.Key))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// OnUnsealedRemoved registers a callback called with keys of pieces which are
+// no longer unsealed
+func (st *SectorBlocks) OnUnsealedRemoved(cb func(pieceKey string)) {
+	st.unsealed.cache.OnRemove(cb)
+}
+
+// IsUnsealed returns true if the piece with the DAG under ref is currently
+// unsealed, so reading it doesn't need unsealing
+func (st *SectorBlocks) IsUnsealed(ref cid.Cid) (bool, error) {
+	info, err := st.GetPieceInfo(ref)
+	if err != nil {
+		return false, err
+	}
+
+	return st.unsealed.isUnsealed(PieceKey(info.Serialization, ref)), nil
 }
 
 func (st *SectorBlocks) List() (map[cid.Cid][]api.SealedRef, error) {
@@ -247,7 +436,10 @@ func (st *SectorBlocks) Has(k cid.Cid) (bool, error) {
 	return st.keys.Has(dshelp.CidToDsKey(k))
 }
 
-func (st *SectorBlocks) SealedBlockstore(approveUnseal func() error) *SectorBlockStore {
+// SealedBlockstore returns a blockstore reading blocks from sealed sectors.
+// approveUnseal is called with the key of each piece which has to be unsealed
+// first, reads fail when it returns an error
+func (st *SectorBlocks) SealedBlockstore(approveUnseal func(pieceKey string) error) *SectorBlockStore {
 	return &SectorBlockStore{
 		intermediate:  st.intermediate,
 		sectorBlocks:  st,
@@ -0,0 +1,28 @@
+package sectorblocks
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPieceSector(t *testing.T) {
+	st := NewSectorBlocks(nil, datastore.NewMapDatastore(), nil, nil)
+
+	_, err := st.PieceSector("uQmPiece")
+	require.Equal(t, ErrNotFound, err)
+
+	require.NoError(t, st.putPieceSector("uQmPiece", 3))
+	require.NoError(t, st.putPieceSector("rQmPiece", 5))
+
+	id, err := st.PieceSector("uQmPiece")
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), id)
+
+	// pieces added again are looked up in the last sector
+	require.NoError(t, st.putPieceSector("uQmPiece", 7))
+	id, err = st.PieceSector("uQmPiece")
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), id)
+}
@@ -11,7 +11,7 @@ type SectorBlockStore struct {
 	intermediate blockstore.Blockstore
 	sectorBlocks *SectorBlocks
 
-	approveUnseal func() error
+	approveUnseal func(pieceKey string) error
 }
 
 func (s *SectorBlockStore) DeleteBlock(cid.Cid) error {
@@ -0,0 +1,281 @@
+package sectorblocks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-car"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	ipld "github.com/ipfs/go-ipld-format"
+	"golang.org/x/xerrors"
+)
+
+func init() {
+	// needed to decode nodes of generic IPLD DAGs
+	ipld.Register(cid.DagCBOR, cbor.DecodeBlock)
+}
+
+// DAGWalker iterates over blocks of a DAG in depth-first order, which is the
+// order blocks are stored in raw and IPLD pieces
+type DAGWalker struct {
+	ng    ipld.NodeGetter
+	stack []cid.Cid
+	seen  *cid.Set // nil if repeated blocks should be visited again
+}
+
+func NewDAGWalker(ng ipld.NodeGetter, root cid.Cid, dedup bool) *DAGWalker {
+	w := &DAGWalker{
+		ng:    ng,
+		stack: []cid.Cid{root},
+	}
+	if dedup {
+		w.seen = cid.NewSet()
+	}
+	return w
+}
+
+// NextCid returns the CID of the next block to visit, or io.EOF when the
+// whole DAG was visited. Links of the returned block have to be added with
+// AddLinks before calling NextCid again
+func (w *DAGWalker) NextCid() (cid.Cid, error) {
+	for len(w.stack) > 0 {
+		c := w.stack[len(w.stack)-1]
+		w.stack = w.stack[:len(w.stack)-1]
+
+		if w.seen != nil && !w.seen.Visit(c) {
+			continue
+		}
+		return c, nil
+	}
+
+	return cid.Undef, io.EOF
+}
+
+// Done returns true when there are no more blocks to visit
+func (w *DAGWalker) Done() bool {
+	for len(w.stack) > 0 {
+		if w.seen == nil || !w.seen.Has(w.stack[len(w.stack)-1]) {
+			return false
+		}
+		w.stack = w.stack[:len(w.stack)-1]
+	}
+	return true
+}
+
+func (w *DAGWalker) AddLinks(nd ipld.Node) {
+	links := OrderedLinks(nd)
+	for i := len(links) - 1; i >= 0; i-- {
+		w.stack = append(w.stack, links[i].Cid)
+	}
+}
+
+// OrderedLinks returns links of a node in the order they are walked in. Both
+// sides of a transfer have to agree on it, and link order of decoded CBOR
+// nodes isn't stable, so those are sorted by their path in the node
+func OrderedLinks(nd ipld.Node) []*ipld.Link {
+	links := nd.Links()
+	if nd.Cid().Type() == cid.DagProtobuf {
+		// order of protobuf links is meaningful (e.g. unixfs file chunks)
+		return links
+	}
+
+	type pathLink struct {
+		path string
+		link *ipld.Link
+	}
+
+	// link names of CBOR nodes are empty, paths come from the node tree
+	var paths []pathLink
+	for _, p := range nd.Tree("", -1) {
+		lnk, rest, err := nd.ResolveLink(strings.Split(p, "/"))
+		if err != nil || len(rest) > 0 {
+			continue
+		}
+		paths = append(paths, pathLink{path: p, link: lnk})
+	}
+
+	if len(paths) != len(links) {
+		// the tree doesn't list all links, fall back to CID order
+		out := make([]*ipld.Link, len(links))
+		copy(out, links)
+		sort.SliceStable(out, func(i, j int) bool {
+			return bytes.Compare(out[i].Cid.Bytes(), out[j].Cid.Bytes()) < 0
+		})
+		return out
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		return pathLess(paths[i].path, paths[j].path)
+	})
+
+	out := make([]*ipld.Link, len(paths))
+	for i, pl := range paths {
+		out[i] = pl.link
+	}
+	return out
+}
+
+// pathLess compares paths segment by segment, numeric segments (list
+// indexes) are compared as numbers
+func pathLess(a, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+
+		ai, aerr := strconv.ParseUint(as[i], 10, 64)
+		bi, berr := strconv.ParseUint(bs[i], 10, 64)
+		if aerr == nil && berr == nil {
+			return ai < bi
+		}
+		return as[i] < bs[i]
+	}
+	return len(as) < len(bs)
+}
+
+// Next returns the next node of the DAG, or io.EOF after the last one
+func (w *DAGWalker) Next(ctx context.Context) (ipld.Node, error) {
+	c, err := w.NextCid()
+	if err != nil {
+		return nil, err
+	}
+
+	nd, err := w.ng.Get(ctx, c)
+	if err != nil {
+		return nil, xerrors.Errorf("getting DAG node %s: %w", c, err)
+	}
+
+	w.AddLinks(nd)
+	return nd, nil
+}
+
+// TransferSize returns how many bytes of a block are part of the data stored
+// in raw or IPLD mode
+func TransferSize(s SealSerialization, nd ipld.Node) uint64 {
+	if s == SerializationRaw && nd.Cid().Type() != cid.Raw {
+		// intermediate node
+		return 0
+	}
+	return uint64(len(nd.RawData()))
+}
+
+// CarHeader returns the header written at the start of IPLD pieces
+func CarHeader(root cid.Cid) ([]byte, error) {
+	return cbor.DumpObject(&car.CarHeader{
+		Roots:   []cid.Cid{root},
+		Version: 1,
+	})
+}
+
+// CarSection returns the prefix written before block data in IPLD pieces
+func CarSection(nd ipld.Node) []byte {
+	cb := nd.Cid().Bytes()
+
+	buf := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(cb))
+	n := binary.PutUvarint(buf, uint64(len(cb)+len(nd.RawData())))
+
+	return append(buf[:n], cb...)
+}
+
+type dagPiece struct {
+	pieceSize    uint64
+	transferSize uint64
+}
+
+// writeDagPiece serializes a DAG in raw or IPLD mode. onBlock is called for
+// each block with the offset its data was written at
+func writeDagPiece(ctx context.Context, ng ipld.NodeGetter, root cid.Cid, s SealSerialization, w io.Writer, onBlock func(nd ipld.Node, offset uint64) error) (dagPiece, error) {
+	var out dagPiece
+
+	write := func(b []byte) error {
+		_, err := w.Write(b)
+		out.pieceSize += uint64(len(b))
+		return err
+	}
+
+	switch s {
+	case SerializationRaw:
+	case SerializationIPLD:
+		hdr, err := CarHeader(root)
+		if err != nil {
+			return dagPiece{}, err
+		}
+
+		lb := make([]byte, binary.MaxVarintLen64)
+		if err := write(lb[:binary.PutUvarint(lb, uint64(len(hdr)))]); err != nil {
+			return dagPiece{}, err
+		}
+		if err := write(hdr); err != nil {
+			return dagPiece{}, err
+		}
+	default:
+		return dagPiece{}, xerrors.Errorf("serialization '%c' is not a DAG serialization", s)
+	}
+
+	walker := NewDAGWalker(ng, root, s == SerializationIPLD)
+	for {
+		nd, err := walker.Next(ctx)
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return dagPiece{}, err
+		}
+
+		size := TransferSize(s, nd)
+		if s == SerializationIPLD {
+			if err := write(CarSection(nd)); err != nil {
+				return dagPiece{}, err
+			}
+		}
+
+		if onBlock != nil {
+			if err := onBlock(nd, out.pieceSize); err != nil {
+				return dagPiece{}, err
+			}
+		}
+
+		if size == 0 {
+			continue
+		}
+
+		if err := write(nd.RawData()); err != nil {
+			return dagPiece{}, err
+		}
+		out.transferSize += size
+	}
+}
+
+// DagPieceReader returns a reader with a DAG serialized in raw or IPLD mode,
+// and the size of the serialized piece
+func DagPieceReader(ctx context.Context, ng ipld.NodeGetter, root cid.Cid, s SealSerialization) (io.ReadCloser, uint64, error) {
+	p, err := writeDagPiece(ctx, ng, root, s, ioutil.Discard, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return dagPieceReader(ctx, ng, root, s, nil), p.pieceSize, nil
+}
+
+func dagPieceReader(ctx context.Context, ng ipld.NodeGetter, root cid.Cid, s SealSerialization, onBlock func(nd ipld.Node, offset uint64) error) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		bw := bufio.NewWriter(pw)
+		_, err := writeDagPiece(ctx, ng, root, s, bw, onBlock)
+		if err == nil {
+			err = bw.Flush()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	return pr
+}
@@ -0,0 +1,107 @@
+package sectorblocks
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-car"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDagPiece(t *testing.T) {
+	ctx := context.Background()
+	dag := merkledag.NewDAGService(blockservice.New(blockstore.NewBlockstore(datastore.NewMapDatastore()), nil))
+
+	leafA := merkledag.NewRawNode([]byte("aaaa"))
+	leafB := merkledag.NewRawNode([]byte("bb"))
+
+	inner, err := cbor.WrapObject(map[string]interface{}{
+		"leaves": []cid.Cid{leafA.Cid(), leafB.Cid()},
+	}, mh.SHA2_256, -1)
+	require.NoError(t, err)
+
+	// leafA is linked twice
+	root, err := cbor.WrapObject(map[string]interface{}{
+		"a":     leafA.Cid(),
+		"inner": inner.Cid(),
+	}, mh.SHA2_256, -1)
+	require.NoError(t, err)
+
+	require.NoError(t, dag.AddMany(ctx, []ipld.Node{leafA, leafB, inner, root}))
+
+	// raw pieces have data of every leaf occurrence
+	var raw bytes.Buffer
+	p, err := writeDagPiece(ctx, dag, root.Cid(), SerializationRaw, &raw, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, bytes.Count(raw.Bytes(), []byte("aaaa")))
+	require.Equal(t, 1, bytes.Count(raw.Bytes(), []byte("bb")))
+	require.Equal(t, uint64(10), p.pieceSize)
+	require.Equal(t, uint64(10), p.transferSize)
+
+	// IPLD pieces are CAR files, with every block stored once
+	var ipldPiece bytes.Buffer
+	offsets := map[cid.Cid]uint64{}
+	p, err = writeDagPiece(ctx, dag, root.Cid(), SerializationIPLD, &ipldPiece, func(nd ipld.Node, offset uint64) error {
+		offsets[nd.Cid()] = offset
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(ipldPiece.Len()), p.pieceSize)
+	require.Len(t, offsets, 4)
+
+	for c, offset := range offsets {
+		nd, err := dag.Get(ctx, c)
+		require.NoError(t, err)
+		require.Equal(t, nd.RawData(), ipldPiece.Bytes()[offset:offset+uint64(len(nd.RawData()))])
+	}
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	hdr, err := car.LoadCar(bs, &ipldPiece)
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{root.Cid()}, hdr.Roots)
+
+	has, err := bs.Has(leafB.Cid())
+	require.NoError(t, err)
+	require.True(t, has)
+}
+
+func TestPathLess(t *testing.T) {
+	require.True(t, pathLess("a", "b"))
+	require.True(t, pathLess("leaves/2", "leaves/10"))
+	require.True(t, pathLess("a", "a/0"))
+	require.False(t, pathLess("b/0", "a/1"))
+}
+
+func TestOrderedLinks(t *testing.T) {
+	var leaves []cid.Cid
+	for i := 0; i < 12; i++ {
+		leaves = append(leaves, merkledag.NewRawNode([]byte{byte(i)}).Cid())
+	}
+	other := merkledag.NewRawNode([]byte("other")).Cid()
+
+	nd, err := cbor.WrapObject(map[string]interface{}{
+		"z":      other,
+		"leaves": leaves,
+		"a":      map[string]interface{}{"b": leaves[3]},
+	}, mh.SHA2_256, -1)
+	require.NoError(t, err)
+
+	// links are ordered by their path, list indexes as numbers
+	expected := append([]cid.Cid{leaves[3]}, leaves...)
+	expected = append(expected, other)
+
+	var ordered []cid.Cid
+	for _, l := range OrderedLinks(nd) {
+		ordered = append(ordered, l.Cid)
+	}
+	require.Equal(t, expected, ordered)
+}
@@ -2,98 +2,40 @@ package sectorblocks
 
 import (
 	"context"
-	"sync"
 
 	logging "github.com/ipfs/go-log"
 
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/lib/sectorbuilder"
+	"github.com/filecoin-project/lotus/storage/unsealed"
 )
 
 var log = logging.Logger("sectorblocks")
 
 type unsealedBlocks struct {
-	lk sync.Mutex
-	sb *sectorbuilder.SectorBuilder
-
-	// TODO: Treat this as some sort of cache, one with rather aggressive GC
-	// TODO: This REALLY, REALLY needs to be on-disk
-	unsealed map[string][]byte
-
-	unsealing map[string]chan struct{}
+	sb    *sectorbuilder.SectorBuilder
+	cache *unsealed.Cache
 }
 
-func (ub *unsealedBlocks) getRef(ctx context.Context, refs []api.SealedRef, approveUnseal func() error) ([]byte, error) {
-	var best api.SealedRef
-
-	ub.lk.Lock()
+func (ub *unsealedBlocks) getRef(ctx context.Context, refs []api.SealedRef, approveUnseal func(pieceKey string) error) ([]byte, error) {
+	// prefer pieces which don't need unsealing
+	best := refs[0]
 	for _, ref := range refs {
-		b, ok := ub.unsealed[ref.Piece]
-		if ok {
-			ub.lk.Unlock()
-			return b[ref.Offset : ref.Offset+uint64(ref.Size)], nil
-		}
-		// TODO: pick unsealing based on how long it's running (or just select all relevant, usually it'll be just one)
-		_, ok = ub.unsealing[ref.Piece]
-		if ok {
+		if ub.cache.Has(ref.Piece) {
 			best = ref
 			break
 		}
-		best = ref
 	}
-	ub.lk.Unlock()
 
-	b, err := ub.maybeUnseal(ctx, best.Piece, approveUnseal)
-	if err != nil {
-		return nil, err
+	approve := func() error {
+		return approveUnseal(best.Piece)
 	}
 
-	return b[best.Offset : best.Offset+uint64(best.Size)], nil
+	return ub.cache.Read(ctx, best.Piece, best.Offset, uint64(best.Size), approve, func() ([]byte, error) {
+		return ub.sb.ReadPieceFromSealedSector(best.Piece)
+	})
 }
 
-func (ub *unsealedBlocks) maybeUnseal(ctx context.Context, pieceKey string, approveUnseal func() error) ([]byte, error) {
-	ub.lk.Lock()
-	defer ub.lk.Unlock()
-
-	out, ok := ub.unsealed[pieceKey]
-	if ok {
-		return out, nil
-	}
-
-	wait, ok := ub.unsealing[pieceKey]
-	if ok {
-		ub.lk.Unlock()
-		select {
-		case <-wait:
-			ub.lk.Lock()
-			// TODO: make sure this is not racy with gc when it's implemented
-			return ub.unsealed[pieceKey], nil
-		case <-ctx.Done():
-			ub.lk.Lock()
-			return nil, ctx.Err()
-		}
-	}
-
-	// TODO: doing this under a lock is suboptimal.. but simpler
-	err := approveUnseal()
-	if err != nil {
-		return nil, err
-	}
-
-	ub.unsealing[pieceKey] = make(chan struct{})
-	ub.lk.Unlock()
-
-	log.Infof("Unsealing piece '%s'", pieceKey)
-	data, err := ub.sb.ReadPieceFromSealedSector(pieceKey)
-	ub.lk.Lock()
-
-	if err != nil {
-		// TODO: tell subs
-		log.Error(err)
-		return nil, err
-	}
-
-	ub.unsealed[pieceKey] = data
-	close(ub.unsealing[pieceKey])
-	return data, nil
+func (ub *unsealedBlocks) isUnsealed(pieceKey string) bool {
+	return ub.cache.Has(pieceKey)
 }
@@ -0,0 +1,370 @@
+package unsealed
+
+import (
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+)
+
+var log = logging.Logger("unsealed")
+
+const tmpPrefix = ".tmp-"
+
+// touchInterval limits how often access times of cached pieces are written
+// to disk, they are only needed to keep the LRU order across restarts
+const touchInterval = time.Minute
+
+type entry struct {
+	key      string
+	size     uint64
+	lastUsed time.Time
+	touched  time.Time
+}
+
+type unsealing struct {
+	done chan struct{}
+	err  error
+}
+
+// Cache keeps unsealed pieces on disk, so repeated reads of a piece don't
+// need unsealing it again. When the cache grows over maxBytes, least recently
+// used pieces are removed
+type Cache struct {
+	lk sync.Mutex
+
+	dir      string
+	maxBytes uint64
+
+	entries   map[string]*entry
+	size      uint64
+	unsealing map[string]*unsealing
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+
+	onRemove []func(key string)
+}
+
+// New opens the cache in dir, picking up pieces cached by earlier runs
+func New(dir string, maxBytes uint64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, xerrors.Errorf("creating unsealed cache dir: %w", err)
+	}
+
+	c := &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+
+		entries:   map[string]*entry{},
+		unsealing: map[string]*unsealing{},
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, xerrors.Errorf("listing unsealed cache dir: %w", err)
+	}
+
+	for _, fi := range files {
+		if strings.HasPrefix(fi.Name(), tmpPrefix) {
+			// interrupted write
+			if err := os.Remove(filepath.Join(dir, fi.Name())); err != nil {
+				log.Warnf("removing unfinished unsealed piece: %s", err)
+			}
+			continue
+		}
+
+		key, err := hex.DecodeString(fi.Name())
+		if err != nil {
+			log.Warnf("unexpected file in unsealed cache dir: %s", fi.Name())
+			continue
+		}
+
+		c.entries[string(key)] = &entry{
+			key:      string(key),
+			size:     uint64(fi.Size()),
+			lastUsed: fi.ModTime(),
+			touched:  fi.ModTime(),
+		}
+		c.size += uint64(fi.Size())
+	}
+
+	c.lk.Lock()
+	c.evict(c.maxBytes, "")
+	c.lk.Unlock()
+
+	return c, nil
+}
+
+// OnRemove registers a callback called with keys of pieces removed from the
+// cache, and of pieces which didn't get cached after unsealing them. It's
+// called with the cache lock held, so it can't use the cache
+func (c *Cache) OnRemove(cb func(key string)) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	c.onRemove = append(c.onRemove, cb)
+}
+
+// removed notifies callbacks of a removed piece, must be called with lk held
+func (c *Cache) removed(key string) {
+	for _, cb := range c.onRemove {
+		cb(key)
+	}
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, hex.EncodeToString([]byte(key)))
+}
+
+// Has returns true if the piece is cached
+func (c *Cache) Has(key string) bool {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	_, ok := c.entries[key]
+	return ok
+}
+
+// Read reads size bytes at offset of the piece. Pieces which aren't cached
+// are unsealed with unseal and added to the cache, concurrent reads of the
+// same piece wait for a single unseal. Each reader which would unseal the
+// piece has to be allowed to by approve, readers waiting for an unseal
+// started by someone else don't
+func (c *Cache) Read(ctx context.Context, key string, offset uint64, size uint64, approve func() error, unseal func() ([]byte, error)) ([]byte, error) {
+	approved := false
+
+	c.lk.Lock()
+	for {
+		if _, ok := c.entries[key]; ok {
+			c.hits++
+			c.use(key)
+
+			// the file is opened before unlocking, so it can't get evicted
+			// before it's read
+			f, err := os.Open(c.path(key))
+			c.lk.Unlock()
+			if err != nil {
+				return nil, xerrors.Errorf("opening unsealed piece: %w", err)
+			}
+			return readFile(f, offset, size)
+		}
+
+		u, ok := c.unsealing[key]
+		if !ok {
+			if approved {
+				break
+			}
+
+			// approval errors are returned only to this reader, the
+			// piece may have been cached or another reader may have
+			// started unsealing it by the time we get the lock back
+			c.lk.Unlock()
+			if err := approve(); err != nil {
+				return nil, err
+			}
+			approved = true
+			c.lk.Lock()
+			continue
+		}
+
+		c.lk.Unlock()
+		select {
+		case <-u.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if u.err != nil {
+			return nil, u.err
+		}
+		c.lk.Lock()
+	}
+
+	c.misses++
+	u := &unsealing{done: make(chan struct{})}
+	c.unsealing[key] = u
+	c.lk.Unlock()
+
+	data, err := c.unseal(key, unseal)
+
+	c.lk.Lock()
+	delete(c.unsealing, key)
+	u.err = err
+	close(u.done)
+	if err != nil {
+		c.removed(key)
+	}
+	c.lk.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	if offset+size > uint64(len(data)) {
+		return nil, xerrors.Errorf("reading %d bytes at %d past the end of piece (%d bytes)", size, offset, len(data))
+	}
+	return data[offset : offset+size], nil
+}
+
+func (c *Cache) unseal(key string, unseal func() ([]byte, error)) ([]byte, error) {
+	log.Infof("Unsealing piece '%s'", key)
+	data, err := unseal()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.write(key, data); err != nil {
+		// the piece can still be read this time
+		log.Errorf("caching unsealed piece '%s': %s", key, err)
+
+		c.lk.Lock()
+		c.removed(key)
+		c.lk.Unlock()
+		return data, nil
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	now := time.Now()
+	c.entries[key] = &entry{
+		key:      key,
+		size:     uint64(len(data)),
+		lastUsed: now,
+		touched:  now,
+	}
+	c.size += uint64(len(data))
+
+	// the new piece is kept even when it's over the limit by itself
+	c.evict(c.maxBytes, key)
+
+	return data, nil
+}
+
+func (c *Cache) write(key string, data []byte) error {
+	tmp := filepath.Join(c.dir, tmpPrefix+hex.EncodeToString([]byte(key)))
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, c.path(key)); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func readFile(f *os.File, offset uint64, size uint64) ([]byte, error) {
+	defer f.Close()
+
+	out := make([]byte, size)
+	if _, err := f.ReadAt(out, int64(offset)); err != nil {
+		return nil, xerrors.Errorf("reading unsealed piece: %w", err)
+	}
+	return out, nil
+}
+
+// use marks the piece as used, must be called with lk held
+func (c *Cache) use(key string) {
+	e := c.entries[key]
+
+	now := time.Now()
+	e.lastUsed = now
+	if now.Sub(e.touched) < touchInterval {
+		return
+	}
+
+	e.touched = now
+	if err := os.Chtimes(c.path(key), now, now); err != nil {
+		log.Warnf("updating unsealed piece access time: %s", err)
+	}
+}
+
+// evict removes least recently used pieces until the cache is at most target
+// bytes, skipping the keep piece. Must be called with lk held
+func (c *Cache) evict(target uint64, keep string) (removed int, freed uint64) {
+	if c.size <= target {
+		return 0, 0
+	}
+
+	lru := make([]*entry, 0, len(c.entries))
+	for _, e := range c.entries {
+		if e.key != keep {
+			lru = append(lru, e)
+		}
+	}
+	sort.Slice(lru, func(i, j int) bool {
+		return lru[i].lastUsed.Before(lru[j].lastUsed)
+	})
+
+	for _, e := range lru {
+		if c.size <= target {
+			break
+		}
+
+		// readers which already opened the file can finish reading it
+		if err := os.Remove(c.path(e.key)); err != nil && !os.IsNotExist(err) {
+			log.Errorf("removing unsealed piece '%s': %s", e.key, err)
+			continue
+		}
+
+		delete(c.entries, e.key)
+		c.size -= e.size
+		c.evictions++
+		c.removed(e.key)
+
+		removed++
+		freed += e.size
+	}
+
+	return removed, freed
+}
+
+// GC removes least recently used pieces until the cache is at most keep
+// bytes
+func (c *Cache) GC(keep uint64) (removed int, freed uint64) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	return c.evict(keep, "")
+}
+
+// Info lists cached pieces, most recently used first, with cache statistics
+func (c *Cache) Info() api.UnsealedCacheInfo {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	out := api.UnsealedCacheInfo{
+		Size:    c.size,
+		MaxSize: c.maxBytes,
+
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+
+		Pieces: make([]api.UnsealedPiece, 0, len(c.entries)),
+	}
+
+	for _, e := range c.entries {
+		out.Pieces = append(out.Pieces, api.UnsealedPiece{
+			Key:      e.key,
+			Size:     e.size,
+			LastUsed: e.lastUsed,
+		})
+	}
+	sort.Slice(out.Pieces, func(i, j int) bool {
+		return out.Pieces[i].LastUsed.After(out.Pieces[j].LastUsed)
+	})
+
+	return out
+}
@@ -0,0 +1,228 @@
+package unsealed
+
+import (
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func approve() error {
+	return nil
+}
+
+func testCache(t *testing.T, maxBytes uint64) (*Cache, string) {
+	dir, err := ioutil.TempDir("", "unsealed-cache")
+	require.NoError(t, err)
+
+	c, err := New(dir, maxBytes)
+	require.NoError(t, err)
+	return c, dir
+}
+
+// read reads the whole piece, unsealing it to data if it isn't cached
+func read(t *testing.T, c *Cache, key string, data []byte) []byte {
+	out, err := c.Read(context.Background(), key, 0, uint64(len(data)), approve, func() ([]byte, error) {
+		return data, nil
+	})
+	require.NoError(t, err)
+	return out
+}
+
+func cachedKeys(c *Cache) []string {
+	var out []string
+	for _, p := range c.Info().Pieces {
+		out = append(out, p.Key)
+	}
+	return out
+}
+
+func TestCacheRead(t *testing.T) {
+	c, dir := testCache(t, 100)
+	defer os.RemoveAll(dir)
+
+	unseals := 0
+	unseal := func() ([]byte, error) {
+		unseals++
+		return []byte("0123456789"), nil
+	}
+
+	out, err := c.Read(context.Background(), "a", 2, 3, approve, unseal)
+	require.NoError(t, err)
+	require.Equal(t, []byte("234"), out)
+	require.True(t, c.Has("a"))
+
+	out, err = c.Read(context.Background(), "a", 5, 5, approve, unseal)
+	require.NoError(t, err)
+	require.Equal(t, []byte("56789"), out)
+	require.Equal(t, 1, unseals)
+
+	info := c.Info()
+	require.Equal(t, uint64(1), info.Hits)
+	require.Equal(t, uint64(1), info.Misses)
+	require.Equal(t, uint64(10), info.Size)
+
+	_, err = c.Read(context.Background(), "b", 8, 5, approve, unseal)
+	require.Error(t, err, "read past the end of the piece")
+}
+
+func TestCacheApproval(t *testing.T) {
+	c, dir := testCache(t, 100)
+	defer os.RemoveAll(dir)
+
+	// readers which aren't allowed to unseal don't unseal
+	_, err := c.Read(context.Background(), "a", 0, 4, func() error {
+		return xerrors.New("not paid")
+	}, func() ([]byte, error) {
+		t.Fatal("unsealed without approval")
+		return nil, nil
+	})
+	require.Error(t, err)
+	require.False(t, c.Has("a"))
+
+	// and don't fail other readers waiting for the piece
+	unsealing := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := c.Read(context.Background(), "a", 0, 4, approve, func() ([]byte, error) {
+			close(unsealing)
+			<-release
+			return []byte("data"), nil
+		})
+		done <- err
+	}()
+	<-unsealing
+
+	waiting := make(chan []byte)
+	go func() {
+		out, err := c.Read(context.Background(), "a", 0, 4, func() error {
+			return xerrors.New("not paid")
+		}, func() ([]byte, error) {
+			return nil, xerrors.New("unsealed twice")
+		})
+		require.NoError(t, err)
+		waiting <- out
+	}()
+
+	close(release)
+	require.NoError(t, <-done)
+	require.Equal(t, []byte("data"), <-waiting)
+}
+
+func TestCacheUnsealError(t *testing.T) {
+	c, dir := testCache(t, 100)
+	defer os.RemoveAll(dir)
+
+	_, err := c.Read(context.Background(), "a", 0, 4, approve, func() ([]byte, error) {
+		return nil, xerrors.New("unseal failed")
+	})
+	require.Error(t, err)
+	require.False(t, c.Has("a"))
+
+	// failed unseals aren't cached
+	require.Equal(t, []byte("data"), read(t, c, "a", []byte("data")))
+}
+
+func TestCacheEvict(t *testing.T) {
+	c, dir := testCache(t, 10)
+	defer os.RemoveAll(dir)
+
+	read(t, c, "a", []byte("aaaa"))
+	read(t, c, "b", []byte("bbbb"))
+	read(t, c, "a", []byte("aaaa"))
+
+	// b is the least recently used
+	read(t, c, "c", []byte("cccc"))
+	require.Equal(t, []string{"c", "a"}, cachedKeys(c))
+	require.Equal(t, uint64(8), c.Info().Size)
+	require.Equal(t, uint64(1), c.Info().Evictions)
+
+	_, err := os.Stat(filepath.Join(dir, hex.EncodeToString([]byte("b"))))
+	require.True(t, os.IsNotExist(err))
+
+	// pieces larger than the cache are kept until the next one is added
+	read(t, c, "d", []byte("dddddddddddd"))
+	require.Equal(t, []string{"d"}, cachedKeys(c))
+
+	read(t, c, "e", []byte("ee"))
+	require.Equal(t, []string{"e"}, cachedKeys(c))
+
+	read(t, c, "f", []byte("ff"))
+	removed, freed := c.GC(2)
+	require.Equal(t, 1, removed)
+	require.Equal(t, uint64(2), freed)
+	require.Equal(t, []string{"f"}, cachedKeys(c))
+}
+
+func TestCacheRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unsealed-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name string, data string, used time.Time) {
+		p := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(p, []byte(data), 0644))
+		require.NoError(t, os.Chtimes(p, used, used))
+	}
+
+	now := time.Now()
+	write(hex.EncodeToString([]byte("old")), "oooo", now.Add(-3*time.Hour))
+	write(hex.EncodeToString([]byte("mid")), "mmmm", now.Add(-2*time.Hour))
+	write(hex.EncodeToString([]byte("new")), "nnnn", now.Add(-time.Hour))
+	write(tmpPrefix+hex.EncodeToString([]byte("partial")), "pp", now)
+	write("not-hex", "x", now)
+
+	// the cache shrank since the last run
+	c, err := New(dir, 8)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"new", "mid"}, cachedKeys(c))
+	require.Equal(t, uint64(8), c.Info().Size)
+
+	_, err = os.Stat(filepath.Join(dir, tmpPrefix+hex.EncodeToString([]byte("partial"))))
+	require.True(t, os.IsNotExist(err), "unfinished writes are removed")
+	_, err = os.Stat(filepath.Join(dir, "not-hex"))
+	require.NoError(t, err, "unknown files are left alone")
+
+	// cached pieces are read without unsealing
+	out, err := c.Read(context.Background(), "mid", 1, 2, approve, func() ([]byte, error) {
+		return nil, xerrors.New("piece should be cached")
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte("mm"), out)
+}
+
+func TestCacheOnRemove(t *testing.T) {
+	c, dir := testCache(t, 8)
+	defer os.RemoveAll(dir)
+
+	var removed []string
+	c.OnRemove(func(key string) {
+		removed = append(removed, key)
+	})
+
+	read(t, c, "a", []byte("aaaa"))
+	read(t, c, "b", []byte("bbbb"))
+	require.Empty(t, removed)
+
+	// evicted pieces
+	read(t, c, "c", []byte("cccc"))
+	require.Equal(t, []string{"a"}, removed)
+
+	// and pieces which failed to unseal
+	_, err := c.Read(context.Background(), "d", 0, 4, approve, func() ([]byte, error) {
+		return nil, xerrors.New("unseal failed")
+	})
+	require.Error(t, err)
+	require.Equal(t, []string{"a", "d"}, removed)
+
+	c.GC(0)
+	require.ElementsMatch(t, []string{"a", "d", "b", "c"}, removed)
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+const voucherCheckInterval = time.Minute
+
+// voucherResubmitTimeout is how long to wait for a submitted voucher to land
+// on chain before sending it again
+const voucherResubmitTimeout = 10 * time.Minute
+
+// RedeemPolicy controls when payment vouchers received in storage deals get
+// redeemed on chain
+type RedeemPolicy struct {
+	// Threshold is the unredeemed value on a payment channel lane above
+	// which the best spendable voucher gets submitted. Nil means vouchers
+	// are only submitted before channel deadlines
+	Threshold types.BigInt
+
+	// DeadlineMargin is how many blocks before a channel can be closed
+	// vouchers get submitted
+	DeadlineMargin uint64
+}
+
+type laneKey struct {
+	ch   address.Address
+	lane uint64
+}
+
+type submittedVoucher struct {
+	nonce uint64
+	at    time.Time
+}
+
+func (m *Miner) runVoucherRedeemer(ctx context.Context) {
+	tick := time.NewTicker(voucherCheckInterval)
+	defer tick.Stop()
+
+	submitted := map[laneKey]submittedVoucher{}
+
+	for {
+		select {
+		case <-tick.C:
+			if err := m.redeemVouchers(ctx, submitted); err != nil {
+				log.Errorf("redeeming vouchers: %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// redeemVouchers submits the best spendable vouchers of payment channels
+// paying the miner. Channels which are closing are settled by the payment
+// channel watcher
+func (m *Miner) redeemVouchers(ctx context.Context, submitted map[laneKey]submittedVoucher) error {
+	head, err := m.api.ChainHead(ctx)
+	if err != nil {
+		return err
+	}
+
+	chans, err := m.api.PaychList(ctx)
+	if err != nil {
+		return xerrors.Errorf("listing payment channels: %w", err)
+	}
+
+	for _, ch := range chans {
+		st, err := m.api.PaychStatus(ctx, ch)
+		if err != nil {
+			log.Errorf("getting payment channel %s status: %s", ch, err)
+			continue
+		}
+		if st.Direction != api.PCHInbound || st.ControlAddr != m.maddr {
+			continue
+		}
+
+		if err := m.redeemChannel(ctx, ch, head.Height(), submitted); err != nil {
+			log.Errorf("redeeming vouchers from channel %s: %s", ch, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *Miner) redeemChannel(ctx context.Context, ch address.Address, h uint64, submitted map[laneKey]submittedVoucher) error {
+	act, err := m.api.StateGetActor(ctx, ch, nil)
+	if err != nil {
+		return err
+	}
+	if act.Balance.Sign() == 0 {
+		// nothing left to redeem, or already collected
+		return nil
+	}
+
+	stb, err := m.api.ChainReadObj(ctx, act.Head)
+	if err != nil {
+		return err
+	}
+	var pcs actors.PaymentChannelActorState
+	if err := pcs.UnmarshalCBOR(bytes.NewReader(stb)); err != nil {
+		return xerrors.Errorf("decoding channel state: %w", err)
+	}
+
+	if pcs.ClosingAt != 0 {
+		// the watcher submits the best vouchers and collects the funds
+		return nil
+	}
+
+	deadline := pcs.MinCloseHeight
+	urgent := deadline != 0 && h+m.cfg.Redeem.DeadlineMargin >= deadline
+
+	vouchers, err := m.api.PaychVoucherList(ctx, ch)
+	if err != nil {
+		return xerrors.Errorf("listing vouchers: %w", err)
+	}
+
+	byLane := map[uint64][]*types.SignedVoucher{}
+	for _, v := range vouchers {
+		byLane[v.Lane] = append(byLane[v.Lane], v)
+	}
+
+	for lane, vs := range byLane {
+		key := laneKey{ch: ch, lane: lane}
+
+		redeemed := types.NewInt(0)
+		if ls, ok := pcs.LaneStates[fmt.Sprint(lane)]; ok {
+			redeemed = ls.Redeemed
+		}
+
+		sort.Slice(vs, func(i, j int) bool {
+			return vs[i].Amount.GreaterThan(vs[j].Amount)
+		})
+
+		for _, v := range vs {
+			if !v.Amount.GreaterThan(redeemed) {
+				// no better voucher on this lane
+				break
+			}
+
+			spendable, err := m.api.PaychVoucherCheckSpendable(ctx, ch, v, nil, nil)
+			if err != nil {
+				return xerrors.Errorf("checking voucher: %w", err)
+			}
+			if !spendable {
+				continue
+			}
+
+			if s, ok := submitted[key]; ok && s.nonce >= v.Nonce && time.Since(s.at) < voucherResubmitTimeout {
+				// waiting for the voucher to land on chain
+				break
+			}
+
+			value := types.BigSub(v.Amount, redeemed)
+			if !urgent && (m.cfg.Redeem.Threshold.Nil() || value.LessThan(m.cfg.Redeem.Threshold)) {
+				break
+			}
+
+			mcid, err := m.api.PaychVoucherSubmit(ctx, ch, v)
+			if err != nil {
+				return xerrors.Errorf("submitting voucher: %w", err)
+			}
+			log.Infof("redeeming %s from channel %s lane %d in message %s", value, ch, lane, mcid)
+
+			submitted[key] = submittedVoucher{nonce: v.Nonce, at: time.Now()}
+			break
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/address"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type redeemApi struct {
+	storageMinerApi
+
+	t *testing.T
+
+	balance  types.BigInt
+	state    actors.PaymentChannelActorState
+	vouchers []*types.SignedVoucher
+
+	unspendable map[uint64]bool // by voucher nonce
+
+	submitted []*types.SignedVoucher
+	pushed    []*types.Message
+}
+
+var testChannelHead = func() cid.Cid {
+	c, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: mh.SHA2_256}.Sum([]byte("channel state"))
+	if err != nil {
+		panic(err)
+	}
+	return c
+}()
+
+func (ra *redeemApi) StateGetActor(ctx context.Context, a address.Address, ts *types.TipSet) (*types.Actor, error) {
+	return &types.Actor{Head: testChannelHead, Balance: ra.balance}, nil
+}
+
+func (ra *redeemApi) ChainReadObj(ctx context.Context, c cid.Cid) ([]byte, error) {
+	require.Equal(ra.t, testChannelHead, c)
+	var buf bytes.Buffer
+	if err := ra.state.MarshalCBOR(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (ra *redeemApi) PaychVoucherList(ctx context.Context, ch address.Address) ([]*types.SignedVoucher, error) {
+	return ra.vouchers, nil
+}
+
+func (ra *redeemApi) PaychVoucherCheckSpendable(ctx context.Context, ch address.Address, sv *types.SignedVoucher, secret []byte, proof []byte) (bool, error) {
+	return !ra.unspendable[sv.Nonce], nil
+}
+
+func (ra *redeemApi) PaychVoucherSubmit(ctx context.Context, ch address.Address, sv *types.SignedVoucher) (cid.Cid, error) {
+	ra.submitted = append(ra.submitted, sv)
+	return testChannelHead, nil
+}
+
+func (ra *redeemApi) MpoolPushMessage(ctx context.Context, msg *types.Message) (*types.SignedMessage, error) {
+	ra.pushed = append(ra.pushed, msg)
+	return &types.SignedMessage{Message: *msg}, nil
+}
+
+func testRedeemer(t *testing.T, policy RedeemPolicy) (*Miner, *redeemApi, address.Address) {
+	ch, err := address.NewIDAddress(200)
+	require.NoError(t, err)
+	worker, err := address.NewIDAddress(300)
+	require.NoError(t, err)
+	from, err := address.NewIDAddress(400)
+	require.NoError(t, err)
+
+	ra := &redeemApi{
+		t:       t,
+		balance: types.NewInt(1000),
+		state: actors.PaymentChannelActorState{
+			From:   from,
+			To:     worker,
+			ToSend: types.NewInt(0),
+			LaneStates: map[string]*actors.LaneState{
+				"0": {Redeemed: types.NewInt(100), Nonce: 1},
+			},
+		},
+		unspendable: map[uint64]bool{},
+	}
+
+	return &Miner{
+		api:    ra,
+		worker: worker,
+		cfg:    Config{Redeem: policy},
+	}, ra, ch
+}
+
+func voucher(lane uint64, nonce uint64, amount uint64) *types.SignedVoucher {
+	return &types.SignedVoucher{Lane: lane, Nonce: nonce, Amount: types.NewInt(amount)}
+}
+
+func TestRedeemAboveThreshold(t *testing.T) {
+	ctx := context.Background()
+	m, ra, ch := testRedeemer(t, RedeemPolicy{Threshold: types.NewInt(200), DeadlineMargin: 10})
+
+	submitted := map[laneKey]submittedVoucher{}
+
+	// 150 unredeemed on lane 0 is below the threshold
+	ra.vouchers = []*types.SignedVoucher{voucher(0, 2, 200), voucher(0, 3, 250)}
+	require.NoError(t, m.redeemChannel(ctx, ch, 50, submitted))
+	require.Empty(t, ra.submitted)
+
+	// the best voucher isn't spendable, the next one is above the threshold
+	ra.vouchers = append(ra.vouchers, voucher(0, 5, 400), voucher(0, 4, 350))
+	ra.unspendable[5] = true
+	require.NoError(t, m.redeemChannel(ctx, ch, 50, submitted))
+	require.Len(t, ra.submitted, 1)
+	require.Equal(t, uint64(4), ra.submitted[0].Nonce)
+
+	// the voucher isn't submitted again while waiting for it to land
+	require.NoError(t, m.redeemChannel(ctx, ch, 51, submitted))
+	require.Len(t, ra.submitted, 1)
+
+	// until it takes too long
+	submitted[laneKey{ch: ch, lane: 0}] = submittedVoucher{nonce: 4, at: time.Now().Add(-voucherResubmitTimeout)}
+	require.NoError(t, m.redeemChannel(ctx, ch, 52, submitted))
+	require.Len(t, ra.submitted, 2)
+}
+
+func TestRedeemBeforeDeadline(t *testing.T) {
+	ctx := context.Background()
+	m, ra, ch := testRedeemer(t, RedeemPolicy{DeadlineMargin: 10})
+
+	submitted := map[laneKey]submittedVoucher{}
+
+	ra.state.MinCloseHeight = 100
+	ra.vouchers = []*types.SignedVoucher{voucher(0, 2, 150), voucher(1, 1, 10), voucher(0, 1, 50)}
+
+	// without a threshold vouchers wait for the deadline
+	require.NoError(t, m.redeemChannel(ctx, ch, 50, submitted))
+	require.Empty(t, ra.submitted)
+
+	require.NoError(t, m.redeemChannel(ctx, ch, 90, submitted))
+	require.Len(t, ra.submitted, 2)
+
+	byLane := map[uint64]uint64{}
+	for _, sv := range ra.submitted {
+		byLane[sv.Lane] = sv.Amount.Uint64()
+	}
+	require.Equal(t, map[uint64]uint64{0: 150, 1: 10}, byLane)
+}
+
+func TestRedeemEmptyChannel(t *testing.T) {
+	ctx := context.Background()
+	m, ra, ch := testRedeemer(t, RedeemPolicy{Threshold: types.NewInt(1)})
+
+	ra.balance = types.NewInt(0)
+	ra.vouchers = []*types.SignedVoucher{voucher(0, 2, 500)}
+
+	require.NoError(t, m.redeemChannel(ctx, ch, 50, map[laneKey]submittedVoucher{}))
+	require.Empty(t, ra.submitted)
+}
+
+func TestRedeemSkipsClosingChannel(t *testing.T) {
+	ctx := context.Background()
+	m, ra, ch := testRedeemer(t, RedeemPolicy{Threshold: types.NewInt(1), DeadlineMargin: 10})
+
+	// the payment channel watcher settles closing channels
+	ra.state.ClosingAt = 60
+	ra.vouchers = []*types.SignedVoucher{voucher(0, 2, 500)}
+
+	require.NoError(t, m.redeemChannel(ctx, ch, 55, map[laneKey]submittedVoucher{}))
+	require.NoError(t, m.redeemChannel(ctx, ch, 70, map[laneKey]submittedVoucher{}))
+	require.Empty(t, ra.submitted)
+	require.Empty(t, ra.pushed)
+}